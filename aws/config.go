@@ -19,6 +19,10 @@ const DefaultMaxNetworkErrorRetries = 0
 // interface.
 type RequestRetryer interface{}
 
+// EndpointErrorClassifier is an alias for a type that implements the
+// request.EndpointErrorClassifier interface.
+type EndpointErrorClassifier interface{}
+
 // A Config provides service configuration for service clients. By default,
 // all clients will use the defaults.DefaultConfig structure.
 //
@@ -55,13 +59,178 @@ type Config struct {
 	// this file provide the endpoints list
 	EndpointsPath *string
 
-	// The Endpoint collection
+	// An optional list of gateways, in place of the file of EndpointsPath.
+	// Each gateway is an URL followed by optional attributes, in the
+	// format of a line of an endpoints file.
+	Gateways []string
+
+	// The Endpoint collection. Set by the Session from EndpointsPath or
+	// Gateways, or built by the caller, such as with
+	// endpoints.NewEndpointCollectionFromList. A collection built by the
+	// caller is used as is and is not closed by the Session.
 	CEndpoint *endpoints.EndpointCollection
 
+	// Named pools of endpoints, in addition to the default pool of
+	// EndpointsPath. Maps the name of a pool to the path of its endpoints
+	// file.
+	EndpointPools map[string]string
+
+	// The routes sending requests to the pools of EndpointPools, by bucket
+	// name or operation. The first matching route wins, requests matching
+	// no route use the default pool.
+	EndpointRoutes []endpoints.PoolRoute
+
+	// The pools of EndpointPools and EndpointRoutes, resolved by the
+	// Session when a client is created.
+	CEndpointPools *endpoints.EndpointPools
+
 	KeepAliveInterval *int
 
 	MaxNetworkErrorRetries *int
 
+	// The quota the retries of the requests draw from and their successes
+	// refill. Clients sharing the quota, like the clients of a session,
+	// stop retrying once it is empty and fail with the
+	// request.ErrCodeRetryQuotaExceeded error. Defaults to nil, retries
+	// are only bounded by MaxRetries.
+	RetryQuota *endpoints.RetryQuota
+
+	// The total time budget of each request, covering all its attempts,
+	// the delays between them and the read of the response body. The
+	// deadline of the request context still applies if it is earlier. Each
+	// attempt but the last waits for its response at most the time left
+	// divided by the attempts left, and then fails with a
+	// request.ErrCodeResponseTimeout error. A request is not retried when
+	// the retry cannot complete before the deadline, it fails with the
+	// request.ErrCodeRetryDeadlineExceeded error instead. Defaults to nil,
+	// no budget.
+	OperationTimeout *time.Duration
+
+	// The timeouts of each attempt of a request, to connect, to receive
+	// the first byte of the response and between two reads of the
+	// response body. The Connect and FirstByte timeouts apply to the
+	// requests sent to the endpoints of CEndpoint, with an HTTPClient
+	// whose Transport is an *http.Transport; Connect also needs its
+	// DialContext rather than the legacy Dial. Timeouts which cannot apply
+	// are logged with Logger whatever the LogLevel, or with the Logger of
+	// the options of a CEndpoint built by the caller. An attempt timing
+	// out counts as a failure in the passive health of its endpoint and
+	// the request is retried on another endpoint at once, whatever
+	// MaxNetworkErrorRetries; a timeout does not blacklist the endpoint.
+	// ReadIdle fails the read of the body with
+	// request.ErrCodeResponseTimeout: the request is retried when it reads
+	// the body itself, a streaming body such as the one of GetObject is
+	// read after the request returned and is not retried. Defaults to nil,
+	// no attempt timeout.
+	AttemptTimeouts *endpoints.AttemptTimeouts
+
+	// The policy used to choose an endpoint from CEndpoint for each request.
+	// Defaults to endpoints.DefaultBalancePolicy, which picks a random
+	// endpoint whatever its health. The passive health of the endpoints is
+	// always recorded, but only steers the traffic when a latency-aware
+	// policy is set, such as endpoints.NewLatencyAwarePolicy().
+	BalancePolicy endpoints.BalancePolicy
+
+	// The circuit breaker thresholds of the endpoints of CEndpoint. Only
+	// used when the endpoint collection is created from EndpointsPath or
+	// Gateways, ignored for a CEndpoint built by the caller. Zero values
+	// fall back to the endpoints.DefaultBreaker* values.
+	CircuitBreaker *endpoints.CircuitBreakerConfig
+
+	// Hedges the idempotent reads sent to CEndpoint: a read which received
+	// no response headers within a percentile of the recent read latencies
+	// is sent again to another endpoint, the first response is used and
+	// the other request is canceled. Nil disables hedging.
+	Hedging *endpoints.HedgeConfig
+
+	// The health checker used to probe the blacklisted endpoints of
+	// CEndpoint. Defaults to endpoints.DefaultHealthChecker, an anonymous
+	// GET of a well known key. Only used when the endpoint collection is
+	// created from EndpointsPath or Gateways, ignored for a CEndpoint built
+	// by the caller.
+	HealthChecker endpoints.HealthChecker
+
+	// The timeout of one health probe. Defaults to
+	// endpoints.DefaultProbeTimeout.
+	ProbeTimeout *time.Duration
+
+	// The maximum number of health probes in flight. Defaults to
+	// endpoints.DefaultProbeConcurrency.
+	ProbeConcurrency *int
+
+	// The dial, response header and idle timeouts of the connections of the
+	// health prober. Zero values fall back to the defaults of
+	// endpoints.NewHttpClient.
+	ProbeTransport *endpoints.TransportConfig
+
+	// The maximum number of requests in flight to one endpoint of
+	// CEndpoint, for the endpoints without max_connections. A saturated
+	// endpoint is skipped by the balance policy, and requests wait in its
+	// queue when every endpoint is saturated. Defaults to 0, unbounded.
+	MaxConnectionsPerEndpoint *int
+
+	// Adapts the number of requests in flight to each endpoint of
+	// CEndpoint to its latency and throttling, holding the requests beyond
+	// the limit in the queue of the endpoint. Defaults to nil, only
+	// MaxConnectionsPerEndpoint bounds them.
+	ConcurrencyLimit *endpoints.ConcurrencyLimitConfig
+
+	// The maximum number of idle connections kept to one endpoint of
+	// CEndpoint, for the endpoints without max_idle_connections. Defaults
+	// to the limit of the transport of HTTPClient.
+	MaxIdleConnsPerEndpoint *int
+
+	// The zone of the client, such as its datacenter or rack. Endpoints of
+	// the same zone are preferred over the other zones. Only used when the
+	// endpoint collection is created from EndpointsPath or Gateways, ignored
+	// for a CEndpoint built by the caller.
+	LocalZone *string
+
+	// Fraction of the endpoints of LocalZone which must be active for the
+	// requests to stay in the zone. Defaults to 0, the requests only spill
+	// over to the other zones once the whole zone is blacklisted.
+	ZoneSpillover *float64
+
+	// Set this to `true` to send the requests of each object to the same
+	// gateway, chosen by hashing the bucket and key, so that the object
+	// stays in the cache of that gateway. A change of the gateways only
+	// moves the objects of the gateways added or removed. Replaces the
+	// BalancePolicy for the requests with a bucket.
+	ConsistentHash *bool
+
+	// How often the file of EndpointsPath is checked for changes, so that
+	// added and removed gateways are picked up without a restart. Defaults
+	// to endpoints.DefaultSeedReloadInterval, negative disables the reload.
+	SeedReloadInterval *time.Duration
+
+	// Set this to `true` to keep the last endpoint list received from the
+	// server in a cache file next to the file of EndpointsPath, see
+	// endpoints.DefaultCachePath. On start the cached endpoints are tried
+	// after the initial ones, so that the client recovers even when all of
+	// them are stale.
+	CacheEndpoints *bool
+
+	// Finds the gateways of CEndpoint in place of the endpoint list API of
+	// the server, such as endpoints.DNSSRVDiscoverer. The file of
+	// EndpointsPath then only provides the initial gateways. Only used when
+	// the endpoint collection is created from EndpointsPath or Gateways,
+	// ignored for a CEndpoint built by the caller.
+	GatewayDiscoverer endpoints.GatewayDiscoverer
+
+	// EndpointErrorClassifier decides which failed requests count against
+	// the endpoint of CEndpoint they were sent to, and are retried on
+	// another endpoint. Network errors always count. By default timeouts
+	// count too, 5xx responses and throttling do not.
+	//
+	// When nil or the value does not implement the
+	// request.EndpointErrorClassifier interface,
+	// request.DefaultEndpointErrorClasses will be used.
+	//
+	// To set the EndpointErrorClassifier field in a type-safe manner and
+	// with chaining, use the request.WithEndpointErrorClassifier helper
+	// function.
+	EndpointErrorClassifier EndpointErrorClassifier
+
 	// The resolver to use for looking up endpoints for AWS service clients
 	// to use based on region.
 	EndpointResolver endpoints.Resolver
@@ -328,6 +497,172 @@ func (c *Config) WithMaxNetworkErrorRetries(retries int) *Config {
 	return c
 }
 
+// WithRetryQuota sets a config RetryQuota value returning a Config pointer
+// for chaining.
+func (c *Config) WithRetryQuota(quota *endpoints.RetryQuota) *Config {
+	c.RetryQuota = quota
+	return c
+}
+
+// WithOperationTimeout sets a config OperationTimeout value returning a
+// Config pointer for chaining.
+func (c *Config) WithOperationTimeout(timeout time.Duration) *Config {
+	c.OperationTimeout = &timeout
+	return c
+}
+
+// WithAttemptTimeouts sets a config AttemptTimeouts value returning a
+// Config pointer for chaining.
+func (c *Config) WithAttemptTimeouts(timeouts endpoints.AttemptTimeouts) *Config {
+	c.AttemptTimeouts = &timeouts
+	return c
+}
+
+// WithEndpointPool adds a named pool of endpoints, read from
+// endpointsPath, returning a Config pointer for chaining.
+func (c *Config) WithEndpointPool(name, endpointsPath string) *Config {
+	pools := make(map[string]string, len(c.EndpointPools)+1)
+	for k, v := range c.EndpointPools {
+		pools[k] = v
+	}
+	pools[name] = endpointsPath
+	c.EndpointPools = pools
+	return c
+}
+
+// WithEndpointRoutes sets a config EndpointRoutes value returning a Config
+// pointer for chaining.
+func (c *Config) WithEndpointRoutes(routes ...endpoints.PoolRoute) *Config {
+	c.EndpointRoutes = routes
+	return c
+}
+
+// WithBalancePolicy sets a config BalancePolicy value returning a Config
+// pointer for chaining.
+func (c *Config) WithBalancePolicy(policy endpoints.BalancePolicy) *Config {
+	c.BalancePolicy = policy
+	return c
+}
+
+// WithCircuitBreaker sets a config CircuitBreaker value returning a Config
+// pointer for chaining.
+func (c *Config) WithCircuitBreaker(cfg endpoints.CircuitBreakerConfig) *Config {
+	c.CircuitBreaker = &cfg
+	return c
+}
+
+// WithHedging sets a config Hedging value returning a Config pointer for
+// chaining.
+func (c *Config) WithHedging(cfg endpoints.HedgeConfig) *Config {
+	c.Hedging = &cfg
+	return c
+}
+
+// WithGateways sets a config Gateways value returning a Config pointer for
+// chaining.
+func (c *Config) WithGateways(gateways ...string) *Config {
+	c.Gateways = append([]string(nil), gateways...)
+	return c
+}
+
+// WithEndpointCollection sets a config CEndpoint value returning a Config
+// pointer for chaining.
+func (c *Config) WithEndpointCollection(coll *endpoints.EndpointCollection) *Config {
+	c.CEndpoint = coll
+	return c
+}
+
+// WithHealthChecker sets a config HealthChecker value returning a Config
+// pointer for chaining.
+func (c *Config) WithHealthChecker(checker endpoints.HealthChecker) *Config {
+	c.HealthChecker = checker
+	return c
+}
+
+// WithGatewayDiscoverer sets a config GatewayDiscoverer value returning a
+// Config pointer for chaining.
+func (c *Config) WithGatewayDiscoverer(discoverer endpoints.GatewayDiscoverer) *Config {
+	c.GatewayDiscoverer = discoverer
+	return c
+}
+
+// WithProbeTimeout sets a config ProbeTimeout value returning a Config
+// pointer for chaining.
+func (c *Config) WithProbeTimeout(timeout time.Duration) *Config {
+	c.ProbeTimeout = &timeout
+	return c
+}
+
+// WithProbeTransport sets a config ProbeTransport value returning a Config
+// pointer for chaining.
+func (c *Config) WithProbeTransport(cfg endpoints.TransportConfig) *Config {
+	c.ProbeTransport = &cfg
+	return c
+}
+
+// WithMaxConnectionsPerEndpoint sets a config MaxConnectionsPerEndpoint
+// value returning a Config pointer for chaining.
+func (c *Config) WithMaxConnectionsPerEndpoint(n int) *Config {
+	c.MaxConnectionsPerEndpoint = &n
+	return c
+}
+
+// WithConcurrencyLimit sets a config ConcurrencyLimit value returning a
+// Config pointer for chaining.
+func (c *Config) WithConcurrencyLimit(cfg endpoints.ConcurrencyLimitConfig) *Config {
+	c.ConcurrencyLimit = &cfg
+	return c
+}
+
+// WithMaxIdleConnsPerEndpoint sets a config MaxIdleConnsPerEndpoint value
+// returning a Config pointer for chaining.
+func (c *Config) WithMaxIdleConnsPerEndpoint(n int) *Config {
+	c.MaxIdleConnsPerEndpoint = &n
+	return c
+}
+
+// WithProbeConcurrency sets a config ProbeConcurrency value returning a
+// Config pointer for chaining.
+func (c *Config) WithProbeConcurrency(n int) *Config {
+	c.ProbeConcurrency = &n
+	return c
+}
+
+// WithLocalZone sets a config LocalZone value returning a Config pointer
+// for chaining.
+func (c *Config) WithLocalZone(zone string) *Config {
+	c.LocalZone = &zone
+	return c
+}
+
+// WithSeedReloadInterval sets a config SeedReloadInterval value returning a
+// Config pointer for chaining.
+func (c *Config) WithSeedReloadInterval(interval time.Duration) *Config {
+	c.SeedReloadInterval = &interval
+	return c
+}
+
+// WithCacheEndpoints sets a config CacheEndpoints value returning a Config
+// pointer for chaining.
+func (c *Config) WithCacheEndpoints(enable bool) *Config {
+	c.CacheEndpoints = &enable
+	return c
+}
+
+// WithZoneSpillover sets a config ZoneSpillover value returning a Config
+// pointer for chaining.
+func (c *Config) WithZoneSpillover(fraction float64) *Config {
+	c.ZoneSpillover = &fraction
+	return c
+}
+
+// WithConsistentHash sets a config ConsistentHash value returning a Config
+// pointer for chaining.
+func (c *Config) WithConsistentHash(enable bool) *Config {
+	c.ConsistentHash = &enable
+	return c
+}
+
 // WithEndpointResolver sets a config EndpointResolver value returning a
 // Config pointer for chaining.
 func (c *Config) WithEndpointResolver(resolver endpoints.Resolver) *Config {
@@ -500,6 +835,10 @@ func mergeInConfig(dst *Config, other *Config) {
 		dst.EndpointsPath = other.EndpointsPath
 	}
 
+	if other.Gateways != nil {
+		dst.Gateways = other.Gateways
+	}
+
 	if other.CEndpoint != nil {
 		dst.CEndpoint = other.CEndpoint
 	}
@@ -516,6 +855,98 @@ func mergeInConfig(dst *Config, other *Config) {
 		dst.MaxNetworkErrorRetries = other.MaxNetworkErrorRetries
 	}
 
+	if other.RetryQuota != nil {
+		dst.RetryQuota = other.RetryQuota
+	}
+
+	if other.OperationTimeout != nil {
+		dst.OperationTimeout = other.OperationTimeout
+	}
+
+	if other.AttemptTimeouts != nil {
+		dst.AttemptTimeouts = other.AttemptTimeouts
+	}
+
+	if other.EndpointPools != nil {
+		dst.EndpointPools = other.EndpointPools
+	}
+
+	if other.EndpointRoutes != nil {
+		dst.EndpointRoutes = other.EndpointRoutes
+	}
+
+	if other.CEndpointPools != nil {
+		dst.CEndpointPools = other.CEndpointPools
+	}
+
+	if other.BalancePolicy != nil {
+		dst.BalancePolicy = other.BalancePolicy
+	}
+
+	if other.CircuitBreaker != nil {
+		dst.CircuitBreaker = other.CircuitBreaker
+	}
+
+	if other.Hedging != nil {
+		dst.Hedging = other.Hedging
+	}
+
+	if other.HealthChecker != nil {
+		dst.HealthChecker = other.HealthChecker
+	}
+
+	if other.ProbeTimeout != nil {
+		dst.ProbeTimeout = other.ProbeTimeout
+	}
+
+	if other.ProbeConcurrency != nil {
+		dst.ProbeConcurrency = other.ProbeConcurrency
+	}
+
+	if other.ProbeTransport != nil {
+		dst.ProbeTransport = other.ProbeTransport
+	}
+
+	if other.MaxConnectionsPerEndpoint != nil {
+		dst.MaxConnectionsPerEndpoint = other.MaxConnectionsPerEndpoint
+	}
+
+	if other.MaxIdleConnsPerEndpoint != nil {
+		dst.MaxIdleConnsPerEndpoint = other.MaxIdleConnsPerEndpoint
+	}
+
+	if other.ConcurrencyLimit != nil {
+		dst.ConcurrencyLimit = other.ConcurrencyLimit
+	}
+
+	if other.LocalZone != nil {
+		dst.LocalZone = other.LocalZone
+	}
+
+	if other.ZoneSpillover != nil {
+		dst.ZoneSpillover = other.ZoneSpillover
+	}
+
+	if other.ConsistentHash != nil {
+		dst.ConsistentHash = other.ConsistentHash
+	}
+
+	if other.SeedReloadInterval != nil {
+		dst.SeedReloadInterval = other.SeedReloadInterval
+	}
+
+	if other.CacheEndpoints != nil {
+		dst.CacheEndpoints = other.CacheEndpoints
+	}
+
+	if other.GatewayDiscoverer != nil {
+		dst.GatewayDiscoverer = other.GatewayDiscoverer
+	}
+
+	if other.EndpointErrorClassifier != nil {
+		dst.EndpointErrorClassifier = other.EndpointErrorClassifier
+	}
+
 	if other.EndpointResolver != nil {
 		dst.EndpointResolver = other.EndpointResolver
 	}
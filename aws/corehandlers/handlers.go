@@ -117,11 +117,11 @@ var SendHandler = request.NamedHandler{
 }
 
 func sendFollowRedirects(r *request.Request) (*http.Response, error) {
-	return r.Config.HTTPClient.Do(r.HTTPRequest)
+	return r.HTTPClient().Do(r.HTTPRequest)
 }
 
 func sendWithoutFollowRedirects(r *request.Request) (*http.Response, error) {
-	transport := r.Config.HTTPClient.Transport
+	transport := r.HTTPClient().Transport
 	if transport == nil {
 		transport = http.DefaultTransport
 	}
@@ -197,6 +197,10 @@ var AfterRetryHandler = request.NamedHandler{
 
 		if r.WillRetry() {
 			r.RetryDelay = r.RetryRules(r)
+			if !r.RetryBeforeDeadline() || !r.DrawRetryQuota() {
+				return
+			}
+
 			if sleepFn := r.Config.SleepDelay; sleepFn != nil {
 				// Support SleepDelay for backwards compatibility and testing
 				sleepFn(r.RetryDelay)
@@ -219,6 +223,30 @@ var AfterRetryHandler = request.NamedHandler{
 		}
 	}}
 
+// EndpointStatsHandler records the outcome of each request attempt on the
+// endpoint it was sent to, feeding the passive health of the endpoints of
+// Config.CEndpoint. Errors classified by Request.IsEndpointError count as
+// failures of the endpoint, canceled requests are not recorded. The
+// concurrency limit of the endpoint shrinks on those errors and on
+// throttling.
+var EndpointStatsHandler = request.NamedHandler{
+	Name: "core.EndpointStatsHandler",
+	Fn: func(r *request.Request) {
+		if r.Endpoint == nil || r.SendTime.IsZero() {
+			return
+		}
+		if aerr, ok := r.Error.(awserr.Error); ok && aerr.Code() == request.CanceledErrorCode {
+			return
+		}
+
+		// signing and the wait for the endpoint are not its latency
+		latency := time.Since(r.SendTime)
+		r.Endpoint.RecordResult(latency, r.IsEndpointError())
+		if r.CEndpoint != nil {
+			r.CEndpoint.RecordLimitResult(r.Endpoint, latency, r.IsEndpointError() || r.IsErrorThrottle())
+		}
+	}}
+
 // ValidateEndpointHandler is a request handler to validate a request had the
 // appropriate Region and Endpoint set. Will set r.Error if the endpoint or
 // region is not valid.
@@ -432,3 +432,104 @@ func TestBuildContentLength_WithBody(t *testing.T) {
 		t.Errorf("expect no error, got %v", err)
 	}
 }
+
+func TestEndpointStatsHandler(t *testing.T) {
+	cases := map[string]struct {
+		Error      error
+		StatusCode int
+		Samples    uint64
+		Failed     bool
+	}{
+		"success": {
+			StatusCode: 200,
+			Samples:    1,
+		},
+		"client error": {
+			Error:      awserr.New("NoSuchKey", "not found", nil),
+			StatusCode: 404,
+			Samples:    1,
+		},
+		"server error by default": {
+			Error:      awserr.New("InternalError", "internal error", nil),
+			StatusCode: 500,
+			Samples:    1,
+		},
+		"network error": {
+			Error: awserr.New(request.ErrCodeRequestError, "send request failed", &url.Error{
+				URL: "http://127.0.0.1:8080",
+				Err: fmt.Errorf("connection refused"),
+			}),
+			Samples: 1,
+			Failed:  true,
+		},
+		"canceled": {
+			Error: awserr.New(request.CanceledErrorCode, "request context canceled", nil),
+		},
+	}
+
+	for name, c := range cases {
+		t.Run(name, func(t *testing.T) {
+			c1 := awstesting.NewClient()
+			req := c1.NewRequest(&request.Operation{Name: "Operation"}, nil, nil)
+			req.Endpoint = &endpoints.SingleEndpoint{URL: "http://127.0.0.1:8080"}
+			req.SendTime = time.Now().Add(-time.Second)
+			req.Error = c.Error
+			if c.StatusCode != 0 {
+				req.HTTPResponse = &http.Response{StatusCode: c.StatusCode}
+			}
+
+			corehandlers.EndpointStatsHandler.Fn(req)
+
+			score := req.Endpoint.Score()
+			if e, a := c.Samples, score.Samples; e != a {
+				t.Fatalf("expect %v samples, got %v", e, a)
+			}
+			if c.Samples == 0 {
+				return
+			}
+			if e, a := c.Failed, score.ErrorRate > 0; e != a {
+				t.Errorf("expect failed %v, got error rate %v", e, score.ErrorRate)
+			}
+		})
+	}
+}
+
+func TestEndpointStatsHandlerConcurrencyLimit(t *testing.T) {
+	ec, err := endpoints.NewEndpointCollectionFromList([]string{"http://127.0.0.1:8080"}, 100,
+		func(o *endpoints.CollectionOptions) {
+			o.ConcurrencyLimit = &endpoints.ConcurrencyLimitConfig{InitialLimit: 10}
+		})
+	if err != nil {
+		t.Fatalf("expect no error, got %v", err)
+	}
+	defer ec.Close()
+
+	c1 := awstesting.NewClient()
+	req := c1.NewRequest(&request.Operation{Name: "Operation"}, nil, nil)
+	req.CEndpoint = ec
+	req.Endpoint = ec.SelectEndpoint(nil)
+	req.SendTime = time.Now().Add(-time.Second)
+	req.Error = awserr.New("SlowDown", "slow down", nil)
+	req.HTTPResponse = &http.Response{StatusCode: 503}
+
+	corehandlers.EndpointStatsHandler.Fn(req)
+
+	if e, a := 9, ec.ConcurrencyLimit(req.Endpoint); e != a {
+		t.Errorf("expect limit %d after throttling, got %d", e, a)
+	}
+}
+
+func TestEndpointStatsHandlerQueueWait(t *testing.T) {
+	c1 := awstesting.NewClient()
+	req := c1.NewRequest(&request.Operation{Name: "Operation"}, nil, nil)
+	req.Endpoint = &endpoints.SingleEndpoint{URL: "http://127.0.0.1:8080"}
+	// the attempt waited for the endpoint before being sent
+	req.AttemptTime = time.Now().Add(-10 * time.Second)
+	req.SendTime = time.Now().Add(-10 * time.Millisecond)
+
+	corehandlers.EndpointStatsHandler.Fn(req)
+
+	if a := req.Endpoint.Score().Latency; a <= 0 || a >= time.Second {
+		t.Errorf("expect the latency since the attempt was sent, got %v", a)
+	}
+}
@@ -0,0 +1,279 @@
+// Copyright 2020 Baidu, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License"); you may not use this file
+// except in compliance with the License. You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software distributed under the
+// License is distributed on an "AS IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND,
+// either express or implied. See the License for the specific language governing permissions
+// and limitations under the License.
+
+package endpoints
+
+import (
+	"math/rand"
+	"sync/atomic"
+)
+
+// BalancePolicy chooses which endpoint of an EndpointCollection a request
+// is sent to.
+//
+// Pick is called with the active endpoints of the collection, sorted by
+// HostAndPort, and must return one of them. Pick is never called with an
+// empty list. Implementations must be safe for concurrent use, a single
+// policy is shared by every request created from the same aws.Config.
+type BalancePolicy interface {
+	// Name returns the name of the policy.
+	Name() string
+
+	// Pick selects one endpoint from the candidates.
+	Pick(candidates []*SingleEndpoint) *SingleEndpoint
+}
+
+const (
+	// RandomPolicyName is the name of the policy returned by NewRandomPolicy.
+	RandomPolicyName = "random"
+
+	// RoundRobinPolicyName is the name of the policy returned by
+	// NewRoundRobinPolicy.
+	RoundRobinPolicyName = "round-robin"
+
+	// LeastOutstandingPolicyName is the name of the policy returned by
+	// NewLeastOutstandingPolicy.
+	LeastOutstandingPolicyName = "least-outstanding"
+
+	// PowerOfTwoChoicesPolicyName is the name of the policy returned by
+	// NewPowerOfTwoChoicesPolicy.
+	PowerOfTwoChoicesPolicyName = "power-of-two-choices"
+
+	// WeightedPolicyName is the name of the policy returned by
+	// NewWeightedPolicy.
+	WeightedPolicyName = "weighted"
+
+	// LatencyAwarePolicyName is the name of the policy returned by
+	// NewLatencyAwarePolicy.
+	LatencyAwarePolicyName = "latency-aware"
+)
+
+// DefaultBalancePolicy is used when no policy is configured. It keeps the
+// random placement the collection has always used, and ignores the passive
+// health of the endpoints: the latency-aware policy is opt-in.
+var DefaultBalancePolicy BalancePolicy = NewRandomPolicy()
+
+// NewBalancePolicy returns a new built-in policy by name, or nil if the
+// name is unknown. Weighted policies created by name use the weight of
+// each endpoint.
+func NewBalancePolicy(name string) BalancePolicy {
+	switch name {
+	case RandomPolicyName:
+		return NewRandomPolicy()
+	case RoundRobinPolicyName:
+		return NewRoundRobinPolicy()
+	case LeastOutstandingPolicyName:
+		return NewLeastOutstandingPolicy()
+	case PowerOfTwoChoicesPolicyName:
+		return NewPowerOfTwoChoicesPolicy()
+	case WeightedPolicyName:
+		return NewWeightedPolicy(nil)
+	case LatencyAwarePolicyName:
+		return NewLatencyAwarePolicy()
+	}
+	return nil
+}
+
+type randomPolicy struct{}
+
+// NewRandomPolicy returns a policy that picks a uniformly random endpoint.
+func NewRandomPolicy() BalancePolicy {
+	return randomPolicy{}
+}
+
+func (randomPolicy) Name() string {
+	return RandomPolicyName
+}
+
+func (randomPolicy) Pick(candidates []*SingleEndpoint) *SingleEndpoint {
+	return candidates[rand.Intn(len(candidates))]
+}
+
+type roundRobinPolicy struct {
+	next uint64
+}
+
+// NewRoundRobinPolicy returns a policy that walks the endpoints in order.
+func NewRoundRobinPolicy() BalancePolicy {
+	return &roundRobinPolicy{}
+}
+
+func (p *roundRobinPolicy) Name() string {
+	return RoundRobinPolicyName
+}
+
+func (p *roundRobinPolicy) Pick(candidates []*SingleEndpoint) *SingleEndpoint {
+	n := atomic.AddUint64(&p.next, 1) - 1
+	return candidates[n%uint64(len(candidates))]
+}
+
+type leastOutstandingPolicy struct{}
+
+// NewLeastOutstandingPolicy returns a policy that picks the endpoint with
+// the fewest in-flight requests. Ties are broken randomly so that idle
+// clients do not all start on the same gateway.
+func NewLeastOutstandingPolicy() BalancePolicy {
+	return leastOutstandingPolicy{}
+}
+
+func (leastOutstandingPolicy) Name() string {
+	return LeastOutstandingPolicyName
+}
+
+func (leastOutstandingPolicy) Pick(candidates []*SingleEndpoint) *SingleEndpoint {
+	var (
+		best  *SingleEndpoint
+		least int64
+		ties  int
+	)
+	for _, endpoint := range candidates {
+		inflight := endpoint.Inflight()
+		switch {
+		case best == nil || inflight < least:
+			best, least, ties = endpoint, inflight, 1
+		case inflight == least:
+			// reservoir sampling over the endpoints with the same load
+			ties++
+			if rand.Intn(ties) == 0 {
+				best = endpoint
+			}
+		}
+	}
+	return best
+}
+
+type powerOfTwoChoicesPolicy struct{}
+
+// NewPowerOfTwoChoicesPolicy returns a policy that samples two random
+// endpoints and picks the one with fewer in-flight requests.
+func NewPowerOfTwoChoicesPolicy() BalancePolicy {
+	return powerOfTwoChoicesPolicy{}
+}
+
+func (powerOfTwoChoicesPolicy) Name() string {
+	return PowerOfTwoChoicesPolicyName
+}
+
+func (powerOfTwoChoicesPolicy) Pick(candidates []*SingleEndpoint) *SingleEndpoint {
+	if len(candidates) == 1 {
+		return candidates[0]
+	}
+
+	i := rand.Intn(len(candidates))
+	j := rand.Intn(len(candidates) - 1)
+	if j >= i {
+		j++
+	}
+	a, b := candidates[i], candidates[j]
+	if b.Inflight() < a.Inflight() {
+		return b
+	}
+	return a
+}
+
+type weightedPolicy struct {
+	weights map[string]int
+}
+
+// NewWeightedPolicy returns a policy that picks endpoints randomly in
+// proportion to their weight. The weight of an endpoint is looked up in
+// weights by URL, and falls back to SingleEndpoint.Weight. Endpoints with
+// a weight of zero or less count as weight 1.
+func NewWeightedPolicy(weights map[string]int) BalancePolicy {
+	copied := make(map[string]int, len(weights))
+	for k, v := range weights {
+		copied[k] = v
+	}
+	return &weightedPolicy{weights: copied}
+}
+
+func (p *weightedPolicy) Name() string {
+	return WeightedPolicyName
+}
+
+func (p *weightedPolicy) weight(endpoint *SingleEndpoint) int {
+	weight, ok := p.weights[endpoint.URL]
+	if !ok {
+		weight = endpoint.Weight
+	}
+	if weight <= 0 {
+		weight = 1
+	}
+	return weight
+}
+
+func (p *weightedPolicy) Pick(candidates []*SingleEndpoint) *SingleEndpoint {
+	total := 0
+	for _, endpoint := range candidates {
+		total += p.weight(endpoint)
+	}
+
+	n := rand.Intn(total)
+	for _, endpoint := range candidates {
+		n -= p.weight(endpoint)
+		if n < 0 {
+			return endpoint
+		}
+	}
+	return candidates[len(candidates)-1]
+}
+
+type latencyAwarePolicy struct{}
+
+// NewLatencyAwarePolicy returns a policy that picks endpoints randomly in
+// inverse proportion to the cost of their EndpointScore, so slow or flaky
+// endpoints receive less traffic before they are blacklisted. Endpoints
+// without recorded results are assumed to be as fast as the average. It is
+// not the DefaultBalancePolicy, set it in aws.Config.BalancePolicy to use it.
+func NewLatencyAwarePolicy() BalancePolicy {
+	return latencyAwarePolicy{}
+}
+
+func (latencyAwarePolicy) Name() string {
+	return LatencyAwarePolicyName
+}
+
+func (latencyAwarePolicy) Pick(candidates []*SingleEndpoint) *SingleEndpoint {
+	scores := make([]EndpointScore, len(candidates))
+	sampled, sum := 0, 0.0
+	for i, endpoint := range candidates {
+		scores[i] = endpoint.Score()
+		if scores[i].Samples > 0 {
+			sampled++
+			sum += float64(scores[i].Latency)
+		}
+	}
+	mean := 1.0
+	if sampled > 0 {
+		mean = sum / float64(sampled)
+	}
+
+	weights := make([]float64, len(candidates))
+	total := 0.0
+	for i, score := range scores {
+		cost := score.Cost
+		if score.Samples == 0 {
+			cost = endpointCost(mean, 0, score.Inflight)
+		}
+		weights[i] = 1 / cost
+		total += weights[i]
+	}
+
+	n := rand.Float64() * total
+	for i, weight := range weights {
+		n -= weight
+		if n < 0 {
+			return candidates[i]
+		}
+	}
+	return candidates[len(candidates)-1]
+}
@@ -0,0 +1,186 @@
+package endpoints
+
+import (
+	"testing"
+	"time"
+)
+
+func newTestCandidates(urls ...string) []*SingleEndpoint {
+	candidates := make([]*SingleEndpoint, 0, len(urls))
+	for _, u := range urls {
+		endpoint := &SingleEndpoint{}
+		if err := parseEndpointFromString(u, endpoint); err != nil {
+			panic(err)
+		}
+		candidates = append(candidates, endpoint)
+	}
+	return candidates
+}
+
+func TestNewBalancePolicy(t *testing.T) {
+	names := []string{
+		RandomPolicyName,
+		RoundRobinPolicyName,
+		LeastOutstandingPolicyName,
+		PowerOfTwoChoicesPolicyName,
+		WeightedPolicyName,
+	}
+	for _, name := range names {
+		p := NewBalancePolicy(name)
+		if p == nil {
+			t.Fatalf("%s expect policy, got nil", name)
+		}
+		if e, a := name, p.Name(); e != a {
+			t.Errorf("expect name %s, got %s", e, a)
+		}
+	}
+
+	if p := NewBalancePolicy("unknown"); p != nil {
+		t.Errorf("expect nil policy, got %v", p.Name())
+	}
+}
+
+func TestRoundRobinPolicy(t *testing.T) {
+	candidates := newTestCandidates("abc1.test:8080", "abc2.test:8080", "abc3.test:8080")
+	p := NewRoundRobinPolicy()
+
+	for i := 0; i < 9; i++ {
+		if e, a := candidates[i%3], p.Pick(candidates); e != a {
+			t.Errorf("%d expect %s, got %s", i, e.URL, a.URL)
+		}
+	}
+}
+
+func TestLeastOutstandingPolicy(t *testing.T) {
+	candidates := newTestCandidates("abc1.test:8080", "abc2.test:8080", "abc3.test:8080")
+	candidates[0].Acquire()
+	candidates[0].Acquire()
+	candidates[2].Acquire()
+
+	p := NewLeastOutstandingPolicy()
+	for i := 0; i < 10; i++ {
+		if e, a := candidates[1], p.Pick(candidates); e != a {
+			t.Errorf("%d expect %s, got %s", i, e.URL, a.URL)
+		}
+	}
+
+	candidates[0].Release()
+	candidates[0].Release()
+	seen := map[*SingleEndpoint]bool{}
+	for i := 0; i < 100; i++ {
+		seen[p.Pick(candidates)] = true
+	}
+	if seen[candidates[2]] {
+		t.Errorf("expect %s never picked", candidates[2].URL)
+	}
+	if !seen[candidates[0]] || !seen[candidates[1]] {
+		t.Errorf("expect ties to be spread, got %v", seen)
+	}
+}
+
+func TestPowerOfTwoChoicesPolicy(t *testing.T) {
+	candidates := newTestCandidates("abc1.test:8080", "abc2.test:8080")
+	candidates[0].Acquire()
+
+	p := NewPowerOfTwoChoicesPolicy()
+	for i := 0; i < 10; i++ {
+		if e, a := candidates[1], p.Pick(candidates); e != a {
+			t.Errorf("%d expect %s, got %s", i, e.URL, a.URL)
+		}
+	}
+
+	single := candidates[:1]
+	if e, a := single[0], p.Pick(single); e != a {
+		t.Errorf("expect %s, got %s", e.URL, a.URL)
+	}
+}
+
+func TestWeightedPolicy(t *testing.T) {
+	candidates := newTestCandidates("abc1.test:8080", "abc2.test:8080", "abc3.test:8080")
+	candidates[1].Weight = -1
+
+	p := NewWeightedPolicy(map[string]int{
+		"http://abc1.test:8080": 1000000,
+	})
+	picked := map[*SingleEndpoint]int{}
+	for i := 0; i < 100; i++ {
+		picked[p.Pick(candidates)]++
+	}
+	if picked[candidates[0]] < 90 {
+		t.Errorf("expect heavy endpoint picked most of the time, got %v", picked[candidates[0]])
+	}
+
+	candidates[2].Weight = 1000000
+	p = NewWeightedPolicy(nil)
+	picked = map[*SingleEndpoint]int{}
+	for i := 0; i < 100; i++ {
+		picked[p.Pick(candidates)]++
+	}
+	if picked[candidates[2]] < 90 {
+		t.Errorf("expect heavy endpoint picked most of the time, got %v", picked[candidates[2]])
+	}
+}
+
+func TestSelectEndpoint(t *testing.T) {
+	ec, err := NewEndpointCollection(TEST_ENDPOINT_PATH, 3)
+	if err != nil {
+		t.Fatalf("1 expect nil, got err != nil")
+	}
+
+	p := NewRoundRobinPolicy()
+	first := ec.SelectEndpoint(p)
+	if first == nil {
+		t.Fatalf("2 expect endpoint, got nil")
+	}
+	if e, a := "http://abc1.test:8080", first.URL; e != a {
+		t.Errorf("3 expect %s, got %s", e, a)
+	}
+
+	ec.AddEndpointToBlacklist(ec.endpointHead.next)
+	for i := 0; i < 4; i++ {
+		endpoint := ec.SelectEndpoint(p)
+		if endpoint == nil || endpoint.URL == "http://abc2.test:8080" {
+			t.Errorf("4 expect active endpoint, got %v", endpoint)
+		}
+	}
+
+	if endpoint := ec.SelectEndpoint(nil); endpoint == nil {
+		t.Errorf("5 expect endpoint from default policy, got nil")
+	}
+
+	empty := &EndpointCollection{}
+	if endpoint := empty.SelectEndpoint(p); endpoint != nil {
+		t.Errorf("6 expect nil, got %s", endpoint.URL)
+	}
+}
+
+func TestLatencyAwarePolicy(t *testing.T) {
+	candidates := newTestCandidates("abc1.test:8080", "abc2.test:8080", "abc3.test:8080")
+	p := NewLatencyAwarePolicy()
+
+	// without samples every endpoint is picked
+	seen := map[*SingleEndpoint]bool{}
+	for i := 0; i < 100; i++ {
+		seen[p.Pick(candidates)] = true
+	}
+	if e, a := 3, len(seen); e != a {
+		t.Errorf("1 expect %d endpoints picked, got %d", e, a)
+	}
+
+	for i := 0; i < 10; i++ {
+		candidates[0].RecordResult(time.Millisecond, false)
+		candidates[1].RecordResult(time.Second, false)
+		candidates[2].RecordResult(time.Millisecond, true)
+	}
+
+	picked := map[*SingleEndpoint]int{}
+	for i := 0; i < 1000; i++ {
+		picked[p.Pick(candidates)]++
+	}
+	if picked[candidates[0]] < 900 {
+		t.Errorf("2 expect healthy endpoint picked most of the time, got %v", picked[candidates[0]])
+	}
+	if picked[candidates[1]] >= picked[candidates[0]] || picked[candidates[2]] >= picked[candidates[0]] {
+		t.Errorf("3 expect slow and flaky endpoints down-weighted, got %v", picked)
+	}
+}
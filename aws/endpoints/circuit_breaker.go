@@ -0,0 +1,225 @@
+// Copyright 2020 Baidu, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License"); you may not use this file
+// except in compliance with the License. You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software distributed under the
+// License is distributed on an "AS IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND,
+// either express or implied. See the License for the specific language governing permissions
+// and limitations under the License.
+
+package endpoints
+
+import (
+	"sync"
+	"time"
+)
+
+const (
+	// DefaultBreakerFailureThreshold is the default number of consecutive
+	// failures which open the circuit breaker of an endpoint.
+	DefaultBreakerFailureThreshold = 1
+
+	// DefaultBreakerOpenTimeout is the default time an endpoint stays
+	// blacklisted after its first trip.
+	DefaultBreakerOpenTimeout = 1 * time.Second
+
+	// DefaultBreakerMaxOpenTimeout is the default upper bound of the time
+	// an endpoint stays blacklisted.
+	DefaultBreakerMaxOpenTimeout = 5 * time.Minute
+
+	// DefaultBreakerHalfOpenMaxRequests is the default number of requests
+	// a half-open endpoint serves concurrently.
+	DefaultBreakerHalfOpenMaxRequests = 1
+
+	// DefaultBreakerHalfOpenSuccesses is the default number of successful
+	// requests which close the circuit breaker of a half-open endpoint.
+	DefaultBreakerHalfOpenSuccesses = 3
+)
+
+// BreakerState is the state of the circuit breaker of an endpoint.
+type BreakerState int
+
+const (
+	// BreakerClosed endpoints receive all the traffic chosen by the
+	// balance policy.
+	BreakerClosed BreakerState = iota
+
+	// BreakerOpen endpoints are blacklisted and receive no traffic until
+	// their open timeout elapsed and a probe succeeded.
+	BreakerOpen
+
+	// BreakerHalfOpen endpoints recovered from the blacklist and receive a
+	// limited number of requests until enough of them succeeded.
+	BreakerHalfOpen
+)
+
+func (s BreakerState) String() string {
+	switch s {
+	case BreakerClosed:
+		return "closed"
+	case BreakerOpen:
+		return "open"
+	case BreakerHalfOpen:
+		return "half-open"
+	}
+	return "unknown"
+}
+
+// CircuitBreakerConfig configures the circuit breaker of each endpoint of an
+// EndpointCollection. Zero values are replaced by the defaults.
+type CircuitBreakerConfig struct {
+	// Number of consecutive failures which open the breaker and blacklist
+	// the endpoint.
+	FailureThreshold int
+
+	// Time the endpoint stays blacklisted after its first trip. The time
+	// doubles on every trip until the breaker closes again.
+	OpenTimeout time.Duration
+
+	// Upper bound of the time the endpoint stays blacklisted.
+	MaxOpenTimeout time.Duration
+
+	// Number of requests a half-open endpoint serves concurrently.
+	HalfOpenMaxRequests int
+
+	// Number of consecutive successful requests which close the breaker
+	// of a half-open endpoint.
+	HalfOpenSuccesses int
+}
+
+func (c CircuitBreakerConfig) withDefaults() CircuitBreakerConfig {
+	if c.FailureThreshold <= 0 {
+		c.FailureThreshold = DefaultBreakerFailureThreshold
+	}
+	if c.OpenTimeout <= 0 {
+		c.OpenTimeout = DefaultBreakerOpenTimeout
+	}
+	if c.MaxOpenTimeout <= 0 {
+		c.MaxOpenTimeout = DefaultBreakerMaxOpenTimeout
+	}
+	if c.MaxOpenTimeout < c.OpenTimeout {
+		c.MaxOpenTimeout = c.OpenTimeout
+	}
+	if c.HalfOpenMaxRequests <= 0 {
+		c.HalfOpenMaxRequests = DefaultBreakerHalfOpenMaxRequests
+	}
+	if c.HalfOpenSuccesses <= 0 {
+		c.HalfOpenSuccesses = DefaultBreakerHalfOpenSuccesses
+	}
+	return c
+}
+
+// circuit breaker of one endpoint
+type circuitBreaker struct {
+	mutex     sync.Mutex
+	state     BreakerState
+	failures  int // consecutive failures while closed
+	successes int // consecutive successes while half-open
+	trips     int // times opened since the breaker was last closed
+	openUntil time.Time
+
+	// copied from the config when the breaker becomes half-open
+	halfOpenSuccesses int
+}
+
+// onFailure records a failure and returns true if the breaker opened
+func (b *circuitBreaker) onFailure(cfg CircuitBreakerConfig, now time.Time) bool {
+	b.mutex.Lock()
+	defer b.mutex.Unlock()
+
+	switch b.state {
+	case BreakerOpen:
+		return false
+	case BreakerClosed:
+		b.failures++
+		if b.failures < cfg.FailureThreshold {
+			return false
+		}
+	}
+
+	// a failure while half-open opens the breaker again right away
+	timeout := cfg.OpenTimeout
+	for i := 0; i < b.trips && timeout < cfg.MaxOpenTimeout; i++ {
+		timeout *= 2
+	}
+	if timeout > cfg.MaxOpenTimeout {
+		timeout = cfg.MaxOpenTimeout
+	}
+
+	b.state = BreakerOpen
+	b.trips++
+	b.failures = 0
+	b.successes = 0
+	b.openUntil = now.Add(timeout)
+	return true
+}
+
+// onSuccess records a successful request
+func (b *circuitBreaker) onSuccess() {
+	b.mutex.Lock()
+	defer b.mutex.Unlock()
+
+	switch b.state {
+	case BreakerClosed:
+		b.failures = 0
+	case BreakerHalfOpen:
+		b.successes++
+		if b.successes >= b.halfOpenSuccesses {
+			b.state = BreakerClosed
+			b.trips = 0
+			b.successes = 0
+		}
+	}
+}
+
+// canProbe returns true if the open timeout of the breaker elapsed
+func (b *circuitBreaker) canProbe(now time.Time) bool {
+	b.mutex.Lock()
+	defer b.mutex.Unlock()
+
+	return b.state != BreakerOpen || !now.Before(b.openUntil)
+}
+
+// halfOpen lets a trickle of traffic through an open breaker
+func (b *circuitBreaker) halfOpen(cfg CircuitBreakerConfig) {
+	b.mutex.Lock()
+	defer b.mutex.Unlock()
+
+	if b.state != BreakerOpen {
+		return
+	}
+	b.state = BreakerHalfOpen
+	b.successes = 0
+	b.halfOpenSuccesses = cfg.HalfOpenSuccesses
+}
+
+func (b *circuitBreaker) currentState() BreakerState {
+	b.mutex.Lock()
+	defer b.mutex.Unlock()
+
+	return b.state
+}
+
+// BreakerState returns the state of the circuit breaker of the endpoint.
+func (s *SingleEndpoint) BreakerState() BreakerState {
+	return s.breaker.currentState()
+}
+
+// admits returns false if the endpoint already serves as many requests as
+// it is allowed to, because it reached its concurrency limit or is half-open
+func (s *SingleEndpoint) admits(cfg CircuitBreakerConfig, limiter *endpointLimiter) bool {
+	if limiter.saturated(s.Inflight()) {
+		return false
+	}
+	if s.breaker.currentState() != BreakerHalfOpen {
+		return true
+	}
+	return s.Inflight() < int64(cfg.HalfOpenMaxRequests)
+}
+
+func (e *EndpointCollection) circuitBreakerConfig() CircuitBreakerConfig {
+	return e.options.CircuitBreaker.withDefaults()
+}
@@ -0,0 +1,152 @@
+package endpoints
+
+import (
+	"testing"
+	"time"
+)
+
+func TestCircuitBreakerConfigDefaults(t *testing.T) {
+	cfg := CircuitBreakerConfig{}.withDefaults()
+	expect := CircuitBreakerConfig{
+		FailureThreshold:    DefaultBreakerFailureThreshold,
+		OpenTimeout:         DefaultBreakerOpenTimeout,
+		MaxOpenTimeout:      DefaultBreakerMaxOpenTimeout,
+		HalfOpenMaxRequests: DefaultBreakerHalfOpenMaxRequests,
+		HalfOpenSuccesses:   DefaultBreakerHalfOpenSuccesses,
+	}
+	if cfg != expect {
+		t.Errorf("expect %+v, got %+v", expect, cfg)
+	}
+
+	cfg = CircuitBreakerConfig{OpenTimeout: time.Hour}.withDefaults()
+	if e, a := time.Hour, cfg.MaxOpenTimeout; e != a {
+		t.Errorf("expect max open timeout %v, got %v", e, a)
+	}
+}
+
+func TestCircuitBreaker(t *testing.T) {
+	cfg := CircuitBreakerConfig{
+		FailureThreshold:  2,
+		OpenTimeout:       time.Second,
+		MaxOpenTimeout:    3 * time.Second,
+		HalfOpenSuccesses: 2,
+	}.withDefaults()
+	now := time.Now()
+
+	var b circuitBreaker
+	if e, a := BreakerClosed, b.currentState(); e != a {
+		t.Fatalf("1 expect %v, got %v", e, a)
+	}
+
+	// a success resets the consecutive failures
+	if b.onFailure(cfg, now) {
+		t.Fatalf("2 expect breaker closed")
+	}
+	b.onSuccess()
+	if b.onFailure(cfg, now) {
+		t.Fatalf("3 expect breaker closed")
+	}
+	if !b.onFailure(cfg, now) {
+		t.Fatalf("4 expect breaker open")
+	}
+	if e, a := BreakerOpen, b.currentState(); e != a {
+		t.Fatalf("5 expect %v, got %v", e, a)
+	}
+	if b.onFailure(cfg, now) {
+		t.Errorf("6 expect open breaker not to open again")
+	}
+
+	expectTimeouts := []time.Duration{time.Second, 2 * time.Second, 3 * time.Second, 3 * time.Second}
+	for i, timeout := range expectTimeouts {
+		if i > 0 {
+			b.halfOpen(cfg)
+			if e, a := BreakerHalfOpen, b.currentState(); e != a {
+				t.Fatalf("%d expect %v, got %v", i, e, a)
+			}
+			// any failure while half-open opens the breaker
+			if !b.onFailure(cfg, now) {
+				t.Fatalf("%d expect breaker open", i)
+			}
+		}
+		if b.canProbe(now.Add(timeout - time.Millisecond)) {
+			t.Errorf("%d expect no probe before %v", i, timeout)
+		}
+		if !b.canProbe(now.Add(timeout)) {
+			t.Errorf("%d expect probe after %v", i, timeout)
+		}
+	}
+
+	b.halfOpen(cfg)
+	b.onSuccess()
+	if e, a := BreakerHalfOpen, b.currentState(); e != a {
+		t.Fatalf("7 expect %v, got %v", e, a)
+	}
+	b.onSuccess()
+	if e, a := BreakerClosed, b.currentState(); e != a {
+		t.Fatalf("8 expect %v, got %v", e, a)
+	}
+
+	// closing the breaker resets the backoff
+	b.onFailure(cfg, now)
+	b.onFailure(cfg, now)
+	if !b.canProbe(now.Add(time.Second)) {
+		t.Errorf("9 expect probe after %v", time.Second)
+	}
+}
+
+func TestCircuitBreakerCollection(t *testing.T) {
+	ec, err := NewEndpointCollection(TEST_ENDPOINT_PATH, 3, func(o *CollectionOptions) {
+		o.CircuitBreaker.FailureThreshold = 2
+		o.CircuitBreaker.HalfOpenMaxRequests = 1
+	})
+	if err != nil {
+		t.Fatalf("1 expect nil, got err %v", err)
+	}
+	defer ec.Close()
+
+	endpoint := ec.endpointHead
+	ec.AddEndpointToBlacklist(endpoint)
+	if endpoint.IsInBlackList || ec.numOfActiveEndpoint != 3 {
+		t.Fatalf("2 expect endpoint active below the failure threshold")
+	}
+
+	ec.AddEndpointToBlacklist(endpoint)
+	if !endpoint.IsInBlackList || ec.numOfActiveEndpoint != 2 {
+		t.Fatalf("3 expect endpoint blacklisted")
+	}
+	if e, a := BreakerOpen, endpoint.BreakerState(); e != a {
+		t.Errorf("4 expect %v, got %v", e, a)
+	}
+
+	scores := ec.Scores()
+	if e, a := 3, len(scores); e != a {
+		t.Fatalf("5 expect %d scores, got %d", e, a)
+	}
+	if e, a := BreakerOpen, scores[2].State; e != a {
+		t.Errorf("6 expect blacklisted endpoint last, got %v", a)
+	}
+
+	if !ec.RmEndpointFromBlacklist(endpoint.URL) {
+		t.Fatalf("7 expect endpoint removed from blacklist")
+	}
+	if e, a := BreakerHalfOpen, endpoint.BreakerState(); e != a {
+		t.Errorf("8 expect %v, got %v", e, a)
+	}
+
+	// a busy half-open endpoint is skipped
+	endpoint.Acquire()
+	p := NewRoundRobinPolicy()
+	for i := 0; i < 6; i++ {
+		if selected := ec.SelectEndpoint(p); selected == endpoint {
+			t.Errorf("9 expect busy half-open endpoint skipped")
+		}
+	}
+	endpoint.Release()
+
+	for i := 0; i < DefaultBreakerHalfOpenSuccesses; i++ {
+		endpoint.RecordResult(time.Millisecond, false)
+	}
+	if e, a := BreakerClosed, endpoint.BreakerState(); e != a {
+		t.Errorf("10 expect %v, got %v", e, a)
+	}
+}
@@ -0,0 +1,113 @@
+// Copyright 2020 Baidu, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License"); you may not use this file
+// except in compliance with the License. You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software distributed under the
+// License is distributed on an "AS IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND,
+// either express or implied. See the License for the specific language governing permissions
+// and limitations under the License.
+
+package endpoints
+
+import (
+	"hash/fnv"
+	"math"
+)
+
+// SelectEndpointByKey returns the preferred endpoint of key when the
+// collection is created with CollectionOptions.ConsistentHash, so that the
+// requests of one object always reach the same gateway and its cache. The
+// preferred endpoint is chosen by rendezvous hashing over the active
+// endpoints: a change of the endpoints, such as a new epoch from the server,
+// only moves the keys of the endpoints added or removed. Without
+// ConsistentHash, or with an empty key, policy chooses the endpoint as in
+// SelectEndpoint.
+//
+// The endpoints in skip, such as the one a request fails over from, are only
+// chosen if nothing else is left. Returns nil if no endpoint is active.
+func (e *EndpointCollection) SelectEndpointByKey(key string, policy BalancePolicy,
+	skip ...*SingleEndpoint) *SingleEndpoint {
+
+	candidates := e.selectable()
+	if len(candidates) == 0 {
+		return nil
+	}
+	if len(skip) > 0 {
+		others := candidates[:0:0]
+		for _, endpoint := range candidates {
+			if !containsEndpoint(skip, endpoint) {
+				others = append(others, endpoint)
+			}
+		}
+		if len(others) > 0 {
+			candidates = others
+		}
+	}
+
+	if !e.options.ConsistentHash || key == "" {
+		if policy == nil {
+			policy = DefaultBalancePolicy
+		}
+		return policy.Pick(candidates)
+	}
+	return rendezvous(key, candidates)
+}
+
+// rendezvous returns the endpoint of candidates with the highest weighted
+// score for key
+func rendezvous(key string, candidates []*SingleEndpoint) *SingleEndpoint {
+	var (
+		best      *SingleEndpoint
+		bestScore float64
+	)
+	keyHash := hashString(key)
+	for _, endpoint := range candidates {
+		score := rendezvousScore(keyHash, endpoint)
+		if best == nil || score > bestScore {
+			best, bestScore = endpoint, score
+		}
+	}
+	return best
+}
+
+// rendezvousScore is the weighted score of the endpoint for a key, the
+// endpoint with the highest score takes the key. An endpoint of weight w
+// takes w times the keys of an endpoint of weight 1.
+func rendezvousScore(keyHash uint64, endpoint *SingleEndpoint) float64 {
+	weight := endpoint.Weight
+	if weight <= 0 {
+		weight = 1
+	}
+	// uniform in (0, 1)
+	u := (float64(mix64(keyHash^hashString(endpoint.URL))>>11) + 0.5) / (1 << 53)
+	return -float64(weight) / math.Log(u)
+}
+
+func hashString(s string) uint64 {
+	h := fnv.New64a()
+	h.Write([]byte(s))
+	return h.Sum64()
+}
+
+// mix64 is the finalizer of splitmix64, fnv alone does not spread the keys
+// differing in their last bytes well enough
+func mix64(x uint64) uint64 {
+	x ^= x >> 30
+	x *= 0xbf58476d1ce4e5b9
+	x ^= x >> 27
+	x *= 0x94d049bb133111eb
+	x ^= x >> 31
+	return x
+}
+
+func containsEndpoint(endpoints []*SingleEndpoint, endpoint *SingleEndpoint) bool {
+	for _, e := range endpoints {
+		if e == endpoint {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,158 @@
+package endpoints
+
+import (
+	"fmt"
+	"testing"
+)
+
+func consistentHash(o *CollectionOptions) {
+	o.ConsistentHash = true
+}
+
+// hashURLs returns the URL of the endpoint selected for each key
+func hashURLs(ec *EndpointCollection, keys int) map[string]string {
+	urls := make(map[string]string, keys)
+	for i := 0; i < keys; i++ {
+		key := fmt.Sprintf("bucket/object-%d", i)
+		if endpoint := ec.SelectEndpointByKey(key, nil); endpoint != nil {
+			urls[key] = endpoint.URL
+		}
+	}
+	return urls
+}
+
+func TestSelectEndpointByKey(t *testing.T) {
+	ec := newListCollection(t, consistentHash, "http://abc1.test:8080", "http://abc2.test:8080",
+		"http://abc3.test:8080", "http://abc4.test:8080")
+	defer ec.Close()
+
+	const keys = 4000
+	urls := hashURLs(ec, keys)
+	for key, URL := range hashURLs(ec, keys) {
+		if e, a := urls[key], URL; e != a {
+			t.Fatalf("expect %s for %s, got %s", e, key, a)
+		}
+	}
+
+	counts := map[string]int{}
+	for _, URL := range urls {
+		counts[URL]++
+	}
+	if e, a := 4, len(counts); e != a {
+		t.Fatalf("expect keys on %d endpoints, got %v", e, counts)
+	}
+	for URL, n := range counts {
+		if n < keys/4*8/10 || n > keys/4*12/10 {
+			t.Errorf("expect about %d keys on %s, got %d", keys/4, URL, n)
+		}
+	}
+
+	// skipped endpoints are only chosen if nothing else is left
+	key := "bucket/object-0"
+	preferred := ec.SelectEndpointByKey(key, nil)
+	next := ec.SelectEndpointByKey(key, nil, preferred)
+	if next == nil || next == preferred {
+		t.Fatalf("expect another endpoint than %s", preferred.URL)
+	}
+	if e, a := next, ec.SelectEndpointByKey(key, nil, preferred); e != a {
+		t.Errorf("expect %s, got %s", e.URL, a.URL)
+	}
+	all := ec.activeEndpoints()
+	if a := ec.SelectEndpointByKey(key, nil, all...); a != preferred {
+		t.Errorf("expect %s, got %v", preferred.URL, a)
+	}
+}
+
+func TestSelectEndpointByKeyRebalance(t *testing.T) {
+	ec := newListCollection(t, consistentHash, "http://abc1.test:8080", "http://abc2.test:8080",
+		"http://abc3.test:8080")
+	defer ec.Close()
+
+	const keys = 3000
+	before := hashURLs(ec, keys)
+
+	// only the keys of a blacklisted endpoint move
+	var removed *SingleEndpoint
+	for _, endpoint := range ec.activeEndpoints() {
+		if endpoint.URL == "http://abc2.test:8080" {
+			removed = endpoint
+		}
+	}
+	ec.AddEndpointToBlacklist(removed)
+	for key, URL := range hashURLs(ec, keys) {
+		if before[key] != removed.URL && URL != before[key] {
+			t.Errorf("expect %s kept on %s, got %s", key, before[key], URL)
+		}
+	}
+
+	// a new epoch adding an endpoint only moves keys to it
+	head, num := ec.ParseEndpointFromRgwInfo(&RgwInfo{
+		RgwConfiguration: []*Rgw{
+			{Ip: "abc1.test", Port: "8080"},
+			{Ip: "abc2.test", Port: "8080"},
+			{Ip: "abc3.test", Port: "8080"},
+			{Ip: "abc4.test", Port: "8080"},
+		},
+	})
+	if err := ec.UpdateWholeEndpoitCollection(head, num, 1); err != nil {
+		t.Fatalf("expect nil, got err %v", err)
+	}
+	moved := 0
+	for key, URL := range hashURLs(ec, keys) {
+		if URL == before[key] {
+			continue
+		}
+		if URL != "http://abc4.test:8080" {
+			t.Errorf("expect %s kept on %s, got %s", key, before[key], URL)
+		}
+		moved++
+	}
+	if moved < keys/4*8/10 || moved > keys/4*12/10 {
+		t.Errorf("expect about %d keys moved, got %d", keys/4, moved)
+	}
+}
+
+func TestSelectEndpointByKeyWeight(t *testing.T) {
+	ec := newListCollection(t, consistentHash, "http://abc1.test:8080 weight=3", "http://abc2.test:8080")
+	defer ec.Close()
+
+	counts := map[string]int{}
+	for _, URL := range hashURLs(ec, 4000) {
+		counts[URL]++
+	}
+	if n := counts["http://abc1.test:8080"]; n < 2700 || n > 3300 {
+		t.Errorf("expect about 3000 keys on the heavier endpoint, got %v", counts)
+	}
+}
+
+func TestSelectEndpointByKeyDisabled(t *testing.T) {
+	ec := newIdleCollection(t, 100, CollectionOptions{})
+	policy := NewRoundRobinPolicy()
+
+	first := ec.SelectEndpointByKey("bucket/key", policy)
+	second := ec.SelectEndpointByKey("bucket/key", policy)
+	if first == nil || second == nil || first == second {
+		t.Errorf("expect the balance policy used")
+	}
+
+	hashed := newListCollection(t, consistentHash, "http://abc1.test:8080", "http://abc2.test:8080")
+	defer hashed.Close()
+	first = hashed.SelectEndpointByKey("", policy)
+	second = hashed.SelectEndpointByKey("", policy)
+	if first == nil || second == nil || first == second {
+		t.Errorf("expect the balance policy used without a key")
+	}
+}
+
+func TestSelectEndpointSkip(t *testing.T) {
+	ec := newIdleCollection(t, 100, CollectionOptions{})
+	all := ec.activeEndpoints()
+	for i := 0; i < 20; i++ {
+		if a := ec.SelectEndpointByKey("", nil, all[0], all[1]); a != all[2] {
+			t.Fatalf("expect %s, got %s", all[2].URL, a.URL)
+		}
+	}
+	if a := ec.SelectEndpointByKey("", nil, all...); a == nil {
+		t.Errorf("expect an endpoint once every endpoint is skipped")
+	}
+}
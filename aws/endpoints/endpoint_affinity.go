@@ -0,0 +1,64 @@
+// Copyright 2020 Baidu, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License"); you may not use this file
+// except in compliance with the License. You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software distributed under the
+// License is distributed on an "AS IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND,
+// either express or implied. See the License for the specific language governing permissions
+// and limitations under the License.
+
+package endpoints
+
+import (
+	"sync"
+)
+
+// Affinity pins the requests of one logical operation, such as the parts of
+// a multipart upload, to one endpoint. The operation only moves to another
+// endpoint when the pinned one fails. It is safe for concurrent use.
+type Affinity struct {
+	mutex    sync.Mutex
+	endpoint *SingleEndpoint
+}
+
+// NewAffinity returns an Affinity not pinned to any endpoint yet.
+func NewAffinity() *Affinity {
+	return &Affinity{}
+}
+
+// Select returns the pinned endpoint while it is an active endpoint of
+// coll. Otherwise it asks policy to choose an endpoint of coll, and pins it.
+// Returns nil if no endpoint is active.
+func (a *Affinity) Select(coll *EndpointCollection, policy BalancePolicy) *SingleEndpoint {
+	a.mutex.Lock()
+	defer a.mutex.Unlock()
+
+	if a.endpoint != nil && coll.isActive(a.endpoint) {
+		return a.endpoint
+	}
+	a.endpoint = coll.SelectEndpoint(policy)
+	return a.endpoint
+}
+
+// Failover unpins the endpoint after it failed, so that the next Select
+// moves the operation to another endpoint. It does nothing if the affinity
+// is pinned to another endpoint already.
+func (a *Affinity) Failover(failed *SingleEndpoint) {
+	a.mutex.Lock()
+	defer a.mutex.Unlock()
+
+	if a.endpoint == failed {
+		a.endpoint = nil
+	}
+}
+
+// Endpoint returns the pinned endpoint, nil if none is pinned.
+func (a *Affinity) Endpoint() *SingleEndpoint {
+	a.mutex.Lock()
+	defer a.mutex.Unlock()
+
+	return a.endpoint
+}
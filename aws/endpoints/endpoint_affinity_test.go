@@ -0,0 +1,78 @@
+package endpoints
+
+import (
+	"sync"
+	"testing"
+)
+
+func TestAffinity(t *testing.T) {
+	ec := newIdleCollection(t, 100, CollectionOptions{})
+	affinity := NewAffinity()
+	if endpoint := affinity.Endpoint(); endpoint != nil {
+		t.Errorf("1 expect no endpoint pinned, got %s", endpoint.URL)
+	}
+
+	policy := NewRoundRobinPolicy()
+	pinned := affinity.Select(ec, policy)
+	if pinned == nil {
+		t.Fatalf("2 expect an endpoint")
+	}
+	for i := 0; i < 10; i++ {
+		if e, a := pinned, affinity.Select(ec, policy); e != a {
+			t.Fatalf("3 expect %s, got %s", e.URL, a.URL)
+		}
+	}
+
+	// another endpoint failing leaves the affinity alone
+	other := ec.GetNextEndpoint(pinned)
+	affinity.Failover(other)
+	if e, a := pinned, affinity.Endpoint(); e != a {
+		t.Errorf("4 expect %s, got %s", e.URL, a.URL)
+	}
+
+	affinity.Failover(pinned)
+	if endpoint := affinity.Endpoint(); endpoint != nil {
+		t.Errorf("5 expect no endpoint pinned, got %s", endpoint.URL)
+	}
+	repinned := affinity.Select(ec, policy)
+	if repinned == nil {
+		t.Fatalf("6 expect an endpoint")
+	}
+
+	// a blacklisted endpoint is not kept
+	ec.AddEndpointToBlacklist(repinned)
+	moved := affinity.Select(ec, policy)
+	if moved == nil || moved == repinned {
+		t.Fatalf("7 expect another endpoint than %s", repinned.URL)
+	}
+	if e, a := moved, affinity.Endpoint(); e != a {
+		t.Errorf("8 expect %s, got %s", e.URL, a.URL)
+	}
+
+	dropActiveEndpoints(ec)
+	if endpoint := affinity.Select(ec, policy); endpoint != nil {
+		t.Errorf("9 expect nil, got %s", endpoint.URL)
+	}
+}
+
+func TestAffinityConcurrentSelect(t *testing.T) {
+	ec := newIdleCollection(t, 100, CollectionOptions{})
+	affinity := NewAffinity()
+
+	var wg sync.WaitGroup
+	selected := make([]*SingleEndpoint, 16)
+	for i := range selected {
+		wg.Add(1)
+		go func(i int) {
+			defer wg.Done()
+			selected[i] = affinity.Select(ec, nil)
+		}(i)
+	}
+	wg.Wait()
+
+	for i, endpoint := range selected {
+		if e, a := selected[0], endpoint; e != a {
+			t.Errorf("%d expect %s, got %s", i, e.URL, a.URL)
+		}
+	}
+}
@@ -0,0 +1,217 @@
+// Copyright 2020 Baidu, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License"); you may not use this file
+// except in compliance with the License. You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software distributed under the
+// License is distributed on an "AS IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND,
+// either express or implied. See the License for the specific language governing permissions
+// and limitations under the License.
+
+package endpoints
+
+import (
+	"context"
+	"crypto/tls"
+	"fmt"
+	"net"
+	"net/http"
+	"sync"
+	"time"
+)
+
+// the pools of connections of the endpoints of a collection, one per
+// endpoint and base client
+type endpointClients struct {
+	mutex   sync.Mutex
+	clients map[endpointClientKey]*http.Client
+}
+
+type endpointClientKey struct {
+	base           *http.Client
+	URL            string
+	tlsServerName  string
+	maxConnections int
+	maxIdleConns   int
+	connect        time.Duration
+	firstByte      time.Duration
+}
+
+// maxConnections returns the number of requests the endpoint serves
+// concurrently, 0 if unbounded
+func (e *EndpointCollection) maxConnections(endpoint *SingleEndpoint) int {
+	if endpoint.MaxConnections > 0 {
+		return endpoint.MaxConnections
+	}
+	return e.options.MaxConnectionsPerEndpoint
+}
+
+// maxIdleConns returns the number of idle connections kept to the endpoint,
+// 0 for the default of the transport
+func (e *EndpointCollection) maxIdleConns(endpoint *SingleEndpoint) int {
+	if endpoint.MaxIdleConnections > 0 {
+		return endpoint.MaxIdleConnections
+	}
+	return e.options.MaxIdleConnsPerEndpoint
+}
+
+// AcquireEndpoint marks the start of a request sent to the endpoint. When
+// the endpoint already serves as many requests as its concurrency limit,
+// the request waits in the queue of the endpoint until one is released.
+// Returns the error of ctx if it is done first. Every successful
+// AcquireEndpoint must be followed by a ReleaseEndpoint.
+func (e *EndpointCollection) AcquireEndpoint(ctx context.Context, endpoint *SingleEndpoint) error {
+	if err := e.limiter(endpoint).acquire(ctx); err != nil {
+		return err
+	}
+	endpoint.Acquire()
+	return nil
+}
+
+// ReleaseEndpoint marks the end of a request started with AcquireEndpoint,
+// the next request queued for the endpoint is let through.
+func (e *EndpointCollection) ReleaseEndpoint(endpoint *SingleEndpoint) {
+	endpoint.Release()
+	e.limiter(endpoint).release()
+}
+
+// HTTPClient returns the client sending the requests of the endpoint. It
+// has a pool of connections of its own, so that a slow endpoint does not
+// hold the connections of the others, bounded by the connection limits of
+// the endpoint. The client is derived from base, nil means
+// http.DefaultClient. Its connections are bounded by the Connect and
+// FirstByte timeouts. A base whose Transport is not an *http.Transport is
+// returned as is, ignoring the TLS server name of the endpoint and the
+// timeouts, and Connect is ignored by a transport with the legacy Dial.
+// What is ignored is logged once.
+func (e *EndpointCollection) HTTPClient(endpoint *SingleEndpoint, base *http.Client,
+	timeouts AttemptTimeouts) *http.Client {
+
+	if base == nil {
+		base = http.DefaultClient
+	}
+	key := endpointClientKey{
+		base:           base,
+		URL:            endpoint.URL,
+		tlsServerName:  endpoint.TLSServerName,
+		maxConnections: e.maxConnections(endpoint),
+		maxIdleConns:   e.maxIdleConns(endpoint),
+		connect:        timeouts.Connect,
+		firstByte:      timeouts.FirstByte,
+	}
+
+	e.clients.mutex.Lock()
+	defer e.clients.mutex.Unlock()
+
+	if client, ok := e.clients.clients[key]; ok {
+		return client
+	}
+
+	transport, ok := base.Transport.(*http.Transport)
+	if base.Transport == nil {
+		transport, ok = http.DefaultTransport.(*http.Transport)
+	}
+	if !ok {
+		if key.tlsServerName != "" {
+			e.logf("WARN: TLS server name %s of %s ignored, the transport of the client is not an *http.Transport",
+				key.tlsServerName, key.URL)
+		}
+		if key.connect > 0 || key.firstByte > 0 {
+			e.logf("WARN: connect and first byte timeouts of %s ignored, the transport of the client is not an *http.Transport",
+				key.URL)
+		}
+		e.storeClient(key, base)
+		return base
+	}
+	transport = cloneTransport(transport)
+	setMaxConnsPerHost(transport, key.maxConnections)
+	if key.maxIdleConns > 0 {
+		transport.MaxIdleConnsPerHost = key.maxIdleConns
+	}
+	if key.tlsServerName != "" {
+		if transport.TLSClientConfig == nil {
+			transport.TLSClientConfig = &tls.Config{}
+		}
+		transport.TLSClientConfig.ServerName = key.tlsServerName
+	}
+	if key.connect > 0 {
+		if transport.DialContext == nil && transport.Dial != nil {
+			// a legacy Dial without context cannot be bounded
+			e.logf("WARN: connect timeout of %s ignored, the transport of the client dials with the legacy Dial",
+				key.URL)
+		} else {
+			transport.DialContext = dialTimeout(transport.DialContext, key.connect)
+			transport.TLSHandshakeTimeout = key.connect
+		}
+	}
+	if key.firstByte > 0 {
+		transport.ResponseHeaderTimeout = key.firstByte
+	}
+
+	client := *base
+	client.Transport = transport
+	e.storeClient(key, &client)
+	return &client
+}
+
+// logf logs with the Logger of the collection, if it has one
+func (e *EndpointCollection) logf(format string, args ...interface{}) {
+	if e.options.Logger != nil {
+		e.options.Logger(fmt.Sprintf(format, args...))
+	}
+}
+
+// must protected by lock
+func (e *EndpointCollection) storeClient(key endpointClientKey, client *http.Client) {
+	if e.clients.clients == nil {
+		e.clients.clients = make(map[endpointClientKey]*http.Client)
+	}
+	e.clients.clients[key] = client
+}
+
+// probeClient returns the client of the prober for the endpoint, bound to
+// its TLS server name if it has one
+func (e *EndpointCollection) probeClient(endpoint *SingleEndpoint) *http.Client {
+	client := e.httpClient
+	if client == nil {
+		client = http.DefaultClient
+	}
+	if endpoint.TLSServerName == "" {
+		return client
+	}
+	return e.HTTPClient(endpoint, client, AttemptTimeouts{})
+}
+
+// dialTimeout bounds the connections established by dial by timeout
+func dialTimeout(dial func(ctx context.Context, network, addr string) (net.Conn, error),
+	timeout time.Duration) func(ctx context.Context, network, addr string) (net.Conn, error) {
+
+	if dial == nil {
+		dial = (&net.Dialer{}).DialContext
+	}
+	return func(ctx context.Context, network, addr string) (net.Conn, error) {
+		ctx, cancel := context.WithTimeout(ctx, timeout)
+		defer cancel()
+		return dial(ctx, network, addr)
+	}
+}
+
+// closeClients closes the idle connections of the endpoints of URL, all
+// of them if URL is empty, and forgets their clients
+func (e *EndpointCollection) closeClients(URL string) {
+	e.clients.mutex.Lock()
+	defer e.clients.mutex.Unlock()
+
+	for key, client := range e.clients.clients {
+		if URL != "" && key.URL != URL {
+			continue
+		}
+		// the connections of a base used as is are not the endpoint's own
+		if client != key.base {
+			client.Transport.(*http.Transport).CloseIdleConnections()
+		}
+		delete(e.clients.clients, key)
+	}
+}
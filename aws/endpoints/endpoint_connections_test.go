@@ -0,0 +1,305 @@
+package endpoints
+
+import (
+	"context"
+	"crypto/tls"
+	"fmt"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+)
+
+func connectionLimits(o *CollectionOptions) {
+	o.MaxConnectionsPerEndpoint = 1
+	o.MaxIdleConnsPerEndpoint = 4
+}
+
+func endpointOf(ec *EndpointCollection, URL string) *SingleEndpoint {
+	for _, endpoint := range ec.activeEndpoints() {
+		if endpoint.URL == URL {
+			return endpoint
+		}
+	}
+	return nil
+}
+
+func TestAcquireEndpointQueue(t *testing.T) {
+	ec := newListCollection(t, connectionLimits, "http://abc1.test:8080", "http://abc2.test:8080 max_connections=2")
+	defer ec.Close()
+
+	limited := endpointOf(ec, "http://abc1.test:8080")
+	if err := ec.AcquireEndpoint(context.Background(), limited); err != nil {
+		t.Fatalf("1 expect no error, got %v", err)
+	}
+
+	// a saturated endpoint is skipped
+	for i := 0; i < 10; i++ {
+		if a := ec.SelectEndpoint(nil); a == limited {
+			t.Fatalf("2 expect %s skipped", a.URL)
+		}
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Millisecond)
+	defer cancel()
+	if err := ec.AcquireEndpoint(ctx, limited); err != context.DeadlineExceeded {
+		t.Errorf("3 expect %v, got %v", context.DeadlineExceeded, err)
+	}
+
+	acquired := make(chan error)
+	go func() {
+		acquired <- ec.AcquireEndpoint(context.Background(), limited)
+	}()
+	select {
+	case err := <-acquired:
+		t.Fatalf("4 expect the request queued, got %v", err)
+	case <-time.After(10 * time.Millisecond):
+	}
+	ec.ReleaseEndpoint(limited)
+	select {
+	case err := <-acquired:
+		if err != nil {
+			t.Errorf("5 expect no error, got %v", err)
+		}
+	case <-time.After(time.Second):
+		t.Fatalf("6 expect the queued request let through")
+	}
+	if e, a := int64(1), limited.Inflight(); e != a {
+		t.Errorf("7 expect %d in flight, got %d", e, a)
+	}
+	ec.ReleaseEndpoint(limited)
+
+	// the limit of the endpoint takes precedence
+	other := endpointOf(ec, "http://abc2.test:8080")
+	for i := 0; i < 2; i++ {
+		if err := ec.AcquireEndpoint(context.Background(), other); err != nil {
+			t.Fatalf("8 expect no error, got %v", err)
+		}
+	}
+	ctx, cancel = context.WithTimeout(context.Background(), 10*time.Millisecond)
+	defer cancel()
+	if err := ec.AcquireEndpoint(ctx, other); err != context.DeadlineExceeded {
+		t.Errorf("9 expect %v, got %v", context.DeadlineExceeded, err)
+	}
+}
+
+func TestEndpointHTTPClient(t *testing.T) {
+	ec := newListCollection(t, connectionLimits, "https://abc1.test:443 tls_server_name=s3.test",
+		"http://abc2.test:8080 max_idle_connections=8")
+	defer ec.Close()
+
+	base := NewHttpClient()
+	first := endpointOf(ec, "https://abc1.test:443")
+	second := endpointOf(ec, "http://abc2.test:8080")
+
+	client := ec.HTTPClient(first, base, AttemptTimeouts{})
+	if client == base {
+		t.Fatalf("expect a client of the endpoint")
+	}
+	if e, a := client, ec.HTTPClient(first, base, AttemptTimeouts{}); e != a {
+		t.Errorf("expect the client reused")
+	}
+	if ec.HTTPClient(second, base, AttemptTimeouts{}) == client {
+		t.Errorf("expect a client per endpoint")
+	}
+
+	transport := client.Transport.(*http.Transport)
+	if transport == base.Transport {
+		t.Errorf("expect a transport of the endpoint")
+	}
+	if e, a := 4, transport.MaxIdleConnsPerHost; e != a {
+		t.Errorf("expect max idle conns %d, got %d", e, a)
+	}
+	if e, a := "s3.test", transport.TLSClientConfig.ServerName; e != a {
+		t.Errorf("expect server name %s, got %s", e, a)
+	}
+	if e, a := 8, ec.HTTPClient(second, base, AttemptTimeouts{}).Transport.(*http.Transport).MaxIdleConnsPerHost; e != a {
+		t.Errorf("expect max idle conns %d, got %d", e, a)
+	}
+
+	// an endpoint leaving the collection drops its client
+	head, num := ec.ParseEndpointFromRgwInfo(&RgwInfo{
+		RgwConfiguration: []*Rgw{{Ip: "abc2.test", Port: "8080"}},
+	})
+	if err := ec.UpdateWholeEndpoitCollection(head, num, 1); err != nil {
+		t.Fatalf("expect nil, got err %v", err)
+	}
+	if ec.HTTPClient(first, base, AttemptTimeouts{}) == client {
+		t.Errorf("expect the client of a removed endpoint dropped")
+	}
+
+	custom := &http.Client{Transport: roundTripperFunc(http.DefaultTransport.RoundTrip)}
+	if ec.HTTPClient(second, custom, AttemptTimeouts{}) != custom {
+		t.Errorf("expect a custom transport used as is")
+	}
+}
+
+type roundTripperFunc func(*http.Request) (*http.Response, error)
+
+func (f roundTripperFunc) RoundTrip(r *http.Request) (*http.Response, error) {
+	return f(r)
+}
+
+func TestNewHttpClientWithConfig(t *testing.T) {
+	client := NewHttpClientWithConfig(TransportConfig{
+		ResponseHeaderTimeout: time.Second,
+		IdleConnTimeout:       time.Minute,
+		MaxIdleConnsPerHost:   2,
+	})
+	transport := client.Transport.(*http.Transport)
+	if e, a := time.Second, transport.ResponseHeaderTimeout; e != a {
+		t.Errorf("expect %v, got %v", e, a)
+	}
+	if e, a := time.Minute, transport.IdleConnTimeout; e != a {
+		t.Errorf("expect %v, got %v", e, a)
+	}
+	if e, a := 2, transport.MaxIdleConnsPerHost; e != a {
+		t.Errorf("expect %d, got %d", e, a)
+	}
+	if e, a := defaultMaxIdleConns, transport.MaxIdleConns; e != a {
+		t.Errorf("expect %d, got %d", e, a)
+	}
+}
+
+func TestEndpointHTTPClientAttemptTimeouts(t *testing.T) {
+	ec := newListCollection(t, connectionLimits, "http://abc1.test:8080")
+	defer ec.Close()
+
+	base := NewHttpClient()
+	endpoint := endpointOf(ec, "http://abc1.test:8080")
+	timeouts := AttemptTimeouts{Connect: 20 * time.Millisecond, FirstByte: time.Second}
+
+	client := ec.HTTPClient(endpoint, base, timeouts)
+	if client == ec.HTTPClient(endpoint, base, AttemptTimeouts{}) {
+		t.Errorf("expect a client per timeouts")
+	}
+	transport := client.Transport.(*http.Transport)
+	if e, a := time.Second, transport.ResponseHeaderTimeout; e != a {
+		t.Errorf("expect response header timeout %v, got %v", e, a)
+	}
+	if e, a := 20*time.Millisecond, transport.TLSHandshakeTimeout; e != a {
+		t.Errorf("expect TLS handshake timeout %v, got %v", e, a)
+	}
+
+	dial := dialTimeout(func(ctx context.Context, network, addr string) (net.Conn, error) {
+		<-ctx.Done()
+		return nil, ctx.Err()
+	}, timeouts.Connect)
+	start := time.Now()
+	if _, err := dial(context.Background(), "tcp", "abc1.test:8080"); err != context.DeadlineExceeded {
+		t.Errorf("expect %v, got %v", context.DeadlineExceeded, err)
+	}
+	if elapsed := time.Since(start); elapsed > time.Second {
+		t.Errorf("expect the dial bounded by the connect timeout, took %v", elapsed)
+	}
+}
+
+func TestProbeTLSServerName(t *testing.T) {
+	serverNames := make(chan string, 10)
+	server := httptest.NewUnstartedServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusNotFound)
+	}))
+	server.TLS = &tls.Config{
+		GetConfigForClient: func(hello *tls.ClientHelloInfo) (*tls.Config, error) {
+			serverNames <- hello.ServerName
+			return nil, nil
+		},
+	}
+	server.StartTLS()
+	defer server.Close()
+
+	// not started, so that only this test probes
+	ec := newEndpointCollection(100, nil)
+	defer ec.Close()
+	endpoints, _ := parseEndpointList([]string{server.URL + " tls_server_name=example.com"})
+	if err := ec.useEndpoints(endpoints, nil, true); err != nil {
+		t.Fatalf("1 expect nil, got err %v", err)
+	}
+	ec.httpClient = &http.Client{Transport: &http.Transport{
+		TLSClientConfig: &tls.Config{InsecureSkipVerify: true},
+	}}
+
+	endpoint := endpointOf(ec, server.URL)
+	if err := ec.check(context.Background(), endpoint); err != nil {
+		t.Fatalf("2 expect the probe to succeed, got %v", err)
+	}
+	if e, a := "example.com", <-serverNames; e != a {
+		t.Errorf("3 expect server name %s, got %s", e, a)
+	}
+}
+
+func TestEndpointHTTPClientCustomTransport(t *testing.T) {
+	var logs []string
+	ec := newListCollection(t, func(o *CollectionOptions) {
+		o.Logger = func(args ...interface{}) {
+			logs = append(logs, fmt.Sprint(args...))
+		}
+	}, "https://10.0.0.1:443 tls_server_name=s3.test")
+	defer ec.Close()
+	endpoint := endpointOf(ec, "https://10.0.0.1:443")
+
+	custom := &http.Client{Transport: roundTripperFunc(http.DefaultTransport.RoundTrip)}
+	for i := 0; i < 2; i++ {
+		if ec.HTTPClient(endpoint, custom, AttemptTimeouts{}) != custom {
+			t.Fatalf("expect a custom transport used as is")
+		}
+	}
+
+	warnings := 0
+	for _, log := range logs {
+		if strings.Contains(log, "TLS server name s3.test of https://10.0.0.1:443 ignored") {
+			warnings++
+		}
+	}
+	if e, a := 1, warnings; e != a {
+		t.Errorf("expect %d warning, got %d in %v", e, a, logs)
+	}
+
+	// the custom transport is forgotten, not closed
+	ec.closeClients("")
+}
+
+func TestEndpointHTTPClientIgnoredTimeouts(t *testing.T) {
+	var mutex sync.Mutex
+	var logs []string
+	ec := newListCollection(t, func(o *CollectionOptions) {
+		o.Logger = func(args ...interface{}) {
+			mutex.Lock()
+			defer mutex.Unlock()
+			logs = append(logs, fmt.Sprint(args...))
+		}
+	}, "http://abc1.test:8080")
+	defer ec.Close()
+	endpoint := endpointOf(ec, "http://abc1.test:8080")
+	timeouts := AttemptTimeouts{Connect: time.Second, FirstByte: time.Second}
+
+	custom := &http.Client{Transport: roundTripperFunc(http.DefaultTransport.RoundTrip)}
+	ec.HTTPClient(endpoint, custom, timeouts)
+
+	legacy := &http.Client{Transport: &http.Transport{Dial: net.Dial}}
+	transport := ec.HTTPClient(endpoint, legacy, timeouts).Transport.(*http.Transport)
+	if e, a := time.Duration(0), transport.TLSHandshakeTimeout; e != a {
+		t.Errorf("expect no TLS handshake timeout, got %v", a)
+	}
+	if e, a := time.Second, transport.ResponseHeaderTimeout; e != a {
+		t.Errorf("expect response header timeout %v, got %v", e, a)
+	}
+
+	mutex.Lock()
+	defer mutex.Unlock()
+	for _, warning := range []string{
+		"connect and first byte timeouts of http://abc1.test:8080 ignored",
+		"connect timeout of http://abc1.test:8080 ignored, the transport of the client dials with the legacy Dial",
+	} {
+		found := false
+		for _, log := range logs {
+			found = found || strings.Contains(log, warning)
+		}
+		if !found {
+			t.Errorf("expect warning %q in %v", warning, logs)
+		}
+	}
+}
@@ -0,0 +1,188 @@
+// Copyright 2020 Baidu, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License"); you may not use this file
+// except in compliance with the License. You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software distributed under the
+// License is distributed on an "AS IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND,
+// either express or implied. See the License for the specific language governing permissions
+// and limitations under the License.
+
+package endpoints
+
+import (
+	"fmt"
+	"sync"
+	"time"
+)
+
+// EndpointEventType is the kind of change of an EndpointEvent.
+type EndpointEventType int
+
+const (
+	// EndpointAdded is emitted when an endpoint joins the collection.
+	EndpointAdded EndpointEventType = iota + 1
+
+	// EndpointRemoved is emitted when an endpoint leaves the collection.
+	EndpointRemoved
+
+	// EndpointBlacklisted is emitted when the circuit breaker of an
+	// endpoint opens and the endpoint stops taking requests.
+	EndpointBlacklisted
+
+	// EndpointRecovered is emitted when a blacklisted endpoint takes
+	// requests again.
+	EndpointRecovered
+
+	// EpochChanged is emitted when the collection takes the endpoint list
+	// of another epoch from the server.
+	EpochChanged
+
+	// PoolExhausted is emitted when the last active endpoint is
+	// blacklisted. Requests fail until an endpoint recovers.
+	PoolExhausted
+
+	// EndpointProbed is emitted with the result of each health probe.
+	EndpointProbed
+)
+
+var endpointEventTypeNames = map[EndpointEventType]string{
+	EndpointAdded:       "EndpointAdded",
+	EndpointRemoved:     "EndpointRemoved",
+	EndpointBlacklisted: "EndpointBlacklisted",
+	EndpointRecovered:   "EndpointRecovered",
+	EpochChanged:        "EpochChanged",
+	PoolExhausted:       "PoolExhausted",
+	EndpointProbed:      "EndpointProbed",
+}
+
+func (t EndpointEventType) String() string {
+	if name, ok := endpointEventTypeNames[t]; ok {
+		return name
+	}
+	return fmt.Sprintf("EndpointEventType(%d)", int(t))
+}
+
+// EndpointEvent is a change of an EndpointCollection.
+type EndpointEvent struct {
+	Type EndpointEventType
+	Time time.Time
+
+	// The URL of the endpoint, empty for EpochChanged and PoolExhausted.
+	URL string
+
+	// The epoch of the endpoint list, for EpochChanged.
+	Epoch int
+
+	// The error of a failed probe, for EndpointProbed.
+	Err error
+}
+
+func (ev EndpointEvent) String() string {
+	switch ev.Type {
+	case EpochChanged:
+		return fmt.Sprintf("%s %d", ev.Type, ev.Epoch)
+	case PoolExhausted:
+		return ev.Type.String()
+	case EndpointProbed:
+		if ev.Err != nil {
+			return fmt.Sprintf("%s %s failed, %v", ev.Type, ev.URL, ev.Err)
+		}
+		return fmt.Sprintf("%s %s", ev.Type, ev.URL)
+	default:
+		return fmt.Sprintf("%s %s", ev.Type, ev.URL)
+	}
+}
+
+// endpointEvents delivers the events of a collection to its subscribers
+type endpointEvents struct {
+	mutex       sync.Mutex
+	subscribers map[chan EndpointEvent]struct{}
+
+	// the events emitted with the lock of the collection held, and whether
+	// a goroutine is delivering them, protected by the lock of the
+	// collection
+	pending    []EndpointEvent
+	delivering bool
+}
+
+// Subscribe returns a channel receiving the events of the collection, and a
+// function ending the subscription which closes the channel. The
+// collection never waits for a subscriber, the events which do not fit in
+// the buffer of the channel are dropped.
+func (e *EndpointCollection) Subscribe(buffer int) (<-chan EndpointEvent, func()) {
+	if buffer < 0 {
+		buffer = 0
+	}
+	ch := make(chan EndpointEvent, buffer)
+
+	e.events.mutex.Lock()
+	if e.events.subscribers == nil {
+		e.events.subscribers = make(map[chan EndpointEvent]struct{})
+	}
+	e.events.subscribers[ch] = struct{}{}
+	e.events.mutex.Unlock()
+
+	var once sync.Once
+	return ch, func() {
+		once.Do(func() {
+			e.events.mutex.Lock()
+			delete(e.events.subscribers, ch)
+			e.events.mutex.Unlock()
+			close(ch)
+		})
+	}
+}
+
+// emit queues an event for the subscribers and the logger of the
+// collection, it is delivered once the lock is released by unlock
+// must protected by lock
+func (e *EndpointCollection) emit(typ EndpointEventType, URL string) {
+	e.emitEvent(EndpointEvent{Type: typ, Time: time.Now(), URL: URL})
+}
+
+// must protected by lock
+func (e *EndpointCollection) emitEvent(ev EndpointEvent) {
+	e.events.pending = append(e.events.pending, ev)
+}
+
+// unlock releases the lock of the collection, then delivers the events
+// emitted while it was held, so that neither the logger nor the subscribers
+// run with the lock held. One goroutine delivers at a time, the others
+// leave it their events, so the events keep their order.
+func (e *EndpointCollection) unlock() {
+	if e.events.delivering || len(e.events.pending) == 0 {
+		e.mutex.Unlock()
+		return
+	}
+
+	e.events.delivering = true
+	for len(e.events.pending) > 0 {
+		pending := e.events.pending
+		e.events.pending = nil
+		e.mutex.Unlock()
+		for _, ev := range pending {
+			e.deliver(ev)
+		}
+		e.mutex.Lock()
+	}
+	e.events.delivering = false
+	e.mutex.Unlock()
+}
+
+func (e *EndpointCollection) deliver(ev EndpointEvent) {
+	if e.options.LogEvents && e.options.Logger != nil {
+		e.options.Logger(fmt.Sprintf("DEBUG: Endpoint event %s", ev))
+	}
+
+	e.events.mutex.Lock()
+	defer e.events.mutex.Unlock()
+	for ch := range e.events.subscribers {
+		select {
+		case ch <- ev:
+		default:
+		}
+	}
+}
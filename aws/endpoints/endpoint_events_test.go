@@ -0,0 +1,197 @@
+package endpoints
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net/http"
+	"sort"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+)
+
+// drain returns the events received so far
+func drain(ch <-chan EndpointEvent) []string {
+	var events []string
+	for {
+		select {
+		case ev := <-ch:
+			events = append(events, ev.String())
+		default:
+			return events
+		}
+	}
+}
+
+func TestEndpointEvents(t *testing.T) {
+	path, cleanup := writeEndpointsFile(t, "http://abc1.test:8080", "http://abc2.test:8080")
+	defer cleanup()
+	ec := newSeedCollection(t, path)
+
+	var logged []string
+	var mutex sync.Mutex
+	ec.options.Logger = func(args ...interface{}) {
+		mutex.Lock()
+		defer mutex.Unlock()
+		logged = append(logged, fmt.Sprint(args...))
+	}
+	ec.options.LogEvents = true
+
+	events, unsubscribe := ec.Subscribe(100)
+
+	for ec.SelectEndpoint(nil) != nil {
+		ec.AddEndpointToBlacklist(ec.SelectEndpoint(nil))
+	}
+	a := drain(events)
+	if e := 3; len(a) != e {
+		t.Fatalf("1 expect %d events, got %v", e, a)
+	}
+	if e := "PoolExhausted"; a[2] != e {
+		t.Errorf("2 expect %s, got %s", e, a[2])
+	}
+	for _, ev := range a[:2] {
+		if !strings.HasPrefix(ev, "EndpointBlacklisted http://abc") {
+			t.Errorf("3 expect blacklisted endpoint, got %s", ev)
+		}
+	}
+
+	if n := ec.rmEndpointsFromBlacklist([]string{"http://abc1.test:8080"}); n != 1 {
+		t.Fatalf("4 expect endpoint recovered")
+	}
+	if e, a := "EndpointRecovered http://abc1.test:8080", strings.Join(drain(events), ","); e != a {
+		t.Errorf("5 expect %s, got %s", e, a)
+	}
+
+	// a new list from the server
+	head, num := ec.ParseEndpointFromRgwInfo(&RgwInfo{
+		RgwConfiguration: []*Rgw{
+			{Ip: "abc2.test", Port: "8080"},
+			{Ip: "abc3.test", Port: "8080"},
+		},
+	})
+	if err := ec.UpdateWholeEndpoitCollection(head, num, 5); err != nil {
+		t.Fatalf("6 expect nil, got err %v", err)
+	}
+	e := "EpochChanged 5," +
+		"EndpointRecovered http://abc2.test:8080," +
+		"EndpointAdded http://abc3.test:8080," +
+		"EndpointRemoved http://abc1.test:8080"
+	if a := strings.Join(drain(events), ","); e != a {
+		t.Errorf("7 expect %s, got %s", e, a)
+	}
+
+	// the same epoch again
+	head, num = ec.ParseEndpointFromRgwInfo(&RgwInfo{
+		RgwConfiguration: []*Rgw{{Ip: "abc2.test", Port: "8080"}, {Ip: "abc3.test", Port: "8080"}},
+	})
+	if err := ec.UpdateWholeEndpoitCollection(head, num, 5); err != nil {
+		t.Fatalf("8 expect nil, got err %v", err)
+	}
+	if a := drain(events); len(a) != 0 {
+		t.Errorf("9 expect no event, got %v", a)
+	}
+
+	unsubscribe()
+	unsubscribe()
+	if _, ok := <-events; ok {
+		t.Errorf("10 expect channel closed")
+	}
+	ec.AddEndpointToBlacklist(ec.SelectEndpoint(nil))
+
+	mutex.Lock()
+	defer mutex.Unlock()
+	if e, a := 9, len(logged); e != a {
+		t.Fatalf("11 expect %d logs, got %v", e, logged)
+	}
+	if e, a := "DEBUG: Endpoint event PoolExhausted", logged[2]; e != a {
+		t.Errorf("12 expect %s, got %s", e, a)
+	}
+}
+
+func TestEndpointEventsSlowSubscriber(t *testing.T) {
+	ec := newIdleCollection(t, 100, CollectionOptions{
+		CircuitBreaker: CircuitBreakerConfig{FailureThreshold: 1},
+	})
+	events, unsubscribe := ec.Subscribe(1)
+	defer unsubscribe()
+
+	// the collection does not wait for the subscriber
+	for ec.SelectEndpoint(nil) != nil {
+		ec.AddEndpointToBlacklist(ec.SelectEndpoint(nil))
+	}
+	if a := drain(events); len(a) != 1 || !strings.HasPrefix(a[0], "EndpointBlacklisted") {
+		t.Errorf("expect first event only, got %v", a)
+	}
+}
+
+func TestEndpointEventsMerge(t *testing.T) {
+	path, cleanup := writeEndpointsFile(t, "http://abc1.test:8080", "http://abc2.test:8080")
+	defer cleanup()
+	ec := newSeedCollection(t, path)
+	events, unsubscribe := ec.Subscribe(10)
+	defer unsubscribe()
+
+	rewriteEndpointsFile(t, path, "http://abc2.test:8080", "http://abc3.test:8080")
+	if !ec.reloadSeed() {
+		t.Fatalf("1 expect seeds reloaded")
+	}
+	if e, a := "EndpointRemoved http://abc1.test:8080,EndpointAdded http://abc3.test:8080",
+		strings.Join(drain(events), ","); e != a {
+		t.Errorf("2 expect %s, got %s", e, a)
+	}
+}
+
+func TestEndpointProbedEvents(t *testing.T) {
+	checker := HealthCheckerFunc(func(ctx context.Context, client *http.Client,
+		endpoint *SingleEndpoint) error {
+		if endpoint.Host == "abc2.test" {
+			return errors.New("bad endpoint")
+		}
+		return nil
+	})
+	ec := newIdleCollection(t, 100, CollectionOptions{HealthChecker: checker})
+	events, unsubscribe := ec.Subscribe(10)
+	defer unsubscribe()
+
+	ec.probeAll([]*SingleEndpoint{
+		newTestEndpoint(t, "http://abc1.test:8080"),
+		newTestEndpoint(t, "http://abc2.test:8080"),
+	})
+
+	a := drain(events)
+	sort.Strings(a)
+	e := "EndpointProbed http://abc1.test:8080," +
+		"EndpointProbed http://abc2.test:8080 failed, bad endpoint"
+	if strings.Join(a, ",") != e {
+		t.Errorf("expect %s, got %v", e, a)
+	}
+}
+
+func TestEndpointEventsOutsideLock(t *testing.T) {
+	path, cleanup := writeEndpointsFile(t, "http://abc1.test:8080", "http://abc2.test:8080")
+	defer cleanup()
+	ec := newSeedCollection(t, path)
+
+	// the logger may use the collection, it does not run with its lock held
+	var scores []int
+	ec.options.Logger = func(args ...interface{}) {
+		scores = append(scores, len(ec.Scores()))
+	}
+	ec.options.LogEvents = true
+
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		ec.AddEndpointToBlacklist(findActive(ec, "http://abc1.test:8080"))
+	}()
+	select {
+	case <-done:
+	case <-time.After(5 * time.Second):
+		t.Fatalf("expect the logger not to deadlock")
+	}
+	if e, a := "[2]", fmt.Sprint(scores); e != a {
+		t.Errorf("expect scores %s when logged, got %s", e, a)
+	}
+}
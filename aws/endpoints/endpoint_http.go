@@ -27,22 +27,84 @@ const (
 	defaultResponseHeaderTimeout = 20 * time.Second
 )
 
+// TransportConfig configures the connections of an http client built by
+// NewHttpClientWithConfig, such as the client probing the endpoints of a
+// collection. Zero values are replaced by the defaults.
+type TransportConfig struct {
+	// Timeout of establishing a connection. Defaults to 30 seconds.
+	DialTimeout time.Duration
+
+	// Time to wait for the response headers once the request is written.
+	// Defaults to 20 seconds.
+	ResponseHeaderTimeout time.Duration
+
+	// Time an idle connection is kept open. Defaults to keeping it until
+	// it is closed by the server.
+	IdleConnTimeout time.Duration
+
+	// Maximum number of idle connections. Defaults to 1000.
+	MaxIdleConns int
+
+	// Maximum number of idle connections to one host. Defaults to 500.
+	MaxIdleConnsPerHost int
+}
+
+func (c TransportConfig) withDefaults() TransportConfig {
+	if c.DialTimeout <= 0 {
+		c.DialTimeout = defaultDialTimeout
+	}
+	if c.ResponseHeaderTimeout <= 0 {
+		c.ResponseHeaderTimeout = defaultResponseHeaderTimeout
+	}
+	if c.IdleConnTimeout <= 0 {
+		c.IdleConnTimeout = defaultIdleConnTimeout
+	}
+	if c.MaxIdleConns <= 0 {
+		c.MaxIdleConns = defaultMaxIdleConns
+	}
+	if c.MaxIdleConnsPerHost <= 0 {
+		c.MaxIdleConnsPerHost = defaultMaxIdleConnsPerHost
+	}
+	return c
+}
+
+// AttemptTimeouts bound each attempt of a request sent to an endpoint,
+// apart from the timeout of the whole request. An attempt timing out counts
+// as a network error of its endpoint, and the request is retried on another
+// endpoint. Zero means no timeout.
+type AttemptTimeouts struct {
+	// Time to establish a connection, TLS handshake included. Ignored when
+	// the transport is not an *http.Transport or dials with the legacy
+	// Dial.
+	Connect time.Duration
+
+	// Time to wait for the response headers once the request is written.
+	// Ignored when the transport is not an *http.Transport.
+	FirstByte time.Duration
+
+	// Maximum time between two reads of the response body. A streaming
+	// body is read once the request returned, its read fails but the
+	// request is not retried.
+	ReadIdle time.Duration
+}
+
 // build a new http client
 func NewHttpClient() *http.Client {
+	return NewHttpClientWithConfig(TransportConfig{})
+}
+
+// NewHttpClientWithConfig builds a new http client with the timeouts and
+// limits of cfg.
+func NewHttpClientWithConfig(cfg TransportConfig) *http.Client {
+	cfg = cfg.withDefaults()
 	httpClient := &http.Client{}
 	transport := &http.Transport{
-		MaxIdleConns:          defaultMaxIdleConns,
-		MaxIdleConnsPerHost:   defaultMaxIdleConnsPerHost,
-		IdleConnTimeout:       defaultIdleConnTimeout,
-		ResponseHeaderTimeout: defaultResponseHeaderTimeout,
+		MaxIdleConns:          cfg.MaxIdleConns,
+		MaxIdleConnsPerHost:   cfg.MaxIdleConnsPerHost,
+		IdleConnTimeout:       cfg.IdleConnTimeout,
+		ResponseHeaderTimeout: cfg.ResponseHeaderTimeout,
 		//DisableKeepAlives: true,
-		Dial: func(network, address string) (net.Conn, error) {
-			conn, err := net.DialTimeout(network, address, defaultDialTimeout)
-			if err != nil {
-				return nil, err
-			}
-			return conn, nil
-		},
+		DialContext: (&net.Dialer{Timeout: cfg.DialTimeout}).DialContext,
 	}
 	httpClient.Transport = transport
 
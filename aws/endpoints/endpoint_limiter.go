@@ -0,0 +1,267 @@
+// Copyright 2020 Baidu, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License"); you may not use this file
+// except in compliance with the License. You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software distributed under the
+// License is distributed on an "AS IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND,
+// either express or implied. See the License for the specific language governing permissions
+// and limitations under the License.
+
+package endpoints
+
+import (
+	"context"
+	"sync"
+	"time"
+)
+
+const (
+	// DefaultConcurrencyInitialLimit is the default concurrency limit of an
+	// endpoint before any request completed.
+	DefaultConcurrencyInitialLimit = 20
+
+	// DefaultConcurrencyMinLimit is the default lower bound of the
+	// concurrency limit.
+	DefaultConcurrencyMinLimit = 1
+
+	// DefaultConcurrencyMaxLimit is the default upper bound of the
+	// concurrency limit.
+	DefaultConcurrencyMaxLimit = 1000
+
+	// DefaultConcurrencyBackoff is the default ratio the limit is
+	// multiplied by when the endpoint is overloaded.
+	DefaultConcurrencyBackoff = 0.9
+
+	// DefaultConcurrencyLatencyTolerance is the default multiple of the
+	// baseline latency above which a request counts as slow.
+	DefaultConcurrencyLatencyTolerance = 2.0
+
+	// weight of a new latency sample in the baseline latency
+	baselineLatencyDecay = 0.05
+)
+
+// ConcurrencyLimitConfig configures the adaptive concurrency limit of each
+// endpoint of a collection. The limit of an endpoint grows by one after a
+// limit's worth of fast requests, and is multiplied by Backoff after every
+// throttled, failed or slow request. Requests beyond the limit wait in the
+// queue of the endpoint. Zero values are replaced by the defaults.
+type ConcurrencyLimitConfig struct {
+	// The limit before any request completed.
+	InitialLimit int
+
+	// Lower bound of the limit.
+	MinLimit int
+
+	// Upper bound of the limit. The MaxConnections of an endpoint bounds
+	// its limit too.
+	MaxLimit int
+
+	// Ratio the limit is multiplied by when the endpoint is overloaded,
+	// between 0 and 1.
+	Backoff float64
+
+	// A request is slow when its latency exceeds this multiple of the
+	// baseline latency of the endpoint, a moving average of its latencies.
+	LatencyTolerance float64
+}
+
+func (c ConcurrencyLimitConfig) withDefaults() ConcurrencyLimitConfig {
+	if c.MinLimit <= 0 {
+		c.MinLimit = DefaultConcurrencyMinLimit
+	}
+	if c.MaxLimit <= 0 {
+		c.MaxLimit = DefaultConcurrencyMaxLimit
+	}
+	if c.MaxLimit < c.MinLimit {
+		c.MaxLimit = c.MinLimit
+	}
+	if c.InitialLimit <= 0 {
+		c.InitialLimit = DefaultConcurrencyInitialLimit
+	}
+	if c.InitialLimit < c.MinLimit {
+		c.InitialLimit = c.MinLimit
+	}
+	if c.InitialLimit > c.MaxLimit {
+		c.InitialLimit = c.MaxLimit
+	}
+	if c.Backoff <= 0 || c.Backoff >= 1 {
+		c.Backoff = DefaultConcurrencyBackoff
+	}
+	if c.LatencyTolerance <= 1 {
+		c.LatencyTolerance = DefaultConcurrencyLatencyTolerance
+	}
+	return c
+}
+
+// endpointLimiter bounds the requests in flight to an endpoint, to its
+// MaxConnections or to an adaptive limit. The requests beyond the limit
+// wait in order.
+type endpointLimiter struct {
+	once  sync.Once
+	mutex sync.Mutex
+
+	// the static limit, 0 means unbounded, and the adaptive limit, nil
+	// when the limit is static
+	static   int
+	adaptive *ConcurrencyLimitConfig
+	limit    float64
+
+	inUse    int
+	waiters  []chan struct{}
+	baseline float64 // moving average of the latency in nanoseconds
+}
+
+// limiter returns the initialized limiter of the endpoint
+func (e *EndpointCollection) limiter(endpoint *SingleEndpoint) *endpointLimiter {
+	l := &endpoint.limiter
+	l.once.Do(func() {
+		l.static = e.maxConnections(endpoint)
+		if e.options.ConcurrencyLimit != nil {
+			cfg := e.options.ConcurrencyLimit.withDefaults()
+			l.adaptive = &cfg
+			l.limit = float64(cfg.InitialLimit)
+		}
+	})
+	return l
+}
+
+// capacity returns the number of requests let through, 0 if unbounded
+// must protected by lock
+func (l *endpointLimiter) capacity() int {
+	if l.adaptive == nil {
+		return l.static
+	}
+	capacity := int(l.limit)
+	if l.static > 0 && capacity > l.static {
+		capacity = l.static
+	}
+	return capacity
+}
+
+// full reports whether no more request is let through
+// must protected by lock
+func (l *endpointLimiter) full() bool {
+	capacity := l.capacity()
+	return capacity > 0 && l.inUse >= capacity
+}
+
+func (l *endpointLimiter) acquire(ctx context.Context) error {
+	l.mutex.Lock()
+	if !l.full() && len(l.waiters) == 0 {
+		l.inUse++
+		l.mutex.Unlock()
+		return nil
+	}
+	ready := make(chan struct{})
+	l.waiters = append(l.waiters, ready)
+	l.mutex.Unlock()
+
+	select {
+	case <-ready:
+		return nil
+	case <-ctx.Done():
+	}
+
+	l.mutex.Lock()
+	defer l.mutex.Unlock()
+	for i, waiter := range l.waiters {
+		if waiter == ready {
+			l.waiters = append(l.waiters[:i], l.waiters[i+1:]...)
+			return ctx.Err()
+		}
+	}
+	// the request was let through meanwhile, its place goes to the next
+	l.inUse--
+	l.wake()
+	return ctx.Err()
+}
+
+func (l *endpointLimiter) release() {
+	l.mutex.Lock()
+	defer l.mutex.Unlock()
+
+	l.inUse--
+	l.wake()
+}
+
+// wake lets the waiting requests through while there is room
+// must protected by lock
+func (l *endpointLimiter) wake() {
+	for len(l.waiters) > 0 && !l.full() {
+		l.inUse++
+		close(l.waiters[0])
+		l.waiters = l.waiters[1:]
+	}
+}
+
+// saturated reports whether the endpoint serves as many requests as it is
+// allowed to, counting the inflight requests not sent through the limiter
+func (l *endpointLimiter) saturated(inflight int64) bool {
+	l.mutex.Lock()
+	defer l.mutex.Unlock()
+
+	capacity := l.capacity()
+	return l.full() || capacity > 0 && inflight >= int64(capacity)
+}
+
+func (l *endpointLimiter) currentLimit() int {
+	l.mutex.Lock()
+	defer l.mutex.Unlock()
+
+	return l.capacity()
+}
+
+// observe adapts the limit to the outcome of a request: additive increase
+// while the requests are fast, multiplicative decrease once the endpoint
+// is overloaded or slow
+func (l *endpointLimiter) observe(latency time.Duration, overloaded bool) {
+	if l.adaptive == nil {
+		return
+	}
+	l.mutex.Lock()
+	defer l.mutex.Unlock()
+
+	cfg := l.adaptive
+	slow := false
+	if !overloaded {
+		if l.baseline == 0 {
+			l.baseline = float64(latency)
+		} else {
+			slow = float64(latency) > cfg.LatencyTolerance*l.baseline
+			l.baseline += baselineLatencyDecay * (float64(latency) - l.baseline)
+		}
+	}
+
+	switch {
+	case overloaded || slow:
+		l.limit *= cfg.Backoff
+		if l.limit < float64(cfg.MinLimit) {
+			l.limit = float64(cfg.MinLimit)
+		}
+	case 2*l.inUse >= int(l.limit):
+		// only a limit in use grows
+		l.limit += 1 / l.limit
+		if l.limit > float64(cfg.MaxLimit) {
+			l.limit = float64(cfg.MaxLimit)
+		}
+	}
+	l.wake()
+}
+
+// RecordLimitResult adapts the concurrency limit of the endpoint to the
+// outcome of a request, see CollectionOptions.ConcurrencyLimit. overloaded
+// reports whether the endpoint throttled or failed the request.
+func (e *EndpointCollection) RecordLimitResult(endpoint *SingleEndpoint, latency time.Duration,
+	overloaded bool) {
+
+	e.limiter(endpoint).observe(latency, overloaded)
+}
+
+// ConcurrencyLimit returns the number of requests the endpoint currently
+// serves concurrently before queueing them, 0 if unbounded.
+func (e *EndpointCollection) ConcurrencyLimit(endpoint *SingleEndpoint) int {
+	return e.limiter(endpoint).currentLimit()
+}
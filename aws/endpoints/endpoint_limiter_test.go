@@ -0,0 +1,179 @@
+package endpoints
+
+import (
+	"context"
+	"testing"
+	"time"
+)
+
+func concurrencyLimit(cfg ConcurrencyLimitConfig) func(*CollectionOptions) {
+	return func(o *CollectionOptions) {
+		o.ConcurrencyLimit = &cfg
+	}
+}
+
+func TestConcurrencyLimitConfigDefaults(t *testing.T) {
+	cases := map[string]struct {
+		Config, Expect ConcurrencyLimitConfig
+	}{
+		"zero": {
+			Expect: ConcurrencyLimitConfig{
+				InitialLimit:     DefaultConcurrencyInitialLimit,
+				MinLimit:         DefaultConcurrencyMinLimit,
+				MaxLimit:         DefaultConcurrencyMaxLimit,
+				Backoff:          DefaultConcurrencyBackoff,
+				LatencyTolerance: DefaultConcurrencyLatencyTolerance,
+			},
+		},
+		"initial out of bounds": {
+			Config: ConcurrencyLimitConfig{InitialLimit: 50, MinLimit: 2, MaxLimit: 10, Backoff: 0.5, LatencyTolerance: 3},
+			Expect: ConcurrencyLimitConfig{InitialLimit: 10, MinLimit: 2, MaxLimit: 10, Backoff: 0.5, LatencyTolerance: 3},
+		},
+		"invalid backoff": {
+			Config: ConcurrencyLimitConfig{InitialLimit: 4, MinLimit: 8, MaxLimit: 6, Backoff: 1.5, LatencyTolerance: 0.5},
+			Expect: ConcurrencyLimitConfig{
+				InitialLimit: 8, MinLimit: 8, MaxLimit: 8,
+				Backoff:          DefaultConcurrencyBackoff,
+				LatencyTolerance: DefaultConcurrencyLatencyTolerance,
+			},
+		},
+	}
+
+	for name, c := range cases {
+		t.Run(name, func(t *testing.T) {
+			if e, a := c.Expect, c.Config.withDefaults(); e != a {
+				t.Errorf("expect %+v, got %+v", e, a)
+			}
+		})
+	}
+}
+
+func TestConcurrencyLimitAIMD(t *testing.T) {
+	ec := newListCollection(t,
+		concurrencyLimit(ConcurrencyLimitConfig{InitialLimit: 10, MinLimit: 2, MaxLimit: 12}),
+		"http://abc1.test:8080")
+	defer ec.Close()
+	endpoint := endpointOf(ec, "http://abc1.test:8080")
+
+	if e, a := 10, ec.ConcurrencyLimit(endpoint); e != a {
+		t.Fatalf("1 expect limit %d, got %d", e, a)
+	}
+
+	// an idle limit does not grow
+	for i := 0; i < 50; i++ {
+		ec.RecordLimitResult(endpoint, 10*time.Millisecond, false)
+	}
+	if e, a := 10, ec.ConcurrencyLimit(endpoint); e != a {
+		t.Fatalf("2 expect limit %d, got %d", e, a)
+	}
+
+	// a limit in use grows by one per limit's worth of fast requests
+	for i := 0; i < 10; i++ {
+		if err := ec.AcquireEndpoint(context.Background(), endpoint); err != nil {
+			t.Fatalf("3 expect no error, got %v", err)
+		}
+	}
+	for i := 0; i < 11; i++ {
+		ec.RecordLimitResult(endpoint, 10*time.Millisecond, false)
+	}
+	if e, a := 11, ec.ConcurrencyLimit(endpoint); e != a {
+		t.Fatalf("4 expect limit %d, got %d", e, a)
+	}
+	for i := 0; i < 100; i++ {
+		ec.RecordLimitResult(endpoint, 10*time.Millisecond, false)
+	}
+	if e, a := 12, ec.ConcurrencyLimit(endpoint); e != a {
+		t.Fatalf("5 expect limit capped to %d, got %d", e, a)
+	}
+
+	// throttling shrinks it
+	ec.RecordLimitResult(endpoint, 10*time.Millisecond, true)
+	if e, a := 10, ec.ConcurrencyLimit(endpoint); e != a {
+		t.Fatalf("6 expect limit %d, got %d", e, a)
+	}
+
+	// so does a latency above the tolerance of the baseline
+	ec.RecordLimitResult(endpoint, 50*time.Millisecond, false)
+	if e, a := 9, ec.ConcurrencyLimit(endpoint); e != a {
+		t.Fatalf("7 expect limit %d, got %d", e, a)
+	}
+
+	for i := 0; i < 50; i++ {
+		ec.RecordLimitResult(endpoint, 10*time.Millisecond, true)
+	}
+	if e, a := 2, ec.ConcurrencyLimit(endpoint); e != a {
+		t.Fatalf("8 expect limit floored to %d, got %d", e, a)
+	}
+
+	for i := 0; i < 10; i++ {
+		ec.ReleaseEndpoint(endpoint)
+	}
+	if e, a := 2, ec.Scores()[0].ConcurrencyLimit; e != a {
+		t.Errorf("9 expect score limit %d, got %d", e, a)
+	}
+}
+
+func TestConcurrencyLimitQueue(t *testing.T) {
+	ec := newListCollection(t, concurrencyLimit(ConcurrencyLimitConfig{InitialLimit: 2, MinLimit: 1}),
+		"http://abc1.test:8080", "http://abc2.test:8080")
+	defer ec.Close()
+	endpoint := endpointOf(ec, "http://abc1.test:8080")
+
+	for i := 0; i < 2; i++ {
+		if err := ec.AcquireEndpoint(context.Background(), endpoint); err != nil {
+			t.Fatalf("1 expect no error, got %v", err)
+		}
+	}
+
+	// a saturated endpoint is skipped
+	for i := 0; i < 10; i++ {
+		if a := ec.SelectEndpoint(nil); a == endpoint {
+			t.Fatalf("2 expect %s skipped", a.URL)
+		}
+	}
+
+	first, second := make(chan error), make(chan error)
+	go func() {
+		first <- ec.AcquireEndpoint(context.Background(), endpoint)
+	}()
+	time.Sleep(10 * time.Millisecond)
+	ctx, cancel := context.WithCancel(context.Background())
+	go func() {
+		second <- ec.AcquireEndpoint(ctx, endpoint)
+	}()
+	time.Sleep(10 * time.Millisecond)
+
+	// a shrinking limit keeps the requests queued
+	ec.RecordLimitResult(endpoint, time.Millisecond, true)
+	ec.ReleaseEndpoint(endpoint)
+	select {
+	case err := <-first:
+		t.Fatalf("3 expect the request queued, got %v", err)
+	case <-time.After(10 * time.Millisecond):
+	}
+
+	// the queued requests are let through in order
+	ec.ReleaseEndpoint(endpoint)
+	select {
+	case err := <-first:
+		if err != nil {
+			t.Fatalf("4 expect no error, got %v", err)
+		}
+	case <-time.After(time.Second):
+		t.Fatalf("5 expect the first request let through")
+	}
+
+	cancel()
+	if e, a := context.Canceled, <-second; e != a {
+		t.Fatalf("6 expect %v, got %v", e, a)
+	}
+	ec.ReleaseEndpoint(endpoint)
+
+	if e, a := int64(0), endpoint.Inflight(); e != a {
+		t.Errorf("7 expect %d in flight, got %d", e, a)
+	}
+	if err := ec.AcquireEndpoint(context.Background(), endpoint); err != nil {
+		t.Errorf("8 expect no error, got %v", err)
+	}
+	ec.ReleaseEndpoint(endpoint)
+}
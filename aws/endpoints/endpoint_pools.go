@@ -0,0 +1,125 @@
+// Copyright 2020 Baidu, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License"); you may not use this file
+// except in compliance with the License. You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software distributed under the
+// License is distributed on an "AS IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND,
+// either express or implied. See the License for the specific language governing permissions
+// and limitations under the License.
+
+package endpoints
+
+import (
+	"fmt"
+	"path"
+)
+
+// PoolRoute routes the requests matching it to a named pool of endpoints.
+// A request matches when its bucket matches one of Buckets and its
+// operation is one of Operations. An empty list matches everything.
+type PoolRoute struct {
+	// The name of the pool. The empty name is the default pool, the
+	// collection of Config.EndpointsPath.
+	Pool string
+
+	// Patterns of bucket names, in the syntax of path.Match, such as
+	// "logs-*".
+	Buckets []string
+
+	// Names of API operations, such as "ListObjects".
+	Operations []string
+}
+
+// Match returns true if the request of operation on bucket matches the
+// route.
+func (r PoolRoute) Match(bucket, operation string) bool {
+	return r.matchBucket(bucket) && r.matchOperation(operation)
+}
+
+func (r PoolRoute) matchBucket(bucket string) bool {
+	if len(r.Buckets) == 0 {
+		return true
+	}
+	for _, pattern := range r.Buckets {
+		if ok, _ := path.Match(pattern, bucket); ok {
+			return true
+		}
+	}
+	return false
+}
+
+func (r PoolRoute) matchOperation(operation string) bool {
+	if len(r.Operations) == 0 {
+		return true
+	}
+	for _, name := range r.Operations {
+		if name == operation {
+			return true
+		}
+	}
+	return false
+}
+
+// EndpointPools is a set of named endpoint collections and the routes which
+// send requests to them. It is immutable once created.
+type EndpointPools struct {
+	collections map[string]*EndpointCollection
+	routes      []PoolRoute
+}
+
+// NewEndpointPools returns the pools of collections, keyed by pool name,
+// routed by routes. Returns an error if a route refers to an unknown pool or
+// has a malformed bucket pattern.
+func NewEndpointPools(collections map[string]*EndpointCollection,
+	routes []PoolRoute) (*EndpointPools, error) {
+
+	pools := &EndpointPools{
+		collections: make(map[string]*EndpointCollection, len(collections)),
+		routes:      append([]PoolRoute(nil), routes...),
+	}
+	for name, collection := range collections {
+		if name == "" || collection == nil {
+			return nil, fmt.Errorf("endpoint pool %q is invalid", name)
+		}
+		pools.collections[name] = collection
+	}
+
+	for _, route := range routes {
+		if _, ok := pools.collections[route.Pool]; !ok && route.Pool != "" {
+			return nil, fmt.Errorf("endpoint pool %q of route is not defined", route.Pool)
+		}
+		for _, pattern := range route.Buckets {
+			if _, err := path.Match(pattern, ""); err != nil {
+				return nil, fmt.Errorf("bucket pattern %q of route is invalid: %v",
+					pattern, err)
+			}
+		}
+	}
+	return pools, nil
+}
+
+// Pool returns the collection of the named pool, or nil if there is none.
+func (p *EndpointPools) Pool(name string) *EndpointCollection {
+	if p == nil {
+		return nil
+	}
+	return p.collections[name]
+}
+
+// Route returns the collection of the first route matching the request of
+// operation on bucket. Returns nil if no route matches or the route is to
+// the default pool.
+func (p *EndpointPools) Route(bucket, operation string) *EndpointCollection {
+	if p == nil {
+		return nil
+	}
+	for _, route := range p.routes {
+		if route.Match(bucket, operation) {
+			return p.collections[route.Pool]
+		}
+	}
+	return nil
+}
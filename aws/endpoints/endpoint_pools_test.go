@@ -0,0 +1,105 @@
+package endpoints
+
+import (
+	"testing"
+)
+
+func TestPoolRouteMatch(t *testing.T) {
+	cases := map[string]struct {
+		route     PoolRoute
+		bucket    string
+		operation string
+		match     bool
+	}{
+		"empty route": {
+			bucket: "any", operation: "GetObject", match: true,
+		},
+		"bucket pattern": {
+			route:  PoolRoute{Buckets: []string{"cold-*", "archive"}},
+			bucket: "cold-2020", operation: "GetObject", match: true,
+		},
+		"bucket name": {
+			route:  PoolRoute{Buckets: []string{"cold-*", "archive"}},
+			bucket: "archive", operation: "GetObject", match: true,
+		},
+		"bucket mismatch": {
+			route:  PoolRoute{Buckets: []string{"cold-*"}},
+			bucket: "hot", operation: "GetObject", match: false,
+		},
+		"operation": {
+			route:  PoolRoute{Operations: []string{"ListObjects", "ListObjectsV2"}},
+			bucket: "hot", operation: "ListObjectsV2", match: true,
+		},
+		"operation mismatch": {
+			route:  PoolRoute{Operations: []string{"ListObjects"}},
+			bucket: "hot", operation: "GetObject", match: false,
+		},
+		"bucket and operation": {
+			route: PoolRoute{
+				Buckets:    []string{"cold-*"},
+				Operations: []string{"ListObjects"},
+			},
+			bucket: "hot", operation: "ListObjects", match: false,
+		},
+	}
+
+	for name, c := range cases {
+		if e, a := c.match, c.route.Match(c.bucket, c.operation); e != a {
+			t.Errorf("%s expect %v, got %v", name, e, a)
+		}
+	}
+}
+
+func TestEndpointPools(t *testing.T) {
+	cold := &EndpointCollection{}
+	meta := &EndpointCollection{}
+	collections := map[string]*EndpointCollection{
+		"cold": cold,
+		"meta": meta,
+	}
+
+	if _, err := NewEndpointPools(collections, []PoolRoute{{Pool: "warm"}}); err == nil {
+		t.Errorf("1 expect error for unknown pool")
+	}
+	if _, err := NewEndpointPools(collections, []PoolRoute{
+		{Pool: "cold", Buckets: []string{"cold-["}},
+	}); err == nil {
+		t.Errorf("2 expect error for malformed pattern")
+	}
+	if _, err := NewEndpointPools(map[string]*EndpointCollection{"": cold}, nil); err == nil {
+		t.Errorf("3 expect error for unnamed pool")
+	}
+
+	pools, err := NewEndpointPools(collections, []PoolRoute{
+		{Pool: "", Buckets: []string{"cold-hot"}},
+		{Pool: "cold", Buckets: []string{"cold-*"}},
+		{Pool: "meta", Operations: []string{"ListObjects"}},
+	})
+	if err != nil {
+		t.Fatalf("4 expect nil, got err %v", err)
+	}
+
+	cases := []struct {
+		bucket, operation string
+		expect            *EndpointCollection
+	}{
+		{"cold-hot", "GetObject", nil},
+		{"cold-1", "ListObjects", cold},
+		{"hot", "ListObjects", meta},
+		{"hot", "GetObject", nil},
+	}
+	for i, c := range cases {
+		if e, a := c.expect, pools.Route(c.bucket, c.operation); e != a {
+			t.Errorf("%d expect %p, got %p", i, e, a)
+		}
+	}
+
+	if e, a := cold, pools.Pool("cold"); e != a {
+		t.Errorf("5 expect %p, got %p", e, a)
+	}
+
+	var none *EndpointPools
+	if coll := none.Route("cold-1", "GetObject"); coll != nil {
+		t.Errorf("6 expect nil, got %p", coll)
+	}
+}
@@ -0,0 +1,109 @@
+// Copyright 2020 Baidu, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License"); you may not use this file
+// except in compliance with the License. You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software distributed under the
+// License is distributed on an "AS IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND,
+// either express or implied. See the License for the specific language governing permissions
+// and limitations under the License.
+
+package endpoints
+
+// endpointSnapshot is an immutable view of the active endpoints of a
+// collection. Every change of the collection is made under its lock and
+// publishes a new snapshot, the request path only loads the current one and
+// never takes the lock.
+type endpointSnapshot struct {
+	// the active endpoints, sorted by HostAndPort
+	endpoints []*SingleEndpoint
+
+	// position of each endpoint in endpoints
+	positions map[*SingleEndpoint]int
+
+	// the active endpoints of the local zone, and the number of endpoints
+	// of the local zone including the blacklisted ones
+	local      []*SingleEndpoint
+	localTotal int
+}
+
+var emptySnapshot = &endpointSnapshot{}
+
+// snapshot returns the current view of the active endpoints
+func (e *EndpointCollection) snapshot() *endpointSnapshot {
+	if s, ok := e.active.Load().(*endpointSnapshot); ok {
+		return s
+	}
+	return emptySnapshot
+}
+
+// activeEndpoints returns the active endpoints of the current snapshot. The
+// slice is shared and must not be modified.
+func (e *EndpointCollection) activeEndpoints() []*SingleEndpoint {
+	return e.snapshot().endpoints
+}
+
+// NumActiveEndpoints returns the number of endpoints currently taking
+// requests.
+func (e *EndpointCollection) NumActiveEndpoints() int {
+	return len(e.activeEndpoints())
+}
+
+// isActive reports whether the endpoint currently takes requests
+func (e *EndpointCollection) isActive(endpoint *SingleEndpoint) bool {
+	_, ok := e.snapshot().positions[endpoint]
+	return ok
+}
+
+// publish builds a new snapshot from the ring of active endpoints
+// must protected by lock
+func (e *EndpointCollection) publish() {
+	if e.endpointHead == nil || e.numOfActiveEndpoint <= 0 {
+		e.active.Store(emptySnapshot)
+		return
+	}
+
+	s := &endpointSnapshot{
+		endpoints: make([]*SingleEndpoint, 0, e.numOfActiveEndpoint),
+		positions: make(map[*SingleEndpoint]int, e.numOfActiveEndpoint),
+	}
+	zone := e.options.LocalZone
+	temp := e.endpointHead
+	for {
+		if temp.Id >= e.validMinEndpointId && !temp.IsInBlackList {
+			s.positions[temp] = len(s.endpoints)
+			s.endpoints = append(s.endpoints, temp)
+			if zone != "" && temp.Zone == zone {
+				s.local = append(s.local, temp)
+			}
+		}
+		temp = temp.next
+		if temp == nil || temp == e.endpointHead {
+			break
+		}
+	}
+
+	if zone != "" {
+		s.localTotal = len(s.local)
+		for _, endpoint := range e.blackList {
+			if endpoint.Id >= e.validMinEndpointId && endpoint.Zone == zone {
+				s.localTotal++
+			}
+		}
+	}
+	e.active.Store(s)
+}
+
+// candidates returns the endpoints of the local zone, or every active
+// endpoint if the active part of the local zone fell below spillover
+func (s *endpointSnapshot) candidates(spillover float64) []*SingleEndpoint {
+	if len(s.local) == 0 {
+		return s.endpoints
+	}
+	if float64(len(s.local)) < spillover*float64(s.localTotal) {
+		return s.endpoints
+	}
+	return s.local
+}
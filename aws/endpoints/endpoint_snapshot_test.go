@@ -0,0 +1,207 @@
+package endpoints
+
+import (
+	"context"
+	"io/ioutil"
+	"math/rand"
+	"net/http"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+)
+
+func TestSnapshot(t *testing.T) {
+	empty := &EndpointCollection{}
+	if e, a := 0, len(empty.activeEndpoints()); e != a {
+		t.Errorf("1 expect %d endpoints, got %d", e, a)
+	}
+	if endpoint := empty.GetRandEndpoint(0); endpoint != nil {
+		t.Errorf("2 expect nil, got %s", endpoint.URL)
+	}
+
+	ec := newIdleCollection(t, 100, CollectionOptions{})
+	before := ec.activeEndpoints()
+	if e, a := 3, len(before); e != a {
+		t.Fatalf("3 expect %d endpoints, got %d", e, a)
+	}
+
+	ec.AddEndpointToBlacklist(before[1])
+	after := ec.activeEndpoints()
+	if e, a := 2, len(after); e != a {
+		t.Errorf("4 expect %d endpoints, got %d", e, a)
+	}
+	// a snapshot never changes once published
+	if e, a := 3, len(before); e != a {
+		t.Errorf("5 expect %d endpoints, got %d", e, a)
+	}
+
+	if e, a := after[1], ec.GetNextEndpoint(after[0]); e != a {
+		t.Errorf("6 expect %s, got %s", e.URL, a.URL)
+	}
+	if e, a := after[0], ec.GetNextEndpoint(after[1]); e != a {
+		t.Errorf("7 expect %s, got %s", e.URL, a.URL)
+	}
+	if e, a := after[1], ec.GetRandEndpoint(1); e != a {
+		t.Errorf("8 expect %s, got %s", e.URL, a.URL)
+	}
+}
+
+func TestConcurrentFailover(t *testing.T) {
+	checker := HealthCheckerFunc(func(ctx context.Context, client *http.Client,
+		endpoint *SingleEndpoint) error {
+		return nil
+	})
+
+	ec, err := NewEndpointCollection(TEST_ENDPOINT_PATH, 1, func(o *CollectionOptions) {
+		o.HealthChecker = checker
+		o.CircuitBreaker = CircuitBreakerConfig{
+			OpenTimeout:       time.Nanosecond,
+			HalfOpenSuccesses: 1,
+		}
+	})
+	if err != nil {
+		t.Fatalf("expect nil, got err %v", err)
+	}
+	defer ec.Close()
+
+	var wg sync.WaitGroup
+	stop := make(chan struct{})
+
+	// the keep alive side, recovering and replacing endpoints
+	wg.Add(1)
+	go func() {
+		defer wg.Done()
+		for i := 0; ; i++ {
+			select {
+			case <-stop:
+				return
+			default:
+			}
+			ec.probeBlacklist()
+			ec.probeEndpointFromSeed()
+			if i%10 == 0 {
+				ec.ReadEndpointsFromFile(TEST_ENDPOINT_PATH, false)
+			}
+			ec.Scores()
+		}
+	}()
+
+	// the request side
+	policies := []BalancePolicy{
+		NewRandomPolicy(),
+		NewRoundRobinPolicy(),
+		NewLeastOutstandingPolicy(),
+		NewLatencyAwarePolicy(),
+	}
+	var requests sync.WaitGroup
+	for i := 0; i < 16; i++ {
+		requests.Add(1)
+		go func(policy BalancePolicy) {
+			defer requests.Done()
+			for j := 0; j < 2000; j++ {
+				endpoint := ec.SelectEndpoint(policy)
+				if endpoint == nil {
+					endpoint = ec.GetRandEndpoint(0)
+				}
+				if endpoint == nil {
+					continue
+				}
+
+				endpoint.Acquire()
+				next := ec.GetNextEndpoint(endpoint)
+				if rand.Intn(4) == 0 {
+					endpoint.RecordResult(time.Millisecond, true)
+					next = ec.AddEndpointToBlacklist(endpoint)
+				} else {
+					endpoint.RecordResult(time.Millisecond, false)
+				}
+				endpoint.Release()
+
+				if next != nil && next.URL == "" {
+					t.Errorf("expect valid endpoint")
+				}
+			}
+		}(policies[i%len(policies)])
+	}
+
+	requests.Wait()
+	close(stop)
+	wg.Wait()
+}
+
+func TestZonePreference(t *testing.T) {
+	dir, err := ioutil.TempDir("", "aws-sdk-go-endpoints-zone")
+	if err != nil {
+		t.Fatalf("expect nil, got err %v", err)
+	}
+	defer os.RemoveAll(dir)
+
+	path := filepath.Join(dir, "endpoints")
+	err = ioutil.WriteFile(path, []byte(strings.Join([]string{
+		"http://a1.test:8080 zone=a",
+		"http://a2.test:8080 zone=a",
+		"http://b1.test:8080 zone=b",
+		"http://c1.test:8080",
+	}, "\n")), 0644)
+	if err != nil {
+		t.Fatalf("expect nil, got err %v", err)
+	}
+
+	newCollection := func(options CollectionOptions) *EndpointCollection {
+		ec := &EndpointCollection{
+			lastEpoch: -1,
+			blackList: make(map[string]*SingleEndpoint),
+			notify:    make(chan bool, 1),
+			options:   options,
+		}
+		if err := ec.ReadEndpointsFromFile(path, true); err != nil {
+			t.Fatalf("expect nil, got err %v", err)
+		}
+		return ec
+	}
+	selected := func(ec *EndpointCollection) map[string]bool {
+		seen := map[string]bool{}
+		for i := 0; i < 100; i++ {
+			seen[ec.SelectEndpoint(nil).HostAndPort] = true
+		}
+		return seen
+	}
+
+	// without a local zone every endpoint is used
+	if e, a := 4, len(selected(newCollection(CollectionOptions{}))); e != a {
+		t.Errorf("1 expect %d endpoints, got %d", e, a)
+	}
+
+	ec := newCollection(CollectionOptions{LocalZone: "a"})
+	if seen := selected(ec); len(seen) != 2 || !seen["a1.test:8080"] || !seen["a2.test:8080"] {
+		t.Errorf("2 expect local endpoints, got %v", seen)
+	}
+	ec.AddEndpointToBlacklist(ec.SelectEndpoint(nil))
+	if e, a := 1, len(selected(ec)); e != a {
+		t.Errorf("3 expect %d endpoints, got %d", e, a)
+	}
+	ec.AddEndpointToBlacklist(ec.SelectEndpoint(nil))
+	if seen := selected(ec); len(seen) != 2 || !seen["b1.test:8080"] || !seen["c1.test:8080"] {
+		t.Errorf("4 expect other zones, got %v", seen)
+	}
+
+	// spill over once less than 60% of the local zone is active
+	ec = newCollection(CollectionOptions{LocalZone: "a", ZoneSpillover: 0.6})
+	ec.AddEndpointToBlacklist(ec.SelectEndpoint(nil))
+	if e, a := 3, len(selected(ec)); e != a {
+		t.Errorf("5 expect %d endpoints, got %d", e, a)
+	}
+
+	// unknown zone
+	ec = newCollection(CollectionOptions{LocalZone: "d"})
+	if e, a := 4, len(selected(ec)); e != a {
+		t.Errorf("6 expect %d endpoints, got %d", e, a)
+	}
+
+	if e, a := "a", ec.Scores()[0].Zone; e != a {
+		t.Errorf("7 expect zone %s, got %s", e, a)
+	}
+}
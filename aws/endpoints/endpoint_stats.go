@@ -0,0 +1,197 @@
+// Copyright 2020 Baidu, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License"); you may not use this file
+// except in compliance with the License. You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software distributed under the
+// License is distributed on an "AS IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND,
+// either express or implied. See the License for the specific language governing permissions
+// and limitations under the License.
+
+package endpoints
+
+import (
+	"math"
+	"sort"
+	"sync"
+	"time"
+)
+
+const (
+	// weight of a new latency sample in the moving average
+	latencyDecay = 0.2
+
+	// weight of a new result in the moving error rate
+	errorRateDecay = 0.1
+
+	// the error rate of an endpoint which receives no traffic halves
+	// every errorRateHalfLife, so a flaky endpoint gets tried again
+	errorRateHalfLife = 30 * time.Second
+
+	// the error rate is capped so that the score of an endpoint never
+	// drops to zero
+	maxErrorRate = 0.99
+)
+
+// passive health of one endpoint, learned from the requests sent to it
+type endpointStats struct {
+	mutex      sync.Mutex
+	samples    uint64
+	latency    float64 // moving average in nanoseconds
+	errorRate  float64
+	lastUpdate time.Time
+}
+
+// EndpointScore is a snapshot of the passive health of an endpoint.
+type EndpointScore struct {
+	// URL of the endpoint.
+	URL string
+
+	// Zone of the endpoint.
+	Zone string
+
+	// Number of request results recorded for the endpoint.
+	Samples uint64
+
+	// Moving average of the request latency. Zero if no sample was recorded.
+	Latency time.Duration
+
+	// Moving average of the failed requests, between 0 and 1.
+	ErrorRate float64
+
+	// Number of requests in flight.
+	Inflight int64
+
+	// State of the circuit breaker.
+	State BreakerState
+
+	// Number of requests served concurrently before queueing them, see
+	// CollectionOptions.ConcurrencyLimit. Zero if unbounded.
+	ConcurrencyLimit int
+
+	// Expected cost of the next request, lower is better. It grows with
+	// the latency, the number of requests in flight and the error rate.
+	// Zero if no sample was recorded.
+	Cost float64
+}
+
+// RecordResult records the outcome of a request sent to the endpoint.
+// failed reports whether the endpoint itself failed the request, such as a
+// network error or a timeout, see request.IsEndpointError.
+func (s *SingleEndpoint) RecordResult(latency time.Duration, failed bool) {
+	s.stats.record(latency, failed, time.Now())
+	if !failed {
+		s.breaker.onSuccess()
+	}
+}
+
+// Score returns a snapshot of the passive health of the endpoint.
+func (s *SingleEndpoint) Score() EndpointScore {
+	latency, errorRate, samples := s.stats.snapshot(time.Now())
+	inflight := s.Inflight()
+
+	score := EndpointScore{
+		URL:       s.URL,
+		Zone:      s.Zone,
+		Samples:   samples,
+		Latency:   time.Duration(latency),
+		ErrorRate: errorRate,
+		Inflight:  inflight,
+		State:     s.BreakerState(),
+	}
+	if samples > 0 {
+		score.Cost = endpointCost(latency, errorRate, inflight)
+	}
+	return score
+}
+
+// Scores returns the score of every endpoint of the collection, the active
+// endpoints first, followed by the blacklisted ones.
+func (e *EndpointCollection) Scores() []EndpointScore {
+	e.mutex.Lock()
+	endpoints := e.activeEndpoints()
+	blacklisted := make([]*SingleEndpoint, 0, len(e.blackList))
+	for _, endpoint := range e.blackList {
+		if endpoint.Id >= e.validMinEndpointId {
+			blacklisted = append(blacklisted, endpoint)
+		}
+	}
+	e.unlock()
+
+	sort.Sort(byHostAndPort(blacklisted))
+	endpoints = append(endpoints, blacklisted...)
+
+	scores := make([]EndpointScore, 0, len(endpoints))
+	for _, endpoint := range endpoints {
+		score := endpoint.Score()
+		score.ConcurrencyLimit = e.ConcurrencyLimit(endpoint)
+		scores = append(scores, score)
+	}
+	return scores
+}
+
+// byHostAndPort sorts endpoints by their HostAndPort
+type byHostAndPort []*SingleEndpoint
+
+func (a byHostAndPort) Len() int           { return len(a) }
+func (a byHostAndPort) Swap(i, j int)      { a[i], a[j] = a[j], a[i] }
+func (a byHostAndPort) Less(i, j int) bool { return a[i].HostAndPort < a[j].HostAndPort }
+
+func (s *endpointStats) record(latency time.Duration, failed bool, now time.Time) {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	result := 0.0
+	if failed {
+		result = 1
+	}
+
+	if s.samples == 0 {
+		s.latency = float64(latency)
+		s.errorRate = result
+	} else {
+		s.errorRate = s.decayedErrorRate(now)
+		s.errorRate += errorRateDecay * (result - s.errorRate)
+		// a failed request often returns early, its latency says nothing
+		// about the speed of the endpoint
+		if !failed {
+			s.latency += latencyDecay * (float64(latency) - s.latency)
+		}
+	}
+	if s.errorRate > maxErrorRate {
+		s.errorRate = maxErrorRate
+	}
+	s.samples++
+	s.lastUpdate = now
+}
+
+// must protected by lock
+func (s *endpointStats) decayedErrorRate(now time.Time) float64 {
+	elapsed := now.Sub(s.lastUpdate)
+	if elapsed <= 0 {
+		return s.errorRate
+	}
+	return s.errorRate * math.Exp2(-float64(elapsed)/float64(errorRateHalfLife))
+}
+
+func (s *endpointStats) snapshot(now time.Time) (latency, errorRate float64, samples uint64) {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	if s.samples == 0 {
+		return 0, 0, 0
+	}
+	return s.latency, s.decayedErrorRate(now), s.samples
+}
+
+func endpointCost(latency, errorRate float64, inflight int64) float64 {
+	if latency < 1 {
+		latency = 1
+	}
+	if inflight < 0 {
+		inflight = 0
+	}
+	return latency * float64(inflight+1) / (1 - errorRate)
+}
@@ -0,0 +1,105 @@
+package endpoints
+
+import (
+	"testing"
+	"time"
+)
+
+func TestEndpointStatsRecord(t *testing.T) {
+	var s endpointStats
+	now := time.Now()
+
+	if latency, errorRate, samples := s.snapshot(now); latency != 0 || errorRate != 0 || samples != 0 {
+		t.Fatalf("1 expect empty stats, got %v %v %v", latency, errorRate, samples)
+	}
+
+	s.record(100*time.Millisecond, false, now)
+	latency, errorRate, samples := s.snapshot(now)
+	if e, a := float64(100*time.Millisecond), latency; e != a {
+		t.Errorf("2 expect latency %v, got %v", e, a)
+	}
+	if errorRate != 0 || samples != 1 {
+		t.Errorf("3 expect error rate 0 and 1 sample, got %v %v", errorRate, samples)
+	}
+
+	s.record(200*time.Millisecond, false, now)
+	latency, _, _ = s.snapshot(now)
+	if e, a := float64(120*time.Millisecond), latency; e != a {
+		t.Errorf("4 expect latency %v, got %v", e, a)
+	}
+
+	// failures move the error rate but not the latency
+	for i := 0; i < 100; i++ {
+		s.record(time.Millisecond, true, now)
+	}
+	latency, errorRate, _ = s.snapshot(now)
+	if e, a := float64(120*time.Millisecond), latency; e != a {
+		t.Errorf("5 expect latency %v, got %v", e, a)
+	}
+	if errorRate < 0.9 || errorRate > maxErrorRate {
+		t.Errorf("6 expect error rate close to %v, got %v", maxErrorRate, errorRate)
+	}
+
+	// the error rate decays without traffic
+	_, decayed, _ := s.snapshot(now.Add(errorRateHalfLife))
+	if e, a := errorRate/2, decayed; a < e-0.001 || a > e+0.001 {
+		t.Errorf("7 expect error rate %v, got %v", e, a)
+	}
+}
+
+func TestEndpointScore(t *testing.T) {
+	fast := &SingleEndpoint{URL: "http://abc1.test:8080"}
+	slow := &SingleEndpoint{URL: "http://abc2.test:8080"}
+	flaky := &SingleEndpoint{URL: "http://abc3.test:8080"}
+
+	if score := fast.Score(); score.Samples != 0 || score.Cost != 0 {
+		t.Errorf("1 expect empty score, got %+v", score)
+	}
+
+	for i := 0; i < 10; i++ {
+		fast.RecordResult(10*time.Millisecond, false)
+		slow.RecordResult(100*time.Millisecond, false)
+		flaky.RecordResult(10*time.Millisecond, i%2 == 0)
+	}
+
+	fastScore, slowScore, flakyScore := fast.Score(), slow.Score(), flaky.Score()
+	if e, a := fast.URL, fastScore.URL; e != a {
+		t.Errorf("2 expect %v, got %v", e, a)
+	}
+	if e, a := uint64(10), fastScore.Samples; e != a {
+		t.Errorf("3 expect %v samples, got %v", e, a)
+	}
+	if fastScore.Cost >= slowScore.Cost {
+		t.Errorf("4 expect slow endpoint to cost more, got %v >= %v", fastScore.Cost, slowScore.Cost)
+	}
+	if fastScore.Cost >= flakyScore.Cost {
+		t.Errorf("5 expect flaky endpoint to cost more, got %v >= %v", fastScore.Cost, flakyScore.Cost)
+	}
+
+	fast.Acquire()
+	defer fast.Release()
+	if busy := fast.Score(); busy.Inflight != 1 || busy.Cost <= fastScore.Cost {
+		t.Errorf("6 expect busy endpoint to cost more, got %+v", busy)
+	}
+}
+
+func TestEndpointCollectionScores(t *testing.T) {
+	ec, err := NewEndpointCollection(TEST_ENDPOINT_PATH, 3)
+	if err != nil {
+		t.Fatalf("1 expect nil, got err %v", err)
+	}
+	defer ec.Close()
+
+	ec.endpointHead.RecordResult(time.Millisecond, false)
+
+	scores := ec.Scores()
+	if e, a := numOfActiveEndpoint, len(scores); e != a {
+		t.Fatalf("2 expect %d scores, got %d", e, a)
+	}
+	if e, a := "http://abc1.test:8080", scores[0].URL; e != a {
+		t.Errorf("3 expect %v, got %v", e, a)
+	}
+	if e, a := uint64(1), scores[0].Samples; e != a {
+		t.Errorf("4 expect %v samples, got %v", e, a)
+	}
+}
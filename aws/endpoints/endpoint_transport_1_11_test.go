@@ -0,0 +1,21 @@
+// +build go1.11
+
+package endpoints
+
+import (
+	"net/http"
+	"testing"
+)
+
+func TestEndpointHTTPClientMaxConnsPerHost(t *testing.T) {
+	ec := newListCollection(t, connectionLimits, "http://abc1.test:8080", "http://abc2.test:8080 max_connections=3")
+	defer ec.Close()
+
+	base := NewHttpClient()
+	for URL, expect := range map[string]int{"http://abc1.test:8080": 1, "http://abc2.test:8080": 3} {
+		transport := ec.HTTPClient(endpointOf(ec, URL), base, AttemptTimeouts{}).Transport.(*http.Transport)
+		if e, a := expect, transport.MaxConnsPerHost; e != a {
+			t.Errorf("expect max conns %d for %s, got %d", e, URL, a)
+		}
+	}
+}
@@ -0,0 +1,58 @@
+// +build !go1.13
+
+// Copyright 2020 Baidu, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License"); you may not use this file
+// except in compliance with the License. You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software distributed under the
+// License is distributed on an "AS IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND,
+// either express or implied. See the License for the specific language governing permissions
+// and limitations under the License.
+
+package endpoints
+
+import (
+	"crypto/tls"
+	"net/http"
+)
+
+// cloneTransport returns a copy of transport with a pool of connections
+// of its own. The fields are copied by hand, http.Transport.Clone needs
+// Go 1.13.
+func cloneTransport(transport *http.Transport) *http.Transport {
+	clone := &http.Transport{
+		Proxy:                  transport.Proxy,
+		DialContext:            transport.DialContext,
+		Dial:                   transport.Dial,
+		DialTLS:                transport.DialTLS,
+		TLSHandshakeTimeout:    transport.TLSHandshakeTimeout,
+		DisableKeepAlives:      transport.DisableKeepAlives,
+		DisableCompression:     transport.DisableCompression,
+		MaxIdleConns:           transport.MaxIdleConns,
+		MaxIdleConnsPerHost:    transport.MaxIdleConnsPerHost,
+		IdleConnTimeout:        transport.IdleConnTimeout,
+		ResponseHeaderTimeout:  transport.ResponseHeaderTimeout,
+		ExpectContinueTimeout:  transport.ExpectContinueTimeout,
+		MaxResponseHeaderBytes: transport.MaxResponseHeaderBytes,
+	}
+	if transport.TLSClientConfig != nil {
+		clone.TLSClientConfig = transport.TLSClientConfig.Clone()
+	}
+	if transport.TLSNextProto != nil {
+		clone.TLSNextProto = make(map[string]func(string, *tls.Conn) http.RoundTripper, len(transport.TLSNextProto))
+		for proto, next := range transport.TLSNextProto {
+			clone.TLSNextProto[proto] = next
+		}
+	}
+	if transport.ProxyConnectHeader != nil {
+		clone.ProxyConnectHeader = make(http.Header, len(transport.ProxyConnectHeader))
+		for name, values := range transport.ProxyConnectHeader {
+			clone.ProxyConnectHeader[name] = append([]string(nil), values...)
+		}
+	}
+	setMaxConnsPerHost(clone, maxConnsPerHost(transport))
+	return clone
+}
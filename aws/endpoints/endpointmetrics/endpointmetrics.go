@@ -0,0 +1,470 @@
+// Copyright 2020 Baidu, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License"); you may not use this file
+// except in compliance with the License. You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software distributed under the
+// License is distributed on an "AS IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND,
+// either express or implied. See the License for the specific language governing permissions
+// and limitations under the License.
+
+// Package endpointmetrics exports metrics of endpoint collections and of the
+// requests sent to their endpoints, through expvar and the Prometheus text
+// format. It does not depend on the Prometheus client library.
+//
+// Example:
+//
+//	exporter := endpointmetrics.NewExporter()
+//	defer exporter.Watch("default", coll)()
+//	svc.Handlers.CompleteAttempt.PushBackNamed(exporter.Handler())
+//	http.Handle("/metrics", exporter)
+//	exporter.Publish("endpoints")
+package endpointmetrics
+
+import (
+	"bytes"
+	"expvar"
+	"fmt"
+	"io"
+	"net/http"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/aws/aws-sdk-go/aws/awserr"
+	"github.com/aws/aws-sdk-go/aws/endpoints"
+	"github.com/aws/aws-sdk-go/aws/request"
+)
+
+// DefaultLatencyBuckets are the upper bounds in seconds of the buckets of
+// the request latency histogram.
+var DefaultLatencyBuckets = []float64{.005, .01, .025, .05, .1, .25, .5, 1, 2.5, 5, 10}
+
+// The size of the event buffer of a watched collection. Events which do
+// not fit are dropped.
+const eventBuffer = 256
+
+// Exporter collects the metrics of the endpoints of the collections it
+// watches and of the requests recorded by its handler.
+type Exporter struct {
+	buckets []float64
+
+	mutex     sync.Mutex
+	pools     map[*endpoints.EndpointCollection]string
+	endpoints map[endpointKey]*endpointMetrics
+}
+
+type endpointKey struct {
+	pool string
+	URL  string
+}
+
+type endpointMetrics struct {
+	requests uint64
+	errors   uint64
+
+	// latency histogram, counts[i] is the number of requests of at most
+	// buckets[i] seconds, the last count is for +Inf
+	counts     []uint64
+	latencySum float64
+
+	probes        uint64
+	probeFailures uint64
+
+	blacklistings    uint64
+	blacklistedSince time.Time
+	blacklisted      time.Duration
+}
+
+// NewExporter returns an exporter with the latency buckets given in
+// seconds, or DefaultLatencyBuckets when none is given.
+func NewExporter(buckets ...float64) *Exporter {
+	if len(buckets) == 0 {
+		buckets = DefaultLatencyBuckets
+	}
+	buckets = append([]float64(nil), buckets...)
+	sort.Float64s(buckets)
+
+	return &Exporter{
+		buckets:   buckets,
+		pools:     make(map[*endpoints.EndpointCollection]string),
+		endpoints: make(map[endpointKey]*endpointMetrics),
+	}
+}
+
+// Watch records the events of the collection under the pool name, and its
+// number of active endpoints. It returns a function which stops watching.
+func (x *Exporter) Watch(pool string, coll *endpoints.EndpointCollection) func() {
+	x.mutex.Lock()
+	x.pools[coll] = pool
+	x.mutex.Unlock()
+
+	events, unsubscribe := coll.Subscribe(eventBuffer)
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		for ev := range events {
+			x.observe(pool, ev)
+		}
+	}()
+
+	var once sync.Once
+	return func() {
+		once.Do(func() {
+			unsubscribe()
+			<-done
+
+			x.mutex.Lock()
+			delete(x.pools, coll)
+			x.mutex.Unlock()
+		})
+	}
+}
+
+// must protected by lock
+func (x *Exporter) endpoint(pool, URL string) *endpointMetrics {
+	key := endpointKey{pool: pool, URL: URL}
+	m, ok := x.endpoints[key]
+	if !ok {
+		m = &endpointMetrics{counts: make([]uint64, len(x.buckets)+1)}
+		x.endpoints[key] = m
+	}
+	return m
+}
+
+func (x *Exporter) observe(pool string, ev endpoints.EndpointEvent) {
+	if ev.URL == "" {
+		return
+	}
+
+	x.mutex.Lock()
+	defer x.mutex.Unlock()
+
+	if ev.Type == endpoints.EndpointRemoved {
+		// the endpoint left the collection, its series go with it
+		delete(x.endpoints, endpointKey{pool: pool, URL: ev.URL})
+		return
+	}
+
+	m := x.endpoint(pool, ev.URL)
+	switch ev.Type {
+	case endpoints.EndpointBlacklisted:
+		if m.blacklistedSince.IsZero() {
+			m.blacklistings++
+			m.blacklistedSince = ev.Time
+		}
+	case endpoints.EndpointRecovered:
+		if !m.blacklistedSince.IsZero() {
+			m.blacklisted += ev.Time.Sub(m.blacklistedSince)
+			m.blacklistedSince = time.Time{}
+		}
+	case endpoints.EndpointProbed:
+		m.probes++
+		if ev.Err != nil {
+			m.probeFailures++
+		}
+	}
+}
+
+// Handler returns a request handler recording the outcome of each request
+// attempt on the endpoint it was sent to. It belongs to the CompleteAttempt
+// handlers of a client. Errors classified by Request.IsEndpointError count
+// as errors of the endpoint, canceled requests, requests never let through
+// by their endpoint and requests to collections which are not watched are
+// not recorded. The latency is measured from Request.SendTime.
+func (x *Exporter) Handler() request.NamedHandler {
+	return request.NamedHandler{
+		Name: "endpointmetrics.Handler",
+		Fn: func(r *request.Request) {
+			if r.Endpoint == nil || r.SendTime.IsZero() {
+				return
+			}
+			if aerr, ok := r.Error.(awserr.Error); ok && aerr.Code() == request.CanceledErrorCode {
+				return
+			}
+			// signing and the wait for the endpoint are not its latency
+			x.record(r.CEndpoint, r.Endpoint.URL, time.Since(r.SendTime), r.IsEndpointError())
+		},
+	}
+}
+
+func (x *Exporter) record(coll *endpoints.EndpointCollection, URL string,
+	latency time.Duration, failed bool) {
+
+	x.mutex.Lock()
+	defer x.mutex.Unlock()
+
+	pool, ok := x.pools[coll]
+	if !ok {
+		return
+	}
+	m := x.endpoint(pool, URL)
+	m.requests++
+	if failed {
+		m.errors++
+	}
+	seconds := latency.Seconds()
+	m.latencySum += seconds
+	i := sort.SearchFloat64s(x.buckets, seconds)
+	m.counts[i]++
+}
+
+// endpointSample is the state of the metrics of one endpoint
+type endpointSample struct {
+	endpointKey
+	endpointMetrics
+	blacklistedNow bool
+}
+
+type poolSample struct {
+	pool   string
+	active int
+}
+
+// snapshot copies the metrics, sorted by pool and endpoint
+func (x *Exporter) snapshot() ([]endpointSample, []poolSample) {
+	now := time.Now()
+
+	x.mutex.Lock()
+	samples := make([]endpointSample, 0, len(x.endpoints))
+	for key, m := range x.endpoints {
+		s := endpointSample{endpointKey: key, endpointMetrics: *m}
+		s.counts = append([]uint64(nil), m.counts...)
+		if !m.blacklistedSince.IsZero() {
+			s.blacklistedNow = true
+			s.blacklisted += now.Sub(m.blacklistedSince)
+		}
+		samples = append(samples, s)
+	}
+	colls := make(map[*endpoints.EndpointCollection]string, len(x.pools))
+	for coll, pool := range x.pools {
+		colls[coll] = pool
+	}
+	x.mutex.Unlock()
+
+	pools := make([]poolSample, 0, len(colls))
+	for coll, pool := range colls {
+		pools = append(pools, poolSample{pool: pool, active: coll.NumActiveEndpoints()})
+	}
+
+	sort.Sort(byEndpoint(samples))
+	sort.Sort(byPool(pools))
+	return samples, pools
+}
+
+// byEndpoint sorts samples by pool and endpoint
+type byEndpoint []endpointSample
+
+func (a byEndpoint) Len() int      { return len(a) }
+func (a byEndpoint) Swap(i, j int) { a[i], a[j] = a[j], a[i] }
+func (a byEndpoint) Less(i, j int) bool {
+	if a[i].pool != a[j].pool {
+		return a[i].pool < a[j].pool
+	}
+	return a[i].URL < a[j].URL
+}
+
+type byPool []poolSample
+
+func (a byPool) Len() int           { return len(a) }
+func (a byPool) Swap(i, j int)      { a[i], a[j] = a[j], a[i] }
+func (a byPool) Less(i, j int) bool { return a[i].pool < a[j].pool }
+
+// ServeHTTP writes the metrics in the Prometheus text format.
+func (x *Exporter) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "text/plain; version=0.0.4; charset=utf-8")
+	x.WritePrometheus(w)
+}
+
+// WritePrometheus writes the metrics in the Prometheus text format.
+func (x *Exporter) WritePrometheus(w io.Writer) error {
+	samples, pools := x.snapshot()
+	p := &promWriter{w: w}
+
+	p.header("aws_sdk_endpoint_pool_active_endpoints", "gauge",
+		"Number of endpoints of the pool taking requests.")
+	for _, s := range pools {
+		p.sample("aws_sdk_endpoint_pool_active_endpoints", labels("pool", s.pool), float64(s.active))
+	}
+
+	p.header("aws_sdk_endpoint_requests_total", "counter",
+		"Number of request attempts sent to the endpoint.")
+	for _, s := range samples {
+		p.sample("aws_sdk_endpoint_requests_total", s.labels(), float64(s.requests))
+	}
+
+	p.header("aws_sdk_endpoint_errors_total", "counter",
+		"Number of request attempts failed by the endpoint.")
+	for _, s := range samples {
+		p.sample("aws_sdk_endpoint_errors_total", s.labels(), float64(s.errors))
+	}
+
+	p.header("aws_sdk_endpoint_request_duration_seconds", "histogram",
+		"Latency of the request attempts sent to the endpoint.")
+	for _, s := range samples {
+		var cumulative uint64
+		for i, bound := range x.buckets {
+			cumulative += s.counts[i]
+			p.sample("aws_sdk_endpoint_request_duration_seconds_bucket",
+				s.labels("le", formatFloat(bound)), float64(cumulative))
+		}
+		cumulative += s.counts[len(x.buckets)]
+		p.sample("aws_sdk_endpoint_request_duration_seconds_bucket",
+			s.labels("le", "+Inf"), float64(cumulative))
+		p.sample("aws_sdk_endpoint_request_duration_seconds_sum", s.labels(), s.latencySum)
+		p.sample("aws_sdk_endpoint_request_duration_seconds_count", s.labels(), float64(cumulative))
+	}
+
+	p.header("aws_sdk_endpoint_blacklisted", "gauge",
+		"1 if the endpoint is blacklisted, 0 otherwise.")
+	for _, s := range samples {
+		value := 0.0
+		if s.blacklistedNow {
+			value = 1
+		}
+		p.sample("aws_sdk_endpoint_blacklisted", s.labels(), value)
+	}
+
+	p.header("aws_sdk_endpoint_blacklistings_total", "counter",
+		"Number of times the endpoint was blacklisted.")
+	for _, s := range samples {
+		p.sample("aws_sdk_endpoint_blacklistings_total", s.labels(), float64(s.blacklistings))
+	}
+
+	p.header("aws_sdk_endpoint_blacklisted_seconds_total", "counter",
+		"Time the endpoint spent blacklisted.")
+	for _, s := range samples {
+		p.sample("aws_sdk_endpoint_blacklisted_seconds_total", s.labels(), s.blacklisted.Seconds())
+	}
+
+	p.header("aws_sdk_endpoint_probes_total", "counter",
+		"Number of health probes of the endpoint, by result.")
+	for _, s := range samples {
+		p.sample("aws_sdk_endpoint_probes_total", s.labels("result", "success"),
+			float64(s.probes-s.probeFailures))
+		p.sample("aws_sdk_endpoint_probes_total", s.labels("result", "failure"),
+			float64(s.probeFailures))
+	}
+	return p.err
+}
+
+func (s endpointSample) labels(extra ...string) string {
+	return labels(append([]string{"pool", s.pool, "endpoint", s.URL}, extra...)...)
+}
+
+// labels formats name and value pairs
+func labels(pairs ...string) string {
+	var b bytes.Buffer
+	b.WriteByte('{')
+	for i := 0; i+1 < len(pairs); i += 2 {
+		if i > 0 {
+			b.WriteByte(',')
+		}
+		b.WriteString(pairs[i])
+		b.WriteString(`="`)
+		b.WriteString(labelEscaper.Replace(pairs[i+1]))
+		b.WriteByte('"')
+	}
+	b.WriteByte('}')
+	return b.String()
+}
+
+var labelEscaper = strings.NewReplacer(`\`, `\\`, `"`, `\"`, "\n", `\n`)
+
+func formatFloat(f float64) string {
+	return fmt.Sprint(f)
+}
+
+// promWriter writes the text format, keeping the first error
+type promWriter struct {
+	w   io.Writer
+	err error
+}
+
+func (p *promWriter) header(name, typ, help string) {
+	p.printf("# HELP %s %s\n# TYPE %s %s\n", name, help, name, typ)
+}
+
+func (p *promWriter) sample(name, labels string, value float64) {
+	p.printf("%s%s %s\n", name, labels, formatFloat(value))
+}
+
+func (p *promWriter) printf(format string, args ...interface{}) {
+	if p.err == nil {
+		_, p.err = fmt.Fprintf(p.w, format, args...)
+	}
+}
+
+// Var returns the metrics as an expvar.Var, a JSON object with the pools
+// and their endpoints.
+func (x *Exporter) Var() expvar.Var {
+	return expvar.Func(x.expvarValue)
+}
+
+// Publish publishes the metrics in expvar under name. Like expvar.Publish
+// it panics if the name is already in use.
+func (x *Exporter) Publish(name string) {
+	expvar.Publish(name, x.Var())
+}
+
+type expvarEndpoint struct {
+	Requests           uint64            `json:"requests"`
+	Errors             uint64            `json:"errors"`
+	LatencyBuckets     map[string]uint64 `json:"latency_buckets"`
+	LatencySum         float64           `json:"latency_sum_seconds"`
+	Blacklisted        bool              `json:"blacklisted"`
+	Blacklistings      uint64            `json:"blacklistings"`
+	BlacklistedSeconds float64           `json:"blacklisted_seconds"`
+	Probes             uint64            `json:"probes"`
+	ProbeFailures      uint64            `json:"probe_failures"`
+}
+
+type expvarPool struct {
+	ActiveEndpoints int                        `json:"active_endpoints"`
+	Endpoints       map[string]*expvarEndpoint `json:"endpoints"`
+}
+
+func (x *Exporter) expvarValue() interface{} {
+	samples, pools := x.snapshot()
+
+	value := make(map[string]*expvarPool)
+	pool := func(name string) *expvarPool {
+		p, ok := value[name]
+		if !ok {
+			p = &expvarPool{Endpoints: make(map[string]*expvarEndpoint)}
+			value[name] = p
+		}
+		return p
+	}
+
+	for _, s := range pools {
+		pool(s.pool).ActiveEndpoints = s.active
+	}
+	for _, s := range samples {
+		buckets := make(map[string]uint64, len(s.counts))
+		var cumulative uint64
+		for i, count := range s.counts {
+			cumulative += count
+			le := "+Inf"
+			if i < len(x.buckets) {
+				le = formatFloat(x.buckets[i])
+			}
+			buckets[le] = cumulative
+		}
+		pool(s.pool).Endpoints[s.URL] = &expvarEndpoint{
+			Requests:           s.requests,
+			Errors:             s.errors,
+			LatencyBuckets:     buckets,
+			LatencySum:         s.latencySum,
+			Blacklisted:        s.blacklistedNow,
+			Blacklistings:      s.blacklistings,
+			BlacklistedSeconds: s.blacklisted.Seconds(),
+			Probes:             s.probes,
+			ProbeFailures:      s.probeFailures,
+		}
+	}
+	return value
+}
@@ -0,0 +1,204 @@
+package endpointmetrics
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/aws/awserr"
+	"github.com/aws/aws-sdk-go/aws/endpoints"
+	"github.com/aws/aws-sdk-go/aws/request"
+)
+
+func newCollection(t *testing.T) *endpoints.EndpointCollection {
+	coll, err := endpoints.NewEndpointCollectionFromList([]string{
+		"http://abc1.test:8080",
+		"http://abc2.test:8080",
+	}, 100, func(o *endpoints.CollectionOptions) {
+		o.CircuitBreaker.FailureThreshold = 1
+	})
+	if err != nil {
+		t.Fatalf("expect nil, got err %v", err)
+	}
+	return coll
+}
+
+func newAttempt(coll *endpoints.EndpointCollection, URL string, latency time.Duration,
+	status int) *request.Request {
+
+	now := time.Now()
+	r := &request.Request{
+		// the 5xx responses are errors of the endpoint
+		Config: aws.Config{
+			EndpointErrorClassifier: request.EndpointErrorClasses{ServerErrors: true},
+		},
+		CEndpoint: coll,
+		Endpoint:  coll.GetRandEndpoint(0),
+		// the attempt waited a minute for its endpoint
+		AttemptTime: now.Add(-latency - time.Minute),
+		SendTime:    now.Add(-latency),
+	}
+	for r.Endpoint.URL != URL {
+		r.Endpoint = coll.GetNextEndpoint(r.Endpoint)
+	}
+	if status != http.StatusOK {
+		r.HTTPResponse = &http.Response{StatusCode: status}
+		r.Error = awserr.New("ServiceUnavailable", "service unavailable", nil)
+	}
+	return r
+}
+
+func TestExporter(t *testing.T) {
+	coll := newCollection(t)
+	defer coll.Close()
+
+	x := NewExporter(0.1, 1)
+	stop := x.Watch("hot", coll)
+
+	handler := x.Handler()
+	handler.Fn(newAttempt(coll, "http://abc1.test:8080", 50*time.Millisecond, http.StatusOK))
+	handler.Fn(newAttempt(coll, "http://abc1.test:8080", 500*time.Millisecond, http.StatusOK))
+	handler.Fn(newAttempt(coll, "http://abc1.test:8080", 5*time.Second,
+		http.StatusServiceUnavailable))
+	// never let through by its endpoint
+	queued := newAttempt(coll, "http://abc1.test:8080", 0, http.StatusOK)
+	queued.SendTime = time.Time{}
+	handler.Fn(queued)
+
+	coll.AddEndpointToBlacklist(newAttempt(coll, "http://abc2.test:8080", 0, http.StatusOK).Endpoint)
+	stop()
+	stop()
+
+	x.observe("hot", endpoints.EndpointEvent{
+		Type: endpoints.EndpointProbed, URL: "http://abc2.test:8080", Time: time.Now(),
+	})
+	x.observe("hot", endpoints.EndpointEvent{
+		Type: endpoints.EndpointProbed, URL: "http://abc2.test:8080", Time: time.Now(),
+		Err: errors.New("refused"),
+	})
+
+	w := httptest.NewRecorder()
+	x.ServeHTTP(w, httptest.NewRequest("GET", "/metrics", nil))
+	if e, a := "text/plain; version=0.0.4; charset=utf-8", w.Header().Get("Content-Type"); e != a {
+		t.Errorf("expect content type %s, got %s", e, a)
+	}
+	body := w.Body.String()
+
+	abc1 := `{pool="hot",endpoint="http://abc1.test:8080"`
+	abc2 := `{pool="hot",endpoint="http://abc2.test:8080"`
+	for _, line := range []string{
+		"# TYPE aws_sdk_endpoint_requests_total counter",
+		"aws_sdk_endpoint_requests_total" + abc1 + "} 3",
+		"aws_sdk_endpoint_errors_total" + abc1 + "} 1",
+		"aws_sdk_endpoint_request_duration_seconds_bucket" + abc1 + `,le="0.1"} 1`,
+		"aws_sdk_endpoint_request_duration_seconds_bucket" + abc1 + `,le="1"} 2`,
+		"aws_sdk_endpoint_request_duration_seconds_bucket" + abc1 + `,le="+Inf"} 3`,
+		"aws_sdk_endpoint_request_duration_seconds_count" + abc1 + "} 3",
+		"aws_sdk_endpoint_blacklisted" + abc1 + "} 0",
+		"aws_sdk_endpoint_blacklisted" + abc2 + "} 1",
+		"aws_sdk_endpoint_blacklistings_total" + abc2 + "} 1",
+		"aws_sdk_endpoint_probes_total" + abc2 + `,result="success"} 1`,
+		"aws_sdk_endpoint_probes_total" + abc2 + `,result="failure"} 1`,
+	} {
+		if !strings.Contains(body, line+"\n") {
+			t.Errorf("expect line %q in\n%s", line, body)
+		}
+	}
+	// the collection is no longer watched
+	if strings.Contains(body, `aws_sdk_endpoint_pool_active_endpoints{`) {
+		t.Errorf("expect no pool size once stopped, got\n%s", body)
+	}
+}
+
+func TestExporterPoolSize(t *testing.T) {
+	coll := newCollection(t)
+	defer coll.Close()
+
+	x := NewExporter()
+	defer x.Watch("hot", coll)()
+
+	var b bytes.Buffer
+	if err := x.WritePrometheus(&b); err != nil {
+		t.Fatalf("expect nil, got err %v", err)
+	}
+	if e := `aws_sdk_endpoint_pool_active_endpoints{pool="hot"} 2` + "\n"; !strings.Contains(b.String(), e) {
+		t.Errorf("expect %q in\n%s", e, b.String())
+	}
+}
+
+func TestExporterExpvar(t *testing.T) {
+	coll := newCollection(t)
+	defer coll.Close()
+
+	x := NewExporter(1)
+	defer x.Watch("hot", coll)()
+	x.Handler().Fn(newAttempt(coll, "http://abc1.test:8080", 10*time.Millisecond, http.StatusOK))
+
+	var value map[string]struct {
+		ActiveEndpoints int `json:"active_endpoints"`
+		Endpoints       map[string]struct {
+			Requests       uint64            `json:"requests"`
+			LatencyBuckets map[string]uint64 `json:"latency_buckets"`
+		} `json:"endpoints"`
+	}
+	if err := json.Unmarshal([]byte(x.Var().String()), &value); err != nil {
+		t.Fatalf("expect nil, got err %v", err)
+	}
+	if e, a := 2, value["hot"].ActiveEndpoints; e != a {
+		t.Errorf("expect %d active endpoints, got %d", e, a)
+	}
+	abc1 := value["hot"].Endpoints["http://abc1.test:8080"]
+	if e, a := uint64(1), abc1.Requests; e != a {
+		t.Errorf("expect %d requests, got %d", e, a)
+	}
+	if e, a := uint64(1), abc1.LatencyBuckets["1"]; e != a {
+		t.Errorf("expect %d requests in bucket, got %d", e, a)
+	}
+}
+
+func TestLabels(t *testing.T) {
+	if e, a := `{pool="a\"b\\c\nd"}`, labels("pool", "a\"b\\c\nd"); e != a {
+		t.Errorf("expect %s, got %s", e, a)
+	}
+}
+
+func TestExporterCardinality(t *testing.T) {
+	coll := newCollection(t)
+	defer coll.Close()
+	other := newCollection(t)
+	defer other.Close()
+
+	x := NewExporter()
+	defer x.Watch("hot", coll)()
+
+	handler := x.Handler()
+	handler.Fn(newAttempt(coll, "http://abc1.test:8080", 10*time.Millisecond, http.StatusOK))
+	handler.Fn(newAttempt(coll, "http://abc2.test:8080", 10*time.Millisecond, http.StatusOK))
+	// the requests of a collection which is not watched are not recorded
+	handler.Fn(newAttempt(other, "http://abc1.test:8080", 10*time.Millisecond, http.StatusOK))
+
+	now := time.Now()
+	x.observe("hot", endpoints.EndpointEvent{
+		Type: endpoints.EndpointBlacklisted, URL: "http://abc2.test:8080", Time: now,
+	})
+	x.observe("hot", endpoints.EndpointEvent{
+		Type: endpoints.EndpointRemoved, URL: "http://abc2.test:8080", Time: now.Add(time.Second),
+	})
+
+	samples, _ := x.snapshot()
+	if e, a := 1, len(samples); e != a {
+		t.Fatalf("expect %d endpoints, got %d", e, a)
+	}
+	if e, a := (endpointKey{pool: "hot", URL: "http://abc1.test:8080"}), samples[0].endpointKey; e != a {
+		t.Errorf("expect %v, got %v", e, a)
+	}
+	if e, a := uint64(1), samples[0].requests; e != a {
+		t.Errorf("expect %d requests, got %d", e, a)
+	}
+}
@@ -0,0 +1,151 @@
+// Copyright 2020 Baidu, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License"); you may not use this file
+// except in compliance with the License. You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software distributed under the
+// License is distributed on an "AS IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND,
+// either express or implied. See the License for the specific language governing permissions
+// and limitations under the License.
+
+package endpoints
+
+import (
+	"encoding/xml"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+)
+
+// DefaultCachePath returns the cache file kept next to the endpoints file
+// endpointsPath.
+func DefaultCachePath(endpointsPath string) string {
+	return endpointsPath + ".cache"
+}
+
+// endpointsCache is the content of the cache file, the last endpoint list
+// received from the server. Its epoch is not kept: the cached endpoints are
+// only tried as seeds, the list of the server is always taken on start.
+type endpointsCache struct {
+	XMLName xml.Name `xml:"EndpointsCache"`
+
+	RgwConfiguration []*Rgw `xml:"Rgw"`
+}
+
+// readEndpointsCache reads the cache file written by writeEndpointsCache
+func readEndpointsCache(cachePath string) (*RgwInfo, error) {
+	body, err := ioutil.ReadFile(cachePath)
+	if err != nil {
+		return nil, err
+	}
+
+	cache := &endpointsCache{}
+	if err := xml.Unmarshal(body, cache); err != nil {
+		return nil, fmt.Errorf("invalid endpoints cache %s, %v", cachePath, err)
+	}
+	if len(cache.RgwConfiguration) == 0 {
+		return nil, fmt.Errorf("endpoints cache %s is empty", cachePath)
+	}
+	return &RgwInfo{RgwConfiguration: cache.RgwConfiguration}, nil
+}
+
+// writeEndpointsCache replaces the cache file by rgws. The list is written to
+// a temporary file of the same directory which is then renamed, so that a
+// reader never sees a partial list.
+func writeEndpointsCache(cachePath string, rgws *RgwInfo) error {
+	body, err := xml.MarshalIndent(&endpointsCache{
+		RgwConfiguration: rgws.RgwConfiguration,
+	}, "", "  ")
+	if err != nil {
+		return err
+	}
+
+	dir, name := filepath.Split(cachePath)
+	if dir == "" {
+		dir = "."
+	}
+	f, err := ioutil.TempFile(dir, name+".tmp")
+	if err != nil {
+		return err
+	}
+	if _, err = f.Write(body); err == nil {
+		err = f.Sync()
+	}
+	if closeErr := f.Close(); err == nil {
+		err = closeErr
+	}
+	if err == nil {
+		err = os.Chmod(f.Name(), 0644)
+	}
+	if err == nil {
+		err = os.Rename(f.Name(), cachePath)
+	}
+	if err != nil {
+		os.Remove(f.Name())
+	}
+	return err
+}
+
+// loadCache reads the cache file of the collection, its endpoints are tried
+// after the seeds. A missing or invalid cache is ignored.
+func (e *EndpointCollection) loadCache() {
+	if e.options.CachePath == "" {
+		return
+	}
+
+	rgws, err := readEndpointsCache(e.options.CachePath)
+	if err != nil {
+		return
+	}
+	e.setCachedEndpoints(rgws)
+}
+
+// saveCache remembers rgws as the last known good endpoint list, and writes
+// it to the cache file. The cache is best effort, the collection works the
+// same without it, a failed write is only logged.
+func (e *EndpointCollection) saveCache(rgws *RgwInfo) {
+	if e.options.CachePath == "" {
+		return
+	}
+
+	e.setCachedEndpoints(rgws)
+	if err := writeEndpointsCache(e.options.CachePath, rgws); err != nil {
+		e.logf("WARN: failed to write the endpoints cache %s, %v", e.options.CachePath, err)
+	}
+}
+
+func (e *EndpointCollection) setCachedEndpoints(rgws *RgwInfo) {
+	endpointAll := endpointsFromRgwInfo(rgws)
+
+	e.mutex.Lock()
+	e.cachedEndpoints = endpointAll
+	e.unlock()
+}
+
+// seedEndpoints returns the seeds followed by the cached endpoints which are
+// not seeds, skipping the blacklisted ones
+// must protected by lock
+func (e *EndpointCollection) seedEndpoints() []*SingleEndpoint {
+	var seeds []*SingleEndpoint
+	URLs := make(map[string]bool)
+	add := func(endpoint *SingleEndpoint) {
+		if _, ok := e.blackList[endpoint.URL]; ok || URLs[endpoint.URL] {
+			return
+		}
+		URLs[endpoint.URL] = true
+		seeds = append(seeds, endpoint)
+	}
+
+	if e.endpointSeed != nil {
+		for i := range *e.endpointSeed {
+			add(&(*e.endpointSeed)[i])
+		}
+	}
+	for _, endpoint := range e.cachedEndpoints {
+		add(endpoint)
+	}
+	return seeds
+}
@@ -0,0 +1,186 @@
+package endpoints
+
+import (
+	"fmt"
+	"io/ioutil"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestEndpointsCacheFile(t *testing.T) {
+	path, cleanup := writeEndpointsFile(t, "http://abc1.test:8080")
+	defer cleanup()
+	cachePath := DefaultCachePath(path)
+
+	if _, err := readEndpointsCache(cachePath); err == nil {
+		t.Errorf("1 expect missing cache to fail")
+	}
+
+	rgws := &RgwInfo{
+		RgwConfiguration: []*Rgw{
+			{Ip: "10.0.0.1", Port: "8080", Zone: "z1"},
+			{Ip: "10.0.0.2", Port: "8080"},
+		},
+		epoch: 7,
+	}
+	for i := 0; i < 2; i++ {
+		if err := writeEndpointsCache(cachePath, rgws); err != nil {
+			t.Fatalf("2 expect nil, got err %v", err)
+		}
+	}
+
+	cached, err := readEndpointsCache(cachePath)
+	if err != nil {
+		t.Fatalf("3 expect nil, got err %v", err)
+	}
+	// a restarted client takes the list of the server whatever its epoch
+	if e, a := 0, cached.epoch; e != a {
+		t.Errorf("4 expect epoch %d, got %d", e, a)
+	}
+	if e, a := 2, len(cached.RgwConfiguration); e != a {
+		t.Fatalf("5 expect %d rgws, got %d", e, a)
+	}
+	if e, a := *rgws.RgwConfiguration[0], *cached.RgwConfiguration[0]; e != a {
+		t.Errorf("6 expect %v, got %v", e, a)
+	}
+
+	// the temporary files are renamed over the cache
+	files, err := ioutil.ReadDir(filepath.Dir(path))
+	if err != nil {
+		t.Fatalf("7 expect nil, got err %v", err)
+	}
+	if e, a := 2, len(files); e != a {
+		t.Errorf("8 expect %d files, got %d", e, a)
+	}
+
+	if err := ioutil.WriteFile(cachePath, []byte("<EndpointsCache>"), 0644); err != nil {
+		t.Fatalf("9 expect nil, got err %v", err)
+	}
+	if _, err := readEndpointsCache(cachePath); err == nil {
+		t.Errorf("10 expect invalid cache to fail")
+	}
+}
+
+// newRgwServer returns a server listing itself as the only endpoint
+func newRgwServer(t *testing.T, epoch int) (*httptest.Server, *SingleEndpoint) {
+	var server *httptest.Server
+	server = httptest.NewUnstartedServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.RawQuery != "rgw" {
+			w.WriteHeader(http.StatusNotFound)
+			return
+		}
+		host, port, _ := net.SplitHostPort(server.Listener.Addr().String())
+		w.Header().Set("Last-Epoch", fmt.Sprint(epoch))
+		fmt.Fprintf(w, "<RgwInfo><Rgw><Ip>%s</Ip><Port>%s</Port></Rgw></RgwInfo>", host, port)
+	}))
+	server.Start()
+	return server, newTestEndpoint(t, server.URL)
+}
+
+// newCacheCollection returns a collection of the endpoints file at path
+// caching the endpoint list next to it, without a keep alive running in the
+// background
+func newCacheCollection(t *testing.T, path string) *EndpointCollection {
+	ec, err := newFileEndpointCollection(path, 100, []func(*CollectionOptions){
+		func(o *CollectionOptions) {
+			o.CachePath = DefaultCachePath(path)
+		},
+	})
+	if err != nil {
+		t.Fatalf("expect nil, got err %v", err)
+	}
+	ec.loadCache()
+	return ec
+}
+
+func TestCacheAsSeed(t *testing.T) {
+	server, endpoint := newRgwServer(t, 3)
+	defer server.Close()
+
+	// port 808 should not listen
+	path, cleanup := writeEndpointsFile(t, "http://127.0.0.1:808")
+	defer cleanup()
+
+	ec := newCacheCollection(t, path)
+	if e, a := 0, len(ec.cachedEndpoints); e != a {
+		t.Errorf("1 expect %d cached endpoints, got %d", e, a)
+	}
+	if !ec.UpdateEndpointsByEndpoint(endpoint, true) {
+		t.Fatalf("2 expect update from server")
+	}
+	if _, err := os.Stat(DefaultCachePath(path)); err != nil {
+		t.Fatalf("3 expect cache written, got err %v", err)
+	}
+
+	// a restarted client only knows the stale seed and the cache
+	restarted := newCacheCollection(t, path)
+	if e, a := 1, len(restarted.cachedEndpoints); e != a {
+		t.Fatalf("4 expect %d cached endpoints, got %d", e, a)
+	}
+	dropActiveEndpoints(restarted)
+	if !restarted.UpdateEndpointFromSeed() {
+		t.Fatalf("5 expect update from cached endpoint")
+	}
+	if e, a := endpoint.URL, activeURLs(restarted); e != a {
+		t.Errorf("6 expect active %s, got %s", e, a)
+	}
+
+	// the cached endpoints are probed like the seeds
+	restarted = newCacheCollection(t, path)
+	dropActiveEndpoints(restarted)
+	if !restarted.probeEndpointFromSeed() {
+		t.Fatalf("7 expect cached endpoint probed")
+	}
+	if e, a := endpoint.URL, activeURLs(restarted); e != a {
+		t.Errorf("8 expect active %s, got %s", e, a)
+	}
+}
+
+func TestCacheDisabled(t *testing.T) {
+	server, endpoint := newRgwServer(t, 3)
+	defer server.Close()
+
+	path, cleanup := writeEndpointsFile(t, "http://127.0.0.1:808")
+	defer cleanup()
+
+	ec := newCacheCollection(t, path)
+	ec.options.CachePath = ""
+	if !ec.UpdateEndpointsByEndpoint(endpoint, true) {
+		t.Fatalf("1 expect update from server")
+	}
+	if _, err := os.Stat(DefaultCachePath(path)); !os.IsNotExist(err) {
+		t.Errorf("2 expect no cache, got err %v", err)
+	}
+	if e, a := 0, len(ec.cachedEndpoints); e != a {
+		t.Errorf("3 expect %d cached endpoints, got %d", e, a)
+	}
+}
+
+func TestCacheWriteFailureLogged(t *testing.T) {
+	server, endpoint := newRgwServer(t, 3)
+	defer server.Close()
+
+	path, cleanup := writeEndpointsFile(t, "http://127.0.0.1:808")
+	defer cleanup()
+
+	ec := newCacheCollection(t, path)
+	ec.options.CachePath = filepath.Join(path+"_dont_exist", "endpoints.cache")
+	var logs []string
+	ec.options.Logger = func(args ...interface{}) {
+		logs = append(logs, fmt.Sprint(args...))
+	}
+	if !ec.UpdateEndpointsByEndpoint(endpoint, true) {
+		t.Fatalf("1 expect update from server")
+	}
+	if e, a := 1, len(logs); e != a {
+		t.Fatalf("2 expect %d log, got %v", e, logs)
+	}
+	if e, a := "failed to write the endpoints cache", logs[0]; !strings.Contains(a, e) {
+		t.Errorf("3 expect %q in %q", e, a)
+	}
+}
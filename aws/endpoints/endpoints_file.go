@@ -0,0 +1,279 @@
+// Copyright 2020 Baidu, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License"); you may not use this file
+// except in compliance with the License. You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software distributed under the
+// License is distributed on an "AS IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND,
+// either express or implied. See the License for the specific language governing permissions
+// and limitations under the License.
+
+package endpoints
+
+import (
+	"bufio"
+	"bytes"
+	"fmt"
+	"io/ioutil"
+	"strconv"
+	"strings"
+
+	"github.com/aws/aws-sdk-go/internal/ini"
+)
+
+// An endpoints file lists the gateways of a collection in one of two
+// formats. The line format has one URL per line, followed by optional
+// attributes:
+//
+//	# comment, or ; comment
+//	http://10.0.0.1:8080
+//	http://10.0.0.2:8080 weight=2 zone=dc1 max_connections=64
+//
+// The INI format has one section per gateway, and is detected by a section
+// header on its first line which is not a comment:
+//
+//	[gw1]
+//	url = https://10.0.0.1
+//	weight = 2
+//	zone = dc1
+//	max_connections = 64
+//	max_idle_connections = 16
+//	tls_server_name = s3.example.com
+//	disabled = false
+//
+// Both formats take the same attributes. Disabled gateways are skipped.
+const (
+	endpointAttrURL            = "url"
+	endpointAttrWeight         = "weight"
+	endpointAttrZone           = "zone"
+	endpointAttrMaxConnections = "max_connections"
+	endpointAttrMaxIdleConns   = "max_idle_connections"
+	endpointAttrTLSServerName  = "tls_server_name"
+	endpointAttrDisabled       = "disabled"
+)
+
+// EndpointParseError is an invalid entry of an endpoints file. The entry is
+// skipped, the other entries of the file are still used.
+type EndpointParseError struct {
+	// The path of the endpoints file, empty for a list of gateways given
+	// in code.
+	File string
+
+	// The line of the entry, or its index in the list of gateways,
+	// starting at 1.
+	Line int
+
+	Err error
+}
+
+func (e *EndpointParseError) Error() string {
+	if e.File == "" {
+		return fmt.Sprintf("gateway %d: %v", e.Line, e.Err)
+	}
+	return fmt.Sprintf("%s:%d: %v", e.File, e.Line, e.Err)
+}
+
+// readEndpointsFile returns the enabled endpoints of the file, and the
+// errors of its invalid entries. err is only set if the file cannot be read
+// or parsed at all.
+func readEndpointsFile(path string) (endpoints []SingleEndpoint, parseErrors []error, err error) {
+	content, err := ioutil.ReadFile(path)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	if isINIEndpointsFile(content) {
+		return parseINIEndpoints(path, content)
+	}
+	endpoints, parseErrors = parseLineEndpoints(path, content)
+	return endpoints, parseErrors, nil
+}
+
+// the INI format starts with a section header
+func isINIEndpointsFile(content []byte) bool {
+	scanner := bufio.NewScanner(bytes.NewReader(content))
+	for scanner.Scan() {
+		line := strings.TrimSpace(scanner.Text())
+		if line == "" || isCommentLine(line) {
+			continue
+		}
+		return strings.HasPrefix(line, "[")
+	}
+	return false
+}
+
+// a comment starts with # or ;, in both formats
+func isCommentLine(line string) bool {
+	return strings.HasPrefix(line, "#") || strings.HasPrefix(line, ";")
+}
+
+// parseEndpointList parses a list of gateways given in code, each in the
+// format of a line of an endpoints file
+func parseEndpointList(gateways []string) ([]SingleEndpoint, []error) {
+	return parseLineEndpoints("", []byte(strings.Join(gateways, "\n")))
+}
+
+func parseLineEndpoints(path string, content []byte) ([]SingleEndpoint, []error) {
+	var (
+		endpoints   []SingleEndpoint
+		parseErrors []error
+	)
+
+	scanner := bufio.NewScanner(bytes.NewReader(content))
+	for lineNum := 1; scanner.Scan(); lineNum++ {
+		line := strings.TrimSpace(scanner.Text())
+		if line == "" || isCommentLine(line) {
+			continue
+		}
+
+		endpoints = append(endpoints, SingleEndpoint{})
+		disabled, err := parseEndpointLine(line, &endpoints[len(endpoints)-1])
+		if err != nil {
+			parseErrors = append(parseErrors, &EndpointParseError{
+				File: path, Line: lineNum, Err: err,
+			})
+		}
+		if err != nil || disabled {
+			endpoints[len(endpoints)-1] = SingleEndpoint{}
+			endpoints = endpoints[:len(endpoints)-1]
+		}
+	}
+	return endpoints, parseErrors
+}
+
+// parseEndpointLine parses a line of an endpoints file, an URL followed by
+// optional attributes, such as "http://10.0.0.1:8080 zone=dc1"
+func parseEndpointLine(line string, endpoint *SingleEndpoint) (disabled bool, err error) {
+	fields := strings.Fields(line)
+	if len(fields) == 0 {
+		return false, fmt.Errorf("endpoint is empty")
+	}
+
+	if err := parseEndpointURL(fields[0], endpoint); err != nil {
+		return false, err
+	}
+
+	for _, field := range fields[1:] {
+		kv := strings.SplitN(field, "=", 2)
+		if len(kv) != 2 {
+			return false, fmt.Errorf("invalid attribute %q of %s", field, fields[0])
+		}
+		if kv[0] == endpointAttrURL {
+			return false, fmt.Errorf("unknown attribute %q of %s", kv[0], fields[0])
+		}
+		if disabled, err = setEndpointAttr(endpoint, kv[0], kv[1], disabled); err != nil {
+			return false, err
+		}
+	}
+	return disabled, nil
+}
+
+func parseINIEndpoints(path string, content []byte) ([]SingleEndpoint, []error, error) {
+	sections, err := ini.ParseBytes(content)
+	if err != nil {
+		return nil, nil, fmt.Errorf("%s: %v", path, err)
+	}
+
+	// the ini parser does not keep the lines, find the line of each section
+	sectionLines := map[string]int{}
+	scanner := bufio.NewScanner(bytes.NewReader(content))
+	for lineNum := 1; scanner.Scan(); lineNum++ {
+		line := strings.TrimSpace(scanner.Text())
+		if strings.HasPrefix(line, "[") && strings.HasSuffix(line, "]") {
+			name := strings.TrimSpace(line[1 : len(line)-1])
+			if _, ok := sectionLines[name]; !ok {
+				sectionLines[name] = lineNum
+			}
+		}
+	}
+
+	var (
+		endpoints   []SingleEndpoint
+		parseErrors []error
+	)
+	attrs := []string{
+		endpointAttrWeight,
+		endpointAttrZone,
+		endpointAttrMaxConnections,
+		endpointAttrMaxIdleConns,
+		endpointAttrTLSServerName,
+		endpointAttrDisabled,
+	}
+	for _, name := range sections.List() {
+		section, _ := sections.GetSection(name)
+		// the default section holds the keys before the first header
+		if name == "" {
+			continue
+		}
+
+		endpoints = append(endpoints, SingleEndpoint{})
+		endpoint := &endpoints[len(endpoints)-1]
+		err := parseEndpointURL(section.String(endpointAttrURL), endpoint)
+		disabled := false
+		for _, attr := range attrs {
+			if err != nil {
+				break
+			}
+			if section.Has(attr) {
+				disabled, err = setEndpointAttr(endpoint, attr, section.String(attr), disabled)
+			}
+		}
+		if err != nil {
+			parseErrors = append(parseErrors, &EndpointParseError{
+				File: path, Line: sectionLines[name], Err: fmt.Errorf("[%s] %v", name, err),
+			})
+		}
+		if err != nil || disabled {
+			endpoints[len(endpoints)-1] = SingleEndpoint{}
+			endpoints = endpoints[:len(endpoints)-1]
+		}
+	}
+	return endpoints, parseErrors, nil
+}
+
+func parseEndpointURL(URL string, endpoint *SingleEndpoint) error {
+	if len(URL) < MinEndpointLength {
+		return fmt.Errorf("endpoint %q is too short", URL)
+	}
+	if err := parseEndpointFromString(URL, endpoint); err != nil {
+		return err
+	}
+	if endpoint.Host == "" {
+		return fmt.Errorf("endpoint %q has no host", URL)
+	}
+	return nil
+}
+
+// setEndpointAttr sets one attribute of the endpoint, disabled is updated
+// by the disabled attribute
+func setEndpointAttr(endpoint *SingleEndpoint, key, value string, disabled bool) (bool, error) {
+	var err error
+	switch key {
+	case endpointAttrWeight:
+		endpoint.Weight, err = strconv.Atoi(value)
+	case endpointAttrZone:
+		endpoint.Zone = value
+	case endpointAttrMaxConnections:
+		endpoint.MaxConnections, err = strconv.Atoi(value)
+		if err == nil && endpoint.MaxConnections < 0 {
+			err = fmt.Errorf("must not be negative")
+		}
+	case endpointAttrMaxIdleConns:
+		endpoint.MaxIdleConnections, err = strconv.Atoi(value)
+		if err == nil && endpoint.MaxIdleConnections < 0 {
+			err = fmt.Errorf("must not be negative")
+		}
+	case endpointAttrTLSServerName:
+		endpoint.TLSServerName = value
+	case endpointAttrDisabled:
+		disabled, err = strconv.ParseBool(value)
+	default:
+		return disabled, fmt.Errorf("unknown attribute %q of %s", key, endpoint.URL)
+	}
+	if err != nil {
+		return disabled, fmt.Errorf("invalid %s %q of %s, %v", key, value, endpoint.URL, err)
+	}
+	return disabled, nil
+}
@@ -0,0 +1,269 @@
+package endpoints
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func writeEndpointsFile(t *testing.T, lines ...string) (string, func()) {
+	dir, err := ioutil.TempDir("", "aws-sdk-go-endpoints-file")
+	if err != nil {
+		t.Fatalf("expect nil, got err %v", err)
+	}
+
+	path := filepath.Join(dir, "endpoints")
+	if err := ioutil.WriteFile(path, []byte(strings.Join(lines, "\n")), 0644); err != nil {
+		os.RemoveAll(dir)
+		t.Fatalf("expect nil, got err %v", err)
+	}
+	return path, func() { os.RemoveAll(dir) }
+}
+
+func TestParseEndpointLine(t *testing.T) {
+	cases := map[string]struct {
+		line     string
+		expect   *SingleEndpoint
+		disabled bool
+		err      bool
+	}{
+		"url": {
+			line:   "http://abc1.test:8080",
+			expect: &SingleEndpoint{URL: "http://abc1.test:8080"},
+		},
+		"attributes": {
+			line: "https://10.0.0.1:443   zone=dc1 weight=3 max_connections=64 max_idle_connections=8 tls_server_name=s3.test",
+			expect: &SingleEndpoint{
+				URL:                "https://10.0.0.1:443",
+				Zone:               "dc1",
+				Weight:             3,
+				MaxConnections:     64,
+				MaxIdleConnections: 8,
+				TLSServerName:      "s3.test",
+			},
+		},
+		"ipv6": {
+			line:   "http://[fd00::1]:8080",
+			expect: &SingleEndpoint{URL: "http://[fd00::1]:8080"},
+		},
+		"disabled": {
+			line:     "http://abc1.test:8080 disabled=true",
+			expect:   &SingleEndpoint{URL: "http://abc1.test:8080"},
+			disabled: true,
+		},
+		"too short": {
+			line: "ab",
+			err:  true,
+		},
+		"invalid attribute": {
+			line: "http://abc1.test:8080 dc1",
+			err:  true,
+		},
+		"unknown attribute": {
+			line: "http://abc1.test:8080 rack=r1",
+			err:  true,
+		},
+		"invalid weight": {
+			line: "http://abc1.test:8080 weight=heavy",
+			err:  true,
+		},
+		"negative max connections": {
+			line: "http://abc1.test:8080 max_connections=-1",
+			err:  true,
+		},
+		"negative max idle connections": {
+			line: "http://abc1.test:8080 max_idle_connections=-1",
+			err:  true,
+		},
+	}
+
+	for name, c := range cases {
+		endpoint := &SingleEndpoint{}
+		disabled, err := parseEndpointLine(c.line, endpoint)
+		if c.err {
+			if err == nil {
+				t.Errorf("%s expect error, got nil", name)
+			}
+			continue
+		}
+		if err != nil {
+			t.Fatalf("%s expect nil, got err %v", name, err)
+		}
+		if e, a := c.disabled, disabled; e != a {
+			t.Errorf("%s expect disabled %v, got %v", name, e, a)
+		}
+		if e, a := c.expect.URL, endpoint.URL; e != a {
+			t.Errorf("%s expect url %s, got %s", name, e, a)
+		}
+		if e, a := c.expect.Zone, endpoint.Zone; e != a {
+			t.Errorf("%s expect zone %s, got %s", name, e, a)
+		}
+		if e, a := c.expect.Weight, endpoint.Weight; e != a {
+			t.Errorf("%s expect weight %d, got %d", name, e, a)
+		}
+		if e, a := c.expect.MaxConnections, endpoint.MaxConnections; e != a {
+			t.Errorf("%s expect max connections %d, got %d", name, e, a)
+		}
+		if e, a := c.expect.MaxIdleConnections, endpoint.MaxIdleConnections; e != a {
+			t.Errorf("%s expect max idle connections %d, got %d", name, e, a)
+		}
+		if e, a := c.expect.TLSServerName, endpoint.TLSServerName; e != a {
+			t.Errorf("%s expect tls server name %s, got %s", name, e, a)
+		}
+	}
+}
+
+func TestReadLineEndpointsFile(t *testing.T) {
+	path, cleanup := writeEndpointsFile(t,
+		"# gateways",
+		"; http://abc5.test:8080",
+		"http://abc1.test:8080 zone=dc1",
+		"ab",
+		"",
+		"http://abc2.test:8080 weight=x",
+		"http://abc3.test:8080 disabled=1",
+		"http://abc4.test:8080",
+	)
+	defer cleanup()
+
+	ec := &EndpointCollection{blackList: make(map[string]*SingleEndpoint)}
+	if err := ec.ReadEndpointsFromFile(path, true); err != nil {
+		t.Fatalf("1 expect nil, got err %v", err)
+	}
+
+	var URLs []string
+	for _, endpoint := range ec.activeEndpoints() {
+		URLs = append(URLs, endpoint.URL)
+	}
+	if e, a := "http://abc1.test:8080,http://abc4.test:8080", strings.Join(URLs, ","); e != a {
+		t.Errorf("2 expect %s, got %s", e, a)
+	}
+	if e, a := "", ec.activeEndpoints()[1].Zone; e != a {
+		t.Errorf("3 expect zone %q, got %q", e, a)
+	}
+
+	errs := ec.ParseErrors()
+	if e, a := 2, len(errs); e != a {
+		t.Fatalf("4 expect %d errors, got %d", e, a)
+	}
+	for i, line := range []int{4, 6} {
+		err, ok := errs[i].(*EndpointParseError)
+		if !ok {
+			t.Fatalf("5 expect *EndpointParseError, got %T", errs[i])
+		}
+		if e, a := path, err.File; e != a {
+			t.Errorf("6 expect file %s, got %s", e, a)
+		}
+		if e, a := line, err.Line; e != a {
+			t.Errorf("7 expect line %d, got %d", e, a)
+		}
+		if !strings.HasPrefix(err.Error(), path+":") {
+			t.Errorf("8 expect error to start with the file, got %s", err.Error())
+		}
+	}
+}
+
+func TestReadINIEndpointsFile(t *testing.T) {
+	path, cleanup := writeEndpointsFile(t,
+		"; hot cluster",
+		"[gw1]",
+		"url = https://10.0.0.1",
+		"weight = 2",
+		"zone = dc1",
+		"max_connections = 64",
+		"tls_server_name = s3.test",
+		"",
+		"[gw2]",
+		"url = http://10.0.0.2:8080",
+		"disabled = true",
+		"",
+		"[gw3]",
+		"url = http://10.0.0.3:8080",
+		"weight = heavy",
+		"",
+		"[gw4]",
+		"url = 10.0.0.4:8080",
+	)
+	defer cleanup()
+
+	ec := &EndpointCollection{blackList: make(map[string]*SingleEndpoint)}
+	if err := ec.ReadEndpointsFromFile(path, true); err != nil {
+		t.Fatalf("1 expect nil, got err %v", err)
+	}
+
+	endpoints := ec.activeEndpoints()
+	if e, a := 2, len(endpoints); e != a {
+		t.Fatalf("2 expect %d endpoints, got %d", e, a)
+	}
+	gw1 := endpoints[0]
+	if e, a := "https://10.0.0.1", gw1.URL; e != a {
+		t.Errorf("3 expect %s, got %s", e, a)
+	}
+	if gw1.Weight != 2 || gw1.Zone != "dc1" || gw1.MaxConnections != 64 || gw1.TLSServerName != "s3.test" {
+		t.Errorf("4 expect attributes of gw1, got %+v", gw1)
+	}
+	if e, a := "http://10.0.0.4:8080", endpoints[1].URL; e != a {
+		t.Errorf("5 expect %s, got %s", e, a)
+	}
+
+	errs := ec.ParseErrors()
+	if e, a := 1, len(errs); e != a {
+		t.Fatalf("6 expect %d errors, got %d", e, a)
+	}
+	if e, a := 13, errs[0].(*EndpointParseError).Line; e != a {
+		t.Errorf("7 expect line %d, got %d", e, a)
+	}
+	if !strings.Contains(errs[0].Error(), "[gw3]") {
+		t.Errorf("8 expect section in error, got %s", errs[0].Error())
+	}
+}
+
+func TestReadInvalidEndpointsFile(t *testing.T) {
+	path, cleanup := writeEndpointsFile(t, "ab", "http://abc1.test:8080 weight=x")
+	defer cleanup()
+
+	if _, err := NewEndpointCollection(path, 1); err == nil {
+		t.Errorf("1 expect error, got nil")
+	} else if !strings.Contains(err.Error(), path+":1") {
+		t.Errorf("2 expect file and line in error, got %v", err)
+	}
+
+	path, cleanup = writeEndpointsFile(t, "[gw1", "url = http://abc1.test:8080")
+	defer cleanup()
+	if _, err := NewEndpointCollection(path, 1); err == nil {
+		t.Errorf("3 expect error, got nil")
+	}
+}
+
+func TestSelectEndpointMaxConnections(t *testing.T) {
+	path, cleanup := writeEndpointsFile(t,
+		"http://abc1.test:8080 max_connections=1",
+		"http://abc2.test:8080",
+	)
+	defer cleanup()
+
+	ec := &EndpointCollection{blackList: make(map[string]*SingleEndpoint)}
+	if err := ec.ReadEndpointsFromFile(path, true); err != nil {
+		t.Fatalf("1 expect nil, got err %v", err)
+	}
+	busy := ec.activeEndpoints()[0]
+	busy.Acquire()
+
+	p := NewRoundRobinPolicy()
+	for i := 0; i < 4; i++ {
+		if e, a := "http://abc2.test:8080", ec.SelectEndpoint(p).URL; e != a {
+			t.Errorf("2 expect %s, got %s", e, a)
+		}
+	}
+
+	busy.Release()
+	seen := map[string]bool{}
+	for i := 0; i < 4; i++ {
+		seen[ec.SelectEndpoint(p).URL] = true
+	}
+	if e, a := 2, len(seen); e != a {
+		t.Errorf("3 expect %d endpoints, got %d", e, a)
+	}
+}
@@ -13,18 +13,19 @@
 package endpoints
 
 import (
-	"bufio"
+	"context"
 	"encoding/xml"
+	"errors"
 	"fmt"
-	"io"
 	"io/ioutil"
 	"math/rand"
+	"net"
 	"net/http"
 	"net/url"
-	"os"
 	"strconv"
 	"strings"
 	"sync"
+	"sync/atomic"
 	"time"
 )
 
@@ -42,6 +43,7 @@ var (
 func init() {
 	GEndpoints = &GlobalEndpoints{
 		endpointCollections: make(map[string]*EndpointCollection),
+		references:          make(map[string]int),
 	}
 	rand.Seed(time.Now().UTC().UnixNano())
 }
@@ -52,6 +54,9 @@ type Rgw struct {
 
 	// The port of endpoint.
 	Port string `xml:"Port"`
+
+	// The zone of endpoint, optional.
+	Zone string `xml:"Zone"`
 }
 
 type RgwInfo struct {
@@ -63,6 +68,11 @@ type RgwInfo struct {
 
 // save the info of one endpoint
 type SingleEndpoint struct {
+	// number of requests in flight, accessed atomically
+	inflight int64
+
+	// IsInBlackList, Id, next and pre are guarded by the lock of the
+	// collection
 	IsInBlackList bool
 	Id            uint64
 	Protocol      string
@@ -72,9 +82,39 @@ type SingleEndpoint struct {
 	URL           string
 	next          *SingleEndpoint
 	pre           *SingleEndpoint
+
+	// Weight is used by weighted balance policies, 0 means 1
+	Weight int
+
+	// Zone is the datacenter or rack of the endpoint, optional
+	Zone string
+
+	// MaxConnections bounds the requests in flight to the endpoint, 0
+	// means unbounded
+	MaxConnections int
+
+	// TLSServerName is the name verified in the TLS handshake, when it
+	// differs from Host. It applies to the requests sent with the clients
+	// of EndpointCollection.HTTPClient and to the probes of the endpoint.
+	TLSServerName string
+
+	// MaxIdleConnections bounds the idle connections kept to the endpoint,
+	// 0 means the default of the collection
+	MaxIdleConnections int
+
+	// bounds the requests in flight, see ConcurrencyLimit
+	limiter endpointLimiter
+
+	// passive health learned from the requests sent to the endpoint
+	stats endpointStats
+
+	// decides when the endpoint is blacklisted and when it recovers
+	breaker circuitBreaker
 }
 
 // save all endpoints
+// The ring of endpoints and the blacklist are only changed under mutex, the
+// request path reads the snapshot in active instead.
 type EndpointCollection struct {
 	numOfActiveEndpoint int
 	lastEpoch           int
@@ -86,18 +126,147 @@ type EndpointCollection struct {
 	httpClient          *http.Client
 	mutex               sync.Mutex
 	notify              chan bool
+
+	// the current *endpointSnapshot
+	active atomic.Value
+
+	// the invalid entries of the last endpoints file read
+	parseErrors []error
+
+	// the endpoints file of the seeds, and its version last read
+	seedPath  string
+	seedStamp fileStamp
+
+	// the last endpoint list received from the server, tried after the
+	// seeds
+	cachedEndpoints []*SingleEndpoint
+
+	// ctx is canceled by Close, it stops the keep alive and aborts the
+	// probes in flight
+	ctx       context.Context
+	cancel    context.CancelFunc
+	closeOnce sync.Once
+	wg        sync.WaitGroup
+
+	options CollectionOptions
+
+	// bounds the number of probes in flight
+	probeSlots     chan struct{}
+	probeSlotsOnce sync.Once
+
+	events endpointEvents
+
+	// the recent read latencies, see HedgeDelay
+	reads latencyWindow
+
+	// the connections of the endpoints, see HTTPClient
+	clients endpointClients
+}
+
+// CollectionOptions configures an EndpointCollection.
+type CollectionOptions struct {
+	// The circuit breaker of each endpoint of the collection.
+	CircuitBreaker CircuitBreakerConfig
+
+	// Probes blacklisted and seed endpoints. Defaults to
+	// DefaultHealthChecker.
+	HealthChecker HealthChecker
+
+	// Timeout of one probe, or of one request fetching the endpoint list
+	// from the server. Defaults to DefaultProbeTimeout.
+	ProbeTimeout time.Duration
+
+	// Maximum number of probes in flight. Defaults to
+	// DefaultProbeConcurrency.
+	ProbeConcurrency int
+
+	// The zone of the client. Endpoints of the same zone are preferred,
+	// the other zones only take traffic when too many endpoints of the
+	// zone are blacklisted. Empty disables the preference.
+	LocalZone string
+
+	// Fraction of the endpoints of LocalZone which must be active for the
+	// traffic to stay in the zone, between 0 and 1. With 0, the traffic
+	// only spills over to other zones once every endpoint of the zone is
+	// blacklisted.
+	ZoneSpillover float64
+
+	// How often the endpoints file is checked for changes. Added and
+	// removed seeds are merged into the collection without a restart.
+	// Defaults to DefaultSeedReloadInterval, negative disables the reload.
+	SeedReloadInterval time.Duration
+
+	// The file in which the last endpoint list received from the server is
+	// kept. On start its endpoints are tried after the seeds, so that the
+	// client recovers even when every seed is stale. Empty disables the
+	// cache.
+	CachePath string
+
+	// Finds the gateways of the collection in place of the endpoint list
+	// API of the server, such as DNSSRVDiscoverer. The endpoints file then
+	// only provides the seeds used until the first discovery, and once all
+	// the discovered gateways are blacklisted. Nil uses the server.
+	Discoverer GatewayDiscoverer
+
+	// Logs the warnings of the collection, such as the timeouts of
+	// HTTPClient which cannot apply, and its events when LogEvents is set.
+	// It is called without the lock of the collection held, and should
+	// not block. Nil disables the logging.
+	Logger func(args ...interface{})
+
+	// Also logs the events of the collection, see Subscribe.
+	LogEvents bool
+
+	// Timeouts and limits of the connections of the prober.
+	ProbeTransport TransportConfig
+
+	// Maximum number of requests in flight to one endpoint, for the
+	// endpoints without max_connections. Requests to a saturated endpoint
+	// go to the other endpoints, or wait in its queue when every endpoint
+	// is saturated. 0 means unbounded.
+	MaxConnectionsPerEndpoint int
+
+	// Adapts the number of requests in flight to each endpoint to its
+	// latency and throttling, see ConcurrencyLimitConfig. Nil only bounds
+	// them by MaxConnectionsPerEndpoint.
+	ConcurrencyLimit *ConcurrencyLimitConfig
+
+	// Maximum number of idle connections kept to one endpoint, for the
+	// endpoints without max_idle_connections. 0 keeps the default of the
+	// http client.
+	MaxIdleConnsPerEndpoint int
+
+	// Routes the requests of each object to a preferred endpoint chosen by
+	// hashing its bucket and key, see SelectEndpointByKey. It keeps the
+	// objects in the caches of the gateways, at the cost of the balance
+	// policy.
+	ConsistentHash bool
 }
 
 // manage all endpoint collections
 type GlobalEndpoints struct {
 	endpointCollections map[string]*EndpointCollection
+	references          map[string]int
 	mutex               sync.Mutex
 }
 
 // reading endpoints from file
 // and creating an new endpoints collection
-func NewEndpointCollection(endpointsPath string, keepAliveInterval int) (
-	*EndpointCollection, error) {
+func NewEndpointCollection(endpointsPath string, keepAliveInterval int,
+	optFns ...func(*CollectionOptions)) (*EndpointCollection, error) {
+
+	endpoints, err := newFileEndpointCollection(endpointsPath, keepAliveInterval, optFns)
+	if err != nil {
+		return nil, err
+	}
+	endpoints.start()
+	return endpoints, nil
+}
+
+// newFileEndpointCollection returns the collection of the endpoints file at
+// endpointsPath, not started yet
+func newFileEndpointCollection(endpointsPath string, keepAliveInterval int,
+	optFns []func(*CollectionOptions)) (*EndpointCollection, error) {
 
 	if endpointsPath == "" {
 		return nil, fmt.Errorf("endpoint path is empty")
@@ -107,22 +276,165 @@ func NewEndpointCollection(endpointsPath string, keepAliveInterval int) (
 		return nil, fmt.Errorf("keepAliveInterval must be equal or greater than 0")
 	}
 
-	httpClient := NewHttpClient()
-	endpoints := &EndpointCollection{
-		lastEpoch:         -1,
-		keepAliveInterval: keepAliveInterval,
-		httpClient:        httpClient,
-		blackList:         make(map[string]*SingleEndpoint),
-		notify:            make(chan bool),
+	// a change made after the stat is seen by the first reload
+	stamp, err := statEndpointsFile(endpointsPath)
+	if err != nil {
+		return nil, err
 	}
+
+	endpoints := newEndpointCollection(keepAliveInterval, optFns)
+	endpoints.seedPath = endpointsPath
+	endpoints.seedStamp = stamp
 	if err := endpoints.ReadEndpointsFromFile(endpointsPath, true); err != nil {
+		endpoints.cancel()
 		return nil, err
 	}
-	// start keep alive in the background
-	go endpoints.KeepAlive()
 	return endpoints, nil
 }
 
+// NewEndpointCollectionFromList creates an endpoints collection from a list
+// of gateways instead of a file. Each gateway is given in the format of a
+// line of an endpoints file: a URL followed by optional attributes, such as
+// "http://10.0.0.1:8080 zone=dc1". The collection behaves like one created
+// from a file, except that its seeds are never reloaded.
+func NewEndpointCollectionFromList(gateways []string, keepAliveInterval int,
+	optFns ...func(*CollectionOptions)) (*EndpointCollection, error) {
+
+	if len(gateways) == 0 {
+		return nil, fmt.Errorf("gateway list is empty")
+	}
+
+	if keepAliveInterval < 0 {
+		return nil, fmt.Errorf("keepAliveInterval must be equal or greater than 0")
+	}
+
+	endpointAll, parseErrors := parseEndpointList(gateways)
+	if len(endpointAll) == 0 {
+		if len(parseErrors) > 0 {
+			return nil, fmt.Errorf("no valid gateway, %v", parseErrors[0])
+		}
+		return nil, fmt.Errorf("every gateway is disabled")
+	}
+
+	endpoints := newEndpointCollection(keepAliveInterval, optFns)
+	if err := endpoints.useEndpoints(endpointAll, parseErrors, true); err != nil {
+		endpoints.cancel()
+		return nil, err
+	}
+	endpoints.start()
+	return endpoints, nil
+}
+
+// newEndpointCollection returns an empty collection, not started yet
+func newEndpointCollection(keepAliveInterval int,
+	optFns []func(*CollectionOptions)) *EndpointCollection {
+
+	var options CollectionOptions
+	for _, fn := range optFns {
+		fn(&options)
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	return &EndpointCollection{
+		lastEpoch:         -1,
+		keepAliveInterval: keepAliveInterval,
+		httpClient:        NewHttpClientWithConfig(options.ProbeTransport),
+		blackList:         make(map[string]*SingleEndpoint),
+		notify:            make(chan bool, 1),
+		ctx:               ctx,
+		cancel:            cancel,
+		options:           options,
+	}
+}
+
+// start loads the cache and starts the keep alive in the background
+func (e *EndpointCollection) start() {
+	e.loadCache()
+
+	e.wg.Add(1)
+	go func() {
+		defer e.wg.Done()
+		e.KeepAlive()
+	}()
+	if e.seedPath != "" {
+		e.wg.Add(1)
+		go func() {
+			defer e.wg.Done()
+			e.watchSeed()
+		}()
+	}
+}
+
+// Close stops the keep alive of the collection, aborts the probes in
+// flight and closes the idle connections of the prober. Endpoints can still
+// be selected from a closed collection, but they are no longer probed or
+// updated from the server. Close is safe to call more than once.
+func (e *EndpointCollection) Close() error {
+	e.closeOnce.Do(func() {
+		if e.cancel != nil {
+			e.cancel()
+		}
+		e.wg.Wait()
+
+		e.closeClients("")
+		if e.httpClient != nil {
+			type idleCloser interface {
+				CloseIdleConnections()
+			}
+			if t, ok := e.httpClient.Transport.(idleCloser); ok {
+				t.CloseIdleConnections()
+			}
+		}
+	})
+	return nil
+}
+
+// closed returns a channel which is closed when the collection is closed
+func (e *EndpointCollection) closed() <-chan struct{} {
+	if e.ctx == nil {
+		return nil
+	}
+	return e.ctx.Done()
+}
+
+func (e *EndpointCollection) context() context.Context {
+	if e.ctx == nil {
+		return context.Background()
+	}
+	return e.ctx
+}
+
+// wake up the keep alive without blocking the caller
+func (e *EndpointCollection) notifyKeepAlive() {
+	select {
+	case e.notify <- true:
+	default:
+	}
+}
+
+// sleep waits for d, or until the collection is closed. If wakeOnNotify is
+// true it also returns early when the keep alive is notified.
+func (e *EndpointCollection) sleep(d time.Duration, wakeOnNotify bool) (
+	notified, closed bool) {
+
+	notify := e.notify
+	if !wakeOnNotify {
+		notify = nil
+	}
+
+	timer := time.NewTimer(d)
+	defer timer.Stop()
+
+	select {
+	case <-notify:
+		return true, false
+	case <-e.closed():
+		return false, true
+	case <-timer.C:
+		return false, false
+	}
+}
+
 // Update the head of EndpointCollection
 func (e *EndpointCollection) UpdateWholeEndpoitCollection(head *SingleEndpoint, endpointNum,
 	epoch int) error {
@@ -133,7 +445,18 @@ func (e *EndpointCollection) UpdateWholeEndpoitCollection(head *SingleEndpoint,
 
 	// update the head
 	e.mutex.Lock()
-	defer e.mutex.Unlock()
+	defer e.unlock()
+
+	// the endpoints before the update, true if blacklisted
+	before := make(map[string]bool)
+	for _, endpoint := range e.ringEndpoints() {
+		before[endpoint.URL] = false
+	}
+	for URL, endpoint := range e.blackList {
+		if endpoint.Id >= e.validMinEndpointId {
+			before[URL] = true
+		}
+	}
 
 	// update min valid endpoint id
 	e.endpointHead = head
@@ -145,67 +468,102 @@ func (e *EndpointCollection) UpdateWholeEndpoitCollection(head *SingleEndpoint,
 		head = head.next
 	}
 	e.validMinEndpointId = newValidMinEndpointId
-	e.lastEpoch = epoch
+	e.updateEpoch(epoch)
 
 	// clear blacklist
 	for k := range e.blackList {
 		delete(e.blackList, k)
 	}
 
+	for _, endpoint := range e.ringEndpoints() {
+		blacklisted, ok := before[endpoint.URL]
+		if !ok {
+			e.emit(EndpointAdded, endpoint.URL)
+		} else if blacklisted {
+			e.emit(EndpointRecovered, endpoint.URL)
+		}
+		delete(before, endpoint.URL)
+	}
+	for URL := range before {
+		e.emit(EndpointRemoved, URL)
+		e.closeClients(URL)
+	}
+
+	e.publish()
 	return nil
 }
 
-func (e *EndpointCollection) ReadEndpointsFromFile(endpointPath string, isSeed bool) error {
-	var (
-		head *SingleEndpoint
-	)
+// updateEpoch sets the epoch of the endpoint list of the collection
+// must protected by lock
+func (e *EndpointCollection) updateEpoch(epoch int) {
+	if epoch != e.lastEpoch && epoch >= 0 {
+		e.emitEvent(EndpointEvent{Type: EpochChanged, Time: time.Now(), Epoch: epoch})
+	}
+	e.lastEpoch = epoch
+}
 
+// ReadEndpointsFromFile replaces the endpoints of the collection by the
+// endpoints of the file. Invalid entries are skipped and reported by
+// ParseErrors, the file is only rejected when it has no valid entry.
+func (e *EndpointCollection) ReadEndpointsFromFile(endpointPath string, isSeed bool) error {
 	if endpointPath == "" {
 		return fmt.Errorf("endpoint path is empty")
 	}
 
-	fd, err := os.Open(endpointPath)
+	endpointAll, parseErrors, err := readEndpointsFile(endpointPath)
 	if err != nil {
 		return err
 	}
-	defer fd.Close()
-
-	// start read endpoints from file
-	endpointSting := make([]string, 0, 1000)
-	scanner := bufio.NewScanner(fd)
-	for scanner.Scan() {
-		urlString := strings.TrimSpace(scanner.Text())
-		if urlString == "" {
-			continue
-		} else if strings.HasPrefix(urlString, "#") {
-			continue
-		} else if len(urlString) < MinEndpointLength {
-			continue
-		}
-		endpointSting = append(endpointSting, urlString)
+	if len(endpointAll) == 0 && len(parseErrors) > 0 {
+		return fmt.Errorf("no valid endpoint in %s, %v", endpointPath, parseErrors[0])
 	}
+	return e.useEndpoints(endpointAll, parseErrors, isSeed)
+}
+
+// useEndpoints replaces the endpoints of the collection by endpointAll
+func (e *EndpointCollection) useEndpoints(endpointAll []SingleEndpoint,
+	parseErrors []error, isSeed bool) error {
+
+	var (
+		head *SingleEndpoint
+	)
+
+	e.mutex.Lock()
+	e.parseErrors = parseErrors
+	e.unlock()
 
 	activeEndpoint := 0
-	endpointAll := make([]SingleEndpoint, len(endpointSting))
-	for i, key := range endpointSting {
-		if err := parseEndpointFromString(key, &endpointAll[i]); err != nil {
-			return err
-		}
+	for i := range endpointAll {
 		head = insertEndpointToHead(&endpointAll[i], head)
 		activeEndpoint++
 	}
 
-	err = e.UpdateWholeEndpoitCollection(head, activeEndpoint, e.lastEpoch)
+	e.mutex.Lock()
+	epoch := e.lastEpoch
+	e.unlock()
+
+	err := e.UpdateWholeEndpoitCollection(head, activeEndpoint, epoch)
 	if err != nil {
 		return err
 	}
 
 	if isSeed {
+		e.mutex.Lock()
 		e.endpointSeed = &endpointAll
+		e.unlock()
 	}
 	return nil
 }
 
+// ParseErrors returns the invalid entries of the endpoints file last read,
+// as *EndpointParseError values.
+func (e *EndpointCollection) ParseErrors() []error {
+	e.mutex.Lock()
+	defer e.unlock()
+
+	return append([]error(nil), e.parseErrors...)
+}
+
 // must protected by lock
 func (e *EndpointCollection) isInActiveEndpoints(endpoint *SingleEndpoint) bool {
 	if endpoint == nil || e.endpointHead == nil {
@@ -227,12 +585,39 @@ func (e *EndpointCollection) isInActiveEndpoints(endpoint *SingleEndpoint) bool
 }
 
 func (e *EndpointCollection) insertToEndpointHead(endpoint *SingleEndpoint) bool {
-	if endpoint == nil {
+	e.mutex.Lock()
+	defer e.unlock()
+
+	if !e.insertEndpoint(endpoint) {
 		return false
 	}
+	e.publish()
+	return true
+}
 
+// insertAllToEndpointHead inserts the endpoints with one lock, so that
+// selections never see a part of them. Returns the number inserted.
+func (e *EndpointCollection) insertAllToEndpointHead(endpoints []*SingleEndpoint) int {
 	e.mutex.Lock()
-	defer e.mutex.Unlock()
+	defer e.unlock()
+
+	inserted := 0
+	for _, endpoint := range endpoints {
+		if e.insertEndpoint(endpoint) {
+			inserted++
+		}
+	}
+	if inserted > 0 {
+		e.publish()
+	}
+	return inserted
+}
+
+// must protected by lock
+func (e *EndpointCollection) insertEndpoint(endpoint *SingleEndpoint) bool {
+	if endpoint == nil {
+		return false
+	}
 
 	if _, ok := e.blackList[endpoint.URL]; ok {
 		return false
@@ -247,26 +632,35 @@ func (e *EndpointCollection) insertToEndpointHead(endpoint *SingleEndpoint) bool
 	endpoint.Id = e.validMinEndpointId
 	e.endpointHead = insertEndpointToHead(endpoint, e.endpointHead)
 	e.numOfActiveEndpoint++
+	e.emit(EndpointAdded, endpoint.URL)
 	return true
 }
 
 func (e *EndpointCollection) AddEndpointToBlacklist(endpoint *SingleEndpoint) *SingleEndpoint {
+	// the endpoint stays active until its breaker opens, which does not
+	// need the lock of the collection
+	if endpoint == nil || !endpoint.breaker.onFailure(e.circuitBreakerConfig(), time.Now()) {
+		return e.GetRandEndpoint(0)
+	}
+
 	e.mutex.Lock()
-	defer e.mutex.Unlock()
+	defer e.unlock()
 
-	if endpoint == nil || endpoint.IsInBlackList {
+	if endpoint.IsInBlackList {
 		return e.GetRandEndpoint(0)
 	}
 
-	if endpoint.Id >= e.validMinEndpointId && e.isInActiveEndpoints(endpoint) {
+	active := endpoint.Id >= e.validMinEndpointId && e.isInActiveEndpoints(endpoint)
+	if active {
 		e.numOfActiveEndpoint--
 	}
 
 	if e.numOfActiveEndpoint <= 0 {
 		e.numOfActiveEndpoint = 0
 		e.endpointHead = nil
-		e.notify <- true
-	} else {
+		e.notifyKeepAlive()
+	} else if endpoint.next != nil {
+		// an endpoint removed from the collection is no longer linked
 		endpoint.next.pre = endpoint.pre
 		endpoint.pre.next = endpoint.next
 		if endpoint == e.endpointHead {
@@ -280,15 +674,47 @@ func (e *EndpointCollection) AddEndpointToBlacklist(endpoint *SingleEndpoint) *S
 
 	if endpoint.Id >= e.validMinEndpointId {
 		e.blackList[endpoint.URL] = endpoint
+		e.emit(EndpointBlacklisted, endpoint.URL)
 	}
+	if active && e.numOfActiveEndpoint == 0 {
+		e.emit(PoolExhausted, "")
+	}
+	e.publish()
 	return e.GetRandEndpoint(0)
 }
 
 // remove endpoint from balcklist and insert to active list
 func (e *EndpointCollection) RmEndpointFromBlacklist(host string) bool {
 	e.mutex.Lock()
-	defer e.mutex.Unlock()
+	defer e.unlock()
+
+	if !e.rmEndpointFromBlacklist(host) {
+		return false
+	}
+	e.publish()
+	return true
+}
+
+// rmEndpointsFromBlacklist recovers the endpoints with one lock, so that
+// selections never see a part of them. Returns the number recovered.
+func (e *EndpointCollection) rmEndpointsFromBlacklist(hosts []string) int {
+	e.mutex.Lock()
+	defer e.unlock()
+
+	recovered := 0
+	for _, host := range hosts {
+		if e.rmEndpointFromBlacklist(host) {
+			recovered++
+		}
+	}
+	if recovered > 0 {
+		e.publish()
+	}
+	return recovered
+}
 
+// must protected by lock
+func (e *EndpointCollection) rmEndpointFromBlacklist(host string) bool {
 	endpoint, ok := e.blackList[host]
 	if !ok {
 		return false
@@ -300,106 +726,116 @@ func (e *EndpointCollection) RmEndpointFromBlacklist(host string) bool {
 
 	delete(e.blackList, host)
 	endpoint.IsInBlackList = false
+	endpoint.breaker.halfOpen(e.circuitBreakerConfig())
 
 	if endpoint.Id >= e.validMinEndpointId {
 		e.endpointHead = insertEndpointToHead(endpoint, e.endpointHead)
 		e.numOfActiveEndpoint++
+		e.emit(EndpointRecovered, endpoint.URL)
 		return true
 	}
 	return false
 }
 
+// GetNextEndpoint returns the active endpoint following endpoint, or a
+// random one if endpoint is not active.
 func (e *EndpointCollection) GetNextEndpoint(endpoint *SingleEndpoint) *SingleEndpoint {
-	if endpoint == nil || endpoint.next == nil {
-		return e.GetRandEndpoint(0)
-	} else if endpoint.Id < e.validMinEndpointId || endpoint.IsInBlackList {
+	snapshot := e.snapshot()
+	i, ok := snapshot.positions[endpoint]
+	if !ok {
 		return e.GetRandEndpoint(0)
 	}
+	return snapshot.endpoints[(i+1)%len(snapshot.endpoints)]
+}
 
-	// get next valid endpoint
-	temp := endpoint.next
-	for temp != nil && temp != endpoint {
-		if temp.Id < e.validMinEndpointId || temp.IsInBlackList {
-			temp = temp.next
-		} else {
-			break
-		}
+// SelectEndpoint asks policy to choose one of the active endpoints.
+// DefaultBalancePolicy is used when policy is nil. Returns nil if no
+// endpoint is active.
+func (e *EndpointCollection) SelectEndpoint(policy BalancePolicy) *SingleEndpoint {
+	if policy == nil {
+		policy = DefaultBalancePolicy
 	}
 
-	if temp != nil {
-		return temp
-	} else {
-		return e.GetRandEndpoint(0)
+	candidates := e.selectable()
+	if len(candidates) == 0 {
+		return nil
+	}
+	return policy.Pick(candidates)
+}
+
+// selectable returns the endpoints a request may be sent to
+func (e *EndpointCollection) selectable() []*SingleEndpoint {
+	candidates := e.snapshot().candidates(e.options.ZoneSpillover)
+	if len(candidates) == 0 {
+		return nil
+	}
+
+	// half-open and busy endpoints only take a trickle of traffic, unless
+	// nothing else is left
+	cfg := e.circuitBreakerConfig()
+	admitted := candidates[:0:0]
+	for _, endpoint := range candidates {
+		if endpoint.admits(cfg, e.limiter(endpoint)) {
+			admitted = append(admitted, endpoint)
+		}
+	}
+	if len(admitted) > 0 {
+		return admitted
 	}
+	return candidates
 }
 
 // get a random endpoint from EndpointCollection
+// retryTime > 0 picks the endpoint at that offset of the active endpoints
 func (e *EndpointCollection) GetRandEndpoint(retryTime int) *SingleEndpoint {
-	temp := e.endpointHead
-	if temp == nil || e.numOfActiveEndpoint == 0 {
+	endpoints := e.activeEndpoints()
+	if len(endpoints) == 0 {
 		return nil
 	}
 
 	if retryTime <= 0 {
-		retryTime = rand.Intn(e.numOfActiveEndpoint)
+		retryTime = rand.Intn(len(endpoints))
 	}
+	return endpoints[retryTime%len(endpoints)]
+}
 
-	for (temp != nil) && (retryTime > 0) {
-		temp = temp.next
-		retryTime--
-	}
+// Acquire marks the start of a request sent to the endpoint.
+func (s *SingleEndpoint) Acquire() {
+	atomic.AddInt64(&s.inflight, 1)
+}
 
-	// get next valid endpoint
-	for temp != nil {
-		if temp.Id < e.validMinEndpointId || temp.IsInBlackList {
-			temp = temp.next
-		} else {
-			break
-		}
-	}
+// Release marks the end of a request started with Acquire.
+func (s *SingleEndpoint) Release() {
+	atomic.AddInt64(&s.inflight, -1)
+}
 
-	if temp == nil {
-		return e.endpointHead
-	}
-	return temp
+// Inflight returns the number of requests currently sent to the endpoint.
+func (s *SingleEndpoint) Inflight() int64 {
+	return atomic.LoadInt64(&s.inflight)
 }
 
 func (e *EndpointCollection) probeEndpoint(URL string) bool {
-	// constrcut http request
-	httpRequest, err := newHttpRequestFromURL(URL, probeKey, "")
-	if err != nil {
-		return false
-	}
-	httpRequest.Method = http.MethodGet
-	e.httpClient.Timeout = ProbeRequestTimeOut
-
-	// send probe to endpoint
-	httpResponse, err := e.httpClient.Do(httpRequest)
-	if err != nil {
-		return false
-	} else if httpResponse == nil {
+	endpoint := &SingleEndpoint{}
+	if err := parseEndpointFromString(URL, endpoint); err != nil || endpoint.Host == "" {
 		return false
 	}
-
-	defer httpResponse.Body.Close()
-	io.Copy(ioutil.Discard, httpResponse.Body)
-	if httpResponse.StatusCode == 200 || httpResponse.StatusCode == 404 ||
-		httpResponse.StatusCode == 403 {
-		return true
-	}
-	return false
+	return e.check(e.context(), endpoint) == nil
 }
 
 func (e *EndpointCollection) probeBlacklist() bool {
-	blacklists := make([]string, 0, len(e.blackList))
-	dellists := make([]string, 0, len(e.blackList))
+	var blacklists []*SingleEndpoint
+	var dellists []string
 
 	{
+		now := time.Now()
 		e.mutex.Lock()
 		for k := range e.blackList {
 			endpoint := e.blackList[k]
 			if endpoint.Id >= e.validMinEndpointId {
-				blacklists = append(blacklists, k)
+				// wait for the open timeout of the breaker
+				if endpoint.breaker.canProbe(now) {
+					blacklists = append(blacklists, endpoint)
+				}
 			} else {
 				dellists = append(dellists, k)
 			}
@@ -407,51 +843,40 @@ func (e *EndpointCollection) probeBlacklist() bool {
 		for _, k := range dellists {
 			delete(e.blackList, k)
 		}
-		e.mutex.Unlock()
+		e.unlock()
 	}
 
-	success := false
-	for _, key := range blacklists {
-		if ok := e.probeEndpoint(key); ok {
-			e.RmEndpointFromBlacklist(key)
-			success = true
-		}
+	var healthy []string
+	for _, endpoint := range e.probeAll(blacklists) {
+		healthy = append(healthy, endpoint.URL)
 	}
-
-	return success
+	return e.rmEndpointsFromBlacklist(healthy) > 0
 }
 
 func (e *EndpointCollection) probeEndpointFromSeed() bool {
-	if e.endpointSeed == nil {
-		return false
-	}
-
-	success := false
-	for _, endpoint := range *e.endpointSeed {
-		if _, ok := e.blackList[endpoint.URL]; ok {
-			continue
-		}
+	e.mutex.Lock()
+	seeds := e.seedEndpoints()
+	e.unlock()
 
-		if ok := e.probeEndpoint(endpoint.URL); ok {
-			e.insertToEndpointHead(&endpoint)
-			success = true
-		}
+	healthy := e.probeAll(seeds)
+	endpoints := make([]*SingleEndpoint, 0, len(healthy))
+	for _, seed := range healthy {
+		endpoints = append(endpoints, seed.clone())
 	}
-	return success
+	return e.insertAllToEndpointHead(endpoints) > 0
 }
 
-// Download endpoint list from server
-// decode the endpoint list into RgwInfo
-func (e *EndpointCollection) getRgwInfoFromServer(endpoint *SingleEndpoint) (*RgwInfo, error) {
-	httpRequest, err := newHttpRequestFromURL(endpoint.URL, "/", "rgw")
+// fetchRgwInfo gets the endpoint list from the server of URL
+func fetchRgwInfo(ctx context.Context, client *http.Client, URL string) (*RgwInfo, error) {
+	httpRequest, err := newHttpRequestFromURL(URL, "/", "rgw")
 	if err != nil {
 		return nil, err
 	}
 	httpRequest.Method = http.MethodGet
-	e.httpClient.Timeout = ProbeRequestTimeOut
+	httpRequest = httpRequest.WithContext(ctx)
 
 	// send http request to endpoint
-	httpResponse, err := e.httpClient.Do(httpRequest)
+	httpResponse, err := client.Do(httpRequest)
 	if err != nil {
 		return nil, err
 	} else if httpResponse == nil {
@@ -461,7 +886,7 @@ func (e *EndpointCollection) getRgwInfoFromServer(endpoint *SingleEndpoint) (*Rg
 	defer httpResponse.Body.Close()
 	if httpResponse.StatusCode != 200 {
 		message, _ := ioutil.ReadAll(httpResponse.Body)
-		return nil, fmt.Errorf(string(message))
+		return nil, errors.New(string(message))
 	}
 	epochs, ok := httpResponse.Header["Last-Epoch"]
 	if !ok {
@@ -509,74 +934,46 @@ func (e *EndpointCollection) ParseEndpointFromRgwInfo(rgws *RgwInfo) (*SingleEnd
 		if err != nil {
 			continue
 		}
+		endpointAll[currentId].Zone = rgw.Zone
 		head = insertEndpointToHead(&endpointAll[currentId], head)
 		currentId++
 	}
 	return head, currentId
 }
 
+// UpdateEndpointsByEndpoint asks endpoint for the endpoint list of the
+// servers and merges it into the collection. Returns true if the collection
+// is up to date.
 func (e *EndpointCollection) UpdateEndpointsByEndpoint(endpoint *SingleEndpoint,
 	forceUpdate bool) bool {
-	// get the information of rgws from endpoint
-	rgws, err := e.getRgwInfoFromServer(endpoint)
-	if err != nil {
-		return false
-	}
-
-	// check epoch
-	if !forceUpdate && rgws.epoch <= e.lastEpoch {
-		return true
-	}
-
-	head, rgwNum := e.ParseEndpointFromRgwInfo(rgws)
-	if head == nil || rgwNum == 0 {
-		return false
-	}
-
-	if err := e.UpdateWholeEndpoitCollection(head, rgwNum, rgws.epoch); err == nil {
-		return true
-	}
-
-	return false
+	return e.discover(apiDiscoverer{
+		e:       e,
+		servers: []*SingleEndpoint{endpoint},
+		force:   forceUpdate,
+	}, forceUpdate)
 }
 
+// UpdateEndpointByApi updates the collection from its discoverer, by
+// default the endpoint list API of the active endpoints. Returns true if the
+// collection is up to date.
 func (e *EndpointCollection) UpdateEndpointByApi() bool {
-	retry := e.numOfActiveEndpoint
-	endpoint := e.endpointHead
-
-	for endpoint != nil && retry >= 0 {
-		endpoint = endpoint.next
-		if endpoint.IsInBlackList || endpoint.Id < e.validMinEndpointId {
-			continue
-		}
-		retry--
-
-		if ok := e.UpdateEndpointsByEndpoint(endpoint, false); ok {
-			return true
-		}
-	}
-	return false
+	return e.discover(e.discoverer(e.activeEndpoints(), false), false)
 }
 
+// UpdateEndpointFromSeed updates the collection from its discoverer when
+// every endpoint is blacklisted, by default the endpoint list API of the
+// seeds. The blacklisted endpoints found are recovered.
 func (e *EndpointCollection) UpdateEndpointFromSeed() bool {
-	if e.endpointSeed == nil {
-		return false
-	}
-
-	for _, endpoint := range *e.endpointSeed {
-		if _, ok := e.blackList[endpoint.URL]; ok {
-			continue
-		}
+	e.mutex.Lock()
+	seeds := e.seedEndpoints()
+	e.unlock()
 
-		if ok := e.UpdateEndpointsByEndpoint(&endpoint, true); ok {
-			return true
-		}
-	}
-	return false
+	return e.discover(e.discoverer(seeds, true), true)
 }
 
 // 1. get endpoint list from server background
 // 2. probe the endpoint in blacklist
+// KeepAlive returns when the collection is closed.
 func (e *EndpointCollection) KeepAlive() {
 	var (
 		before time.Duration
@@ -594,11 +991,11 @@ func (e *EndpointCollection) KeepAlive() {
 	for {
 		// sleep
 		if before > 0 && !immediately {
-			select {
-			case <-e.notify:
-				immediately = true
-			case <-time.After(before * time.Second):
+			notified, closed := e.sleep(before*time.Second, true)
+			if closed {
+				return
 			}
+			immediately = notified
 		}
 
 		ok := e.UpdateEndpointByApi()
@@ -606,7 +1003,7 @@ func (e *EndpointCollection) KeepAlive() {
 			ok = e.probeBlacklist()
 		}
 
-		if e.numOfActiveEndpoint == 0 {
+		if len(e.activeEndpoints()) == 0 {
 			// all endpoints have been added into blacklist and are invalid
 			ok = e.UpdateEndpointFromSeed()
 			if !ok {
@@ -619,40 +1016,104 @@ func (e *EndpointCollection) KeepAlive() {
 		} else if immediately {
 			// failed to fetch endpoint list from server
 			// retry immediately
-			time.Sleep(1 * time.Second)
+			if _, closed := e.sleep(1*time.Second, false); closed {
+				return
+			}
 			continue
 		}
 
 		// sleep
 		if after > 0 && !immediately {
-			select {
-			case <-e.notify:
-				immediately = true
-			case <-time.After(after * time.Second):
+			notified, closed := e.sleep(after*time.Second, true)
+			if closed {
+				return
 			}
+			immediately = notified
 		}
 	}
 }
 
-// the endpoint collection
+// FindEndpointCollection returns the collection of endpointsPath, creating
+// it on first use. Every successful call takes a reference which must be
+// given back with ReleaseEndpointCollection. The options only apply when the
+// collection is created.
 func (g *GlobalEndpoints) FindEndpointCollection(endpointsPath string,
-	keepAliveInterval int) (*EndpointCollection, error) {
+	keepAliveInterval int, optFns ...func(*CollectionOptions)) (*EndpointCollection, error) {
+
+	return g.findOrCreate(endpointsPath, func() (*EndpointCollection, error) {
+		return NewEndpointCollection(endpointsPath, keepAliveInterval, optFns...)
+	})
+}
+
+// FindEndpointCollectionFromList is FindEndpointCollection for a list of
+// gateways, see NewEndpointCollectionFromList. The same list in the same
+// order shares one collection. The reference must be given back with
+// ReleaseEndpointCollectionFromList.
+func (g *GlobalEndpoints) FindEndpointCollectionFromList(gateways []string,
+	keepAliveInterval int, optFns ...func(*CollectionOptions)) (*EndpointCollection, error) {
+
+	return g.findOrCreate(gatewayListKey(gateways), func() (*EndpointCollection, error) {
+		return NewEndpointCollectionFromList(gateways, keepAliveInterval, optFns...)
+	})
+}
+
+// ReleaseEndpointCollectionFromList gives back a reference taken by
+// FindEndpointCollectionFromList. Returns true if the collection was closed.
+func (g *GlobalEndpoints) ReleaseEndpointCollectionFromList(gateways []string) bool {
+	return g.ReleaseEndpointCollection(gatewayListKey(gateways))
+}
+
+// the key of the collection of a list of gateways, it never collides with
+// a path as a path has no newline
+func gatewayListKey(gateways []string) string {
+	return "\n" + strings.Join(gateways, "\n")
+}
+
+func (g *GlobalEndpoints) findOrCreate(key string,
+	create func() (*EndpointCollection, error)) (*EndpointCollection, error) {
 
 	g.mutex.Lock()
 	defer g.mutex.Unlock()
-	endpoints, ok := g.endpointCollections[endpointsPath]
+	endpoints, ok := g.endpointCollections[key]
 	if ok {
+		g.references[key]++
 		return endpoints, nil
 	}
 
-	endpoints, err := NewEndpointCollection(endpointsPath, keepAliveInterval)
+	endpoints, err := create()
 	if err != nil {
 		return nil, err
 	}
-	g.endpointCollections[endpointsPath] = endpoints
+	g.endpointCollections[key] = endpoints
+	g.references[key] = 1
 	return endpoints, nil
 }
 
+// ReleaseEndpointCollection gives back a reference taken by
+// FindEndpointCollection. The collection is closed and evicted when its
+// last reference is released. Returns true if the collection was closed.
+func (g *GlobalEndpoints) ReleaseEndpointCollection(endpointsPath string) bool {
+	g.mutex.Lock()
+	endpoints, ok := g.endpointCollections[endpointsPath]
+	if !ok {
+		g.mutex.Unlock()
+		return false
+	}
+
+	g.references[endpointsPath]--
+	if g.references[endpointsPath] > 0 {
+		g.mutex.Unlock()
+		return false
+	}
+	delete(g.endpointCollections, endpointsPath)
+	delete(g.references, endpointsPath)
+	g.mutex.Unlock()
+
+	// close outside of the lock, it waits for the keep alive to return
+	endpoints.Close()
+	return true
+}
+
 func parseEndpointFromString(urlString string, endpoint *SingleEndpoint) error {
 	if !strings.Contains(urlString, "//") {
 		urlString = "//" + urlString
@@ -675,13 +1136,36 @@ func parseEndpointFromString(urlString string, endpoint *SingleEndpoint) error {
 	}
 
 	if endpoint.Port != "" {
-		endpoint.HostAndPort = endpoint.Host + ":" + endpoint.Port
+		endpoint.HostAndPort = net.JoinHostPort(endpoint.Host, endpoint.Port)
+	} else if strings.Contains(endpoint.Host, ":") {
+		// IPv6 address on the default port of the protocol
+		endpoint.HostAndPort = "[" + endpoint.Host + "]"
+	} else {
+		// the default port of the protocol
+		endpoint.HostAndPort = endpoint.Host
 	}
 	endpoint.URL = fmt.Sprintf("%s://%s", endpoint.Protocol, endpoint.HostAndPort)
 
 	return nil
 }
 
+// clone returns a copy of the address and settings of the endpoint, without
+// its position in the ring and its runtime state
+func (s *SingleEndpoint) clone() *SingleEndpoint {
+	return &SingleEndpoint{
+		Protocol:           s.Protocol,
+		Host:               s.Host,
+		Port:               s.Port,
+		HostAndPort:        s.HostAndPort,
+		URL:                s.URL,
+		Weight:             s.Weight,
+		Zone:               s.Zone,
+		MaxConnections:     s.MaxConnections,
+		TLSServerName:      s.TLSServerName,
+		MaxIdleConnections: s.MaxIdleConnections,
+	}
+}
+
 func insertEndpointToHead(endpoint *SingleEndpoint, head *SingleEndpoint) *SingleEndpoint {
 	if endpoint == nil {
 		return head
@@ -1,9 +1,15 @@
 package endpoints
 
 import (
+	"encoding/xml"
 	"fmt"
+	"net/http"
+	"net/http/httptest"
 	"os"
+	"runtime"
+	"strings"
 	"testing"
+	"time"
 )
 
 const (
@@ -344,8 +350,8 @@ func TestGetNext(t *testing.T) {
 	endpoint4 := endpoint3.next
 	endpoint5 := endpoint4.next
 
-	endpoint3.IsInBlackList = true
-	endpoint4.IsInBlackList = true
+	ec.AddEndpointToBlacklist(endpoint3)
+	ec.AddEndpointToBlacklist(endpoint4)
 
 	endpoint = ec.GetNextEndpoint(endpoint5)
 	if endpoint == nil {
@@ -425,6 +431,9 @@ func TestProbeEndpoint(t *testing.T) {
 		t.Fatalf("1 expect err == nil, got err != nil")
 	}
 
+	server := httptest.NewServer(http.NotFoundHandler())
+	defer server.Close()
+
 	cases := map[string]struct {
 		URL string
 		ret bool
@@ -445,7 +454,7 @@ func TestProbeEndpoint(t *testing.T) {
 			ret: false,
 		},
 		"success": {
-			URL: "http://10.130.48.88:8080", // rgw should listen on port 8080
+			URL: server.URL,
 			ret: true,
 		},
 	}
@@ -457,3 +466,229 @@ func TestProbeEndpoint(t *testing.T) {
 		}
 	}
 }
+
+// count the goroutines running the keep alive of a collection
+func numKeepAliveGoroutines() int {
+	buf := make([]byte, 1<<20)
+	buf = buf[:runtime.Stack(buf, true)]
+	return strings.Count(string(buf), "github.com/aws/aws-sdk-go/aws/endpoints.(*EndpointCollection).KeepAlive(")
+}
+
+func waitKeepAliveGoroutines(expect int) int {
+	n := numKeepAliveGoroutines()
+	for i := 0; i < 100 && n != expect; i++ {
+		time.Sleep(10 * time.Millisecond)
+		n = numKeepAliveGoroutines()
+	}
+	return n
+}
+
+func TestCloseEndpointCollection(t *testing.T) {
+	before := numKeepAliveGoroutines()
+
+	ec, err := NewEndpointCollection(TEST_ENDPOINT_PATH, 1)
+	if err != nil {
+		t.Fatalf("1 expect nil, got err %v", err)
+	}
+	if e, a := before+1, waitKeepAliveGoroutines(before+1); e != a {
+		t.Fatalf("2 expect %d keep alive goroutines, got %d", e, a)
+	}
+
+	if err := ec.Close(); err != nil {
+		t.Fatalf("3 expect nil, got err %v", err)
+	}
+	if e, a := before, waitKeepAliveGoroutines(before); e != a {
+		t.Errorf("4 expect %d keep alive goroutines, got %d", e, a)
+	}
+
+	// close twice
+	if err := ec.Close(); err != nil {
+		t.Errorf("5 expect nil, got err %v", err)
+	}
+
+	// blacklisting every endpoint must not block once closed
+	for ec.SelectEndpoint(nil) != nil {
+		ec.AddEndpointToBlacklist(ec.SelectEndpoint(nil))
+	}
+
+	if ok := ec.probeEndpoint("http://127.0.0.1:808"); ok {
+		t.Errorf("6 expect probe of closed collection to fail")
+	}
+
+	// zero value collection
+	empty := &EndpointCollection{}
+	if err := empty.Close(); err != nil {
+		t.Errorf("7 expect nil, got err %v", err)
+	}
+}
+
+func TestReleaseEndpointCollection(t *testing.T) {
+	const path = TEST_ENDPOINT_PATH + "_release"
+	if err := os.Link(TEST_ENDPOINT_PATH, path); err != nil {
+		t.Fatalf("1 expect nil, got err %v", err)
+	}
+	defer os.Remove(path)
+
+	before := numKeepAliveGoroutines()
+
+	ec, err := GEndpoints.FindEndpointCollection(path, 1)
+	if err != nil {
+		t.Fatalf("2 expect nil, got err %v", err)
+	}
+	ec1, err := GEndpoints.FindEndpointCollection(path, 1)
+	if err != nil {
+		t.Fatalf("3 expect nil, got err %v", err)
+	}
+	if ec != ec1 {
+		t.Fatalf("4 expect same collection")
+	}
+
+	if ok := GEndpoints.ReleaseEndpointCollection(path); ok {
+		t.Errorf("5 expect collection still referenced")
+	}
+	if e, a := before+1, waitKeepAliveGoroutines(before+1); e != a {
+		t.Errorf("6 expect %d keep alive goroutines, got %d", e, a)
+	}
+
+	if ok := GEndpoints.ReleaseEndpointCollection(path); !ok {
+		t.Errorf("7 expect collection closed")
+	}
+	if e, a := before, waitKeepAliveGoroutines(before); e != a {
+		t.Errorf("8 expect %d keep alive goroutines, got %d", e, a)
+	}
+
+	if ok := GEndpoints.ReleaseEndpointCollection(path); ok {
+		t.Errorf("9 expect unknown collection not closed")
+	}
+
+	ec2, err := GEndpoints.FindEndpointCollection(path, 1)
+	if err != nil {
+		t.Fatalf("10 expect nil, got err %v", err)
+	}
+	defer GEndpoints.ReleaseEndpointCollection(path)
+	if ec2 == ec {
+		t.Errorf("11 expect a new collection after release")
+	}
+}
+
+func TestParseEndpointFromRgwInfoZone(t *testing.T) {
+	rgws := &RgwInfo{}
+	err := xml.Unmarshal([]byte(`<RgwInfo>
+		<Rgw><Ip>10.0.0.1</Ip><Port>8080</Port><Zone>dc1</Zone></Rgw>
+		<Rgw><Ip>10.0.0.2</Ip><Port>8080</Port></Rgw>
+	</RgwInfo>`), rgws)
+	if err != nil {
+		t.Fatalf("1 expect nil, got err %v", err)
+	}
+
+	ec := &EndpointCollection{}
+	head, n := ec.ParseEndpointFromRgwInfo(rgws)
+	if e, a := 2, n; e != a {
+		t.Fatalf("2 expect %d endpoints, got %d", e, a)
+	}
+	if e, a := "dc1", head.Zone; e != a {
+		t.Errorf("3 expect zone %s, got %s", e, a)
+	}
+	if e, a := "", head.next.Zone; e != a {
+		t.Errorf("4 expect zone %q, got %q", e, a)
+	}
+}
+
+// newListCollection returns the collection of gateways, with its options set
+// by optFn unless nil
+func newListCollection(t *testing.T, optFn func(*CollectionOptions),
+	gateways ...string) *EndpointCollection {
+
+	var optFns []func(*CollectionOptions)
+	if optFn != nil {
+		optFns = append(optFns, optFn)
+	}
+	ec, err := NewEndpointCollectionFromList(gateways, 100, optFns...)
+	if err != nil {
+		t.Fatalf("expect no error, got %v", err)
+	}
+	return ec
+}
+
+func TestNewEndpointCollectionFromList(t *testing.T) {
+	ec, err := NewEndpointCollectionFromList([]string{
+		"http://abc1.test:8080 zone=z1",
+		"h",
+		"http://abc2.test:8080",
+		"http://abc3.test:8080 disabled=true",
+	}, 100)
+	if err != nil {
+		t.Fatalf("1 expect nil, got err %v", err)
+	}
+	defer ec.Close()
+
+	if e, a := "http://abc1.test:8080,http://abc2.test:8080", activeURLs(ec); e != a {
+		t.Errorf("2 expect active %s, got %s", e, a)
+	}
+	if e, a := 2, len(*ec.endpointSeed); e != a {
+		t.Errorf("3 expect %d seeds, got %d", e, a)
+	}
+	errs := ec.ParseErrors()
+	if e, a := 1, len(errs); e != a {
+		t.Fatalf("4 expect %d parse error, got %d", e, a)
+	}
+	if e, a := "gateway 2:", errs[0].Error(); !strings.HasPrefix(a, e) {
+		t.Errorf("5 expect error to start with %q, got %q", e, a)
+	}
+
+	// the list behaves like a file once every endpoint is blacklisted
+	for ec.SelectEndpoint(nil) != nil {
+		ec.AddEndpointToBlacklist(ec.SelectEndpoint(nil))
+	}
+	if e, a := 2, len(ec.blackList); e != a {
+		t.Errorf("6 expect %d blacklisted endpoints, got %d", e, a)
+	}
+
+	cases := map[string][]string{
+		"empty":    nil,
+		"invalid":  {"h"},
+		"disabled": {"http://abc1.test:8080 disabled=true"},
+	}
+	for name, gateways := range cases {
+		if _, err := NewEndpointCollectionFromList(gateways, 100); err == nil {
+			t.Errorf("%s expect error", name)
+		}
+	}
+	if _, err := NewEndpointCollectionFromList([]string{"http://abc1.test:8080"}, -1); err == nil {
+		t.Errorf("7 expect negative keep alive interval to fail")
+	}
+}
+
+func TestFindEndpointCollectionFromList(t *testing.T) {
+	gateways := []string{"http://abc1.test:8080", "http://abc2.test:8080"}
+
+	ec, err := GEndpoints.FindEndpointCollectionFromList(gateways, 100)
+	if err != nil {
+		t.Fatalf("1 expect nil, got err %v", err)
+	}
+	ec1, err := GEndpoints.FindEndpointCollectionFromList(gateways, 100)
+	if err != nil {
+		t.Fatalf("2 expect nil, got err %v", err)
+	}
+	if ec != ec1 {
+		t.Errorf("3 expect same collection")
+	}
+
+	other, err := GEndpoints.FindEndpointCollectionFromList(gateways[:1], 100)
+	if err != nil {
+		t.Fatalf("4 expect nil, got err %v", err)
+	}
+	if other == ec {
+		t.Errorf("5 expect another collection for another list")
+	}
+	if ok := GEndpoints.ReleaseEndpointCollectionFromList(gateways[:1]); !ok {
+		t.Errorf("6 expect collection closed")
+	}
+
+	if ok := GEndpoints.ReleaseEndpointCollectionFromList(gateways); ok {
+		t.Errorf("7 expect collection still referenced")
+	}
+	if ok := GEndpoints.ReleaseEndpointCollectionFromList(gateways); !ok {
+		t.Errorf("8 expect collection closed")
+	}
+}
@@ -0,0 +1,363 @@
+// Copyright 2020 Baidu, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License"); you may not use this file
+// except in compliance with the License. You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software distributed under the
+// License is distributed on an "AS IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND,
+// either express or implied. See the License for the specific language governing permissions
+// and limitations under the License.
+
+package endpoints
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// GatewayDiscoverer finds the gateways of a collection. When a collection
+// has a discoverer, its keep alive asks the discoverer for the gateways
+// instead of asking the server, and merges the result into the collection.
+type GatewayDiscoverer interface {
+	// Discover returns the gateways currently known. The endpoints
+	// returned must not be shared with another caller.
+	Discover(ctx context.Context) ([]*SingleEndpoint, error)
+}
+
+// GatewayDiscovererFunc is a function implementing GatewayDiscoverer.
+type GatewayDiscovererFunc func(ctx context.Context) ([]*SingleEndpoint, error)
+
+// Discover calls f(ctx).
+func (f GatewayDiscovererFunc) Discover(ctx context.Context) ([]*SingleEndpoint, error) {
+	return f(ctx)
+}
+
+// DNSResolver looks up DNS records. *net.Resolver implements DNSResolver.
+type DNSResolver interface {
+	LookupSRV(ctx context.Context, service, proto, name string) (string, []*net.SRV, error)
+	LookupHost(ctx context.Context, host string) ([]string, error)
+}
+
+func resolverOrDefault(r DNSResolver) DNSResolver {
+	if r == nil {
+		return net.DefaultResolver
+	}
+	return r
+}
+
+// StaticDiscoverer returns a fixed list of gateways, given in the format of
+// a line of an endpoints file: a URL followed by optional attributes, such
+// as "http://10.0.0.1:8080 weight=2 zone=dc1".
+type StaticDiscoverer []string
+
+// Discover parses the gateways of the list. Fails if any of them is
+// invalid.
+func (d StaticDiscoverer) Discover(ctx context.Context) ([]*SingleEndpoint, error) {
+	endpoints := make([]*SingleEndpoint, 0, len(d))
+	for _, line := range d {
+		endpoint := &SingleEndpoint{}
+		disabled, err := parseEndpointLine(line, endpoint)
+		if err != nil {
+			return nil, fmt.Errorf("invalid gateway %q, %v", line, err)
+		}
+		if !disabled {
+			endpoints = append(endpoints, endpoint)
+		}
+	}
+	if len(endpoints) == 0 {
+		return nil, errors.New("no gateway")
+	}
+	return endpoints, nil
+}
+
+// FileDiscoverer reads the gateways from an endpoints file. Invalid entries
+// of the file are skipped.
+type FileDiscoverer struct {
+	Path string
+}
+
+// Discover reads the file.
+func (d FileDiscoverer) Discover(ctx context.Context) ([]*SingleEndpoint, error) {
+	endpointAll, parseErrors, err := readEndpointsFile(d.Path)
+	if err != nil {
+		return nil, err
+	}
+	if len(endpointAll) == 0 {
+		if len(parseErrors) > 0 {
+			return nil, fmt.Errorf("no valid endpoint in %s, %v", d.Path, parseErrors[0])
+		}
+		return nil, fmt.Errorf("no endpoint in %s", d.Path)
+	}
+
+	endpoints := make([]*SingleEndpoint, 0, len(endpointAll))
+	for i := range endpointAll {
+		endpoints = append(endpoints, endpointAll[i].clone())
+	}
+	return endpoints, nil
+}
+
+// RgwDiscoverer gets the gateways from the endpoint list API of the servers
+// of URLs, the first server answering wins.
+type RgwDiscoverer struct {
+	URLs []string
+
+	// The client sending the requests. Defaults to http.DefaultClient.
+	Client *http.Client
+}
+
+// Discover asks the servers for the endpoint list.
+func (d RgwDiscoverer) Discover(ctx context.Context) ([]*SingleEndpoint, error) {
+	client := d.Client
+	if client == nil {
+		client = http.DefaultClient
+	}
+
+	_, endpoints, err := fetchEndpointList(ctx, d.URLs,
+		func(string) *http.Client { return client }, 0)
+	return endpoints, err
+}
+
+// fetchEndpointList asks the servers of URLs for the endpoint list in turn,
+// the first one answering with a valid endpoint wins. Each server is given
+// timeout to answer, zero means no timeout.
+func fetchEndpointList(ctx context.Context, URLs []string, client func(URL string) *http.Client,
+	timeout time.Duration) (*RgwInfo, []*SingleEndpoint, error) {
+
+	err := errors.New("no server")
+	for _, URL := range URLs {
+		fetchCtx, cancel := ctx, context.CancelFunc(func() {})
+		if timeout > 0 {
+			fetchCtx, cancel = context.WithTimeout(ctx, timeout)
+		}
+		var rgws *RgwInfo
+		rgws, err = fetchRgwInfo(fetchCtx, client(URL), URL)
+		cancel()
+		if err != nil {
+			continue
+		}
+		if endpoints := endpointsFromRgwInfo(rgws); len(endpoints) > 0 {
+			return rgws, endpoints, nil
+		}
+		err = fmt.Errorf("no valid endpoint from %s", URL)
+	}
+	return nil, nil, err
+}
+
+// DNSDiscoverer resolves a hostname with one address record per gateway.
+type DNSDiscoverer struct {
+	// The hostname to resolve.
+	Host string
+
+	// The port of the gateways. Empty uses the default port of Protocol.
+	Port string
+
+	// The scheme of the gateways, "http" or "https". Defaults to "http".
+	Protocol string
+
+	// Defaults to net.DefaultResolver.
+	Resolver DNSResolver
+}
+
+// Discover resolves the hostname.
+func (d DNSDiscoverer) Discover(ctx context.Context) ([]*SingleEndpoint, error) {
+	addrs, err := resolverOrDefault(d.Resolver).LookupHost(ctx, d.Host)
+	if err != nil {
+		return nil, err
+	}
+
+	endpoints := make([]*SingleEndpoint, 0, len(addrs))
+	for _, addr := range addrs {
+		endpoint, err := newDiscoveredEndpoint(d.Protocol, addr, d.Port,
+			strings.TrimSuffix(d.Host, "."))
+		if err != nil {
+			continue
+		}
+		endpoints = append(endpoints, endpoint)
+	}
+	if len(endpoints) == 0 {
+		return nil, fmt.Errorf("no address for %s", d.Host)
+	}
+	return endpoints, nil
+}
+
+// DNSSRVDiscoverer resolves the SRV records of a service, one per gateway.
+// The weight of a record is the Weight of its gateway.
+type DNSSRVDiscoverer struct {
+	// The records looked up are _Service._Proto.Name. If Service and Proto
+	// are empty Name is looked up directly.
+	Service string
+	Proto   string
+	Name    string
+
+	// The scheme of the gateways, "http" or "https". Defaults to "http".
+	Protocol string
+
+	// Defaults to net.DefaultResolver.
+	Resolver DNSResolver
+}
+
+// Discover resolves the SRV records.
+func (d DNSSRVDiscoverer) Discover(ctx context.Context) ([]*SingleEndpoint, error) {
+	_, records, err := resolverOrDefault(d.Resolver).LookupSRV(ctx, d.Service, d.Proto, d.Name)
+	if err != nil {
+		return nil, err
+	}
+
+	endpoints := make([]*SingleEndpoint, 0, len(records))
+	for _, record := range records {
+		target := strings.TrimSuffix(record.Target, ".")
+		if target == "" {
+			continue
+		}
+		endpoint, err := newDiscoveredEndpoint(d.Protocol, target,
+			strconv.Itoa(int(record.Port)), target)
+		if err != nil {
+			continue
+		}
+		endpoint.Weight = int(record.Weight)
+		endpoints = append(endpoints, endpoint)
+	}
+	if len(endpoints) == 0 {
+		return nil, fmt.Errorf("no SRV record for %s", d.Name)
+	}
+	return endpoints, nil
+}
+
+// newDiscoveredEndpoint returns the endpoint of host. serverName is the
+// name verified by TLS when host is an address.
+func newDiscoveredEndpoint(protocol, host, port, serverName string) (*SingleEndpoint, error) {
+	if protocol == "" {
+		protocol = "http"
+	}
+	hostAndPort := host
+	if port != "" {
+		hostAndPort = net.JoinHostPort(host, port)
+	} else if strings.Contains(host, ":") {
+		// IPv6 address
+		hostAndPort = "[" + host + "]"
+	}
+
+	endpoint := &SingleEndpoint{}
+	if err := parseEndpointURL(protocol+"://"+hostAndPort, endpoint); err != nil {
+		return nil, err
+	}
+	if protocol == "https" && serverName != host {
+		endpoint.TLSServerName = serverName
+	}
+	return endpoint, nil
+}
+
+// endpointsFromRgwInfo returns the endpoints of the endpoint list, skipping
+// the invalid ones
+func endpointsFromRgwInfo(rgws *RgwInfo) []*SingleEndpoint {
+	endpoints := make([]*SingleEndpoint, 0, len(rgws.RgwConfiguration))
+	for _, rgw := range rgws.RgwConfiguration {
+		if rgw == nil || rgw.Ip == "" {
+			continue
+		}
+		endpoint := &SingleEndpoint{}
+		if err := parseEndpointFromString(rgw.Ip+":"+rgw.Port, endpoint); err != nil {
+			continue
+		}
+		endpoint.Zone = rgw.Zone
+		endpoints = append(endpoints, endpoint)
+	}
+	return endpoints
+}
+
+// errEndpointListUpToDate is returned by an apiDiscoverer when the endpoint
+// list of the servers is not newer than the one of the collection
+var errEndpointListUpToDate = errors.New("endpoint list up to date")
+
+// apiDiscoverer is the discoverer of a collection without
+// CollectionOptions.Discoverer. It asks the servers for their endpoint list,
+// and takes it if its epoch is newer than the last one taken, or if force
+// is set.
+type apiDiscoverer struct {
+	e       *EndpointCollection
+	servers []*SingleEndpoint
+	force   bool
+}
+
+// Discover asks the servers for the endpoint list, each one within the probe
+// timeout of the collection.
+func (d apiDiscoverer) Discover(ctx context.Context) ([]*SingleEndpoint, error) {
+	URLs := make([]string, 0, len(d.servers))
+	servers := make(map[string]*SingleEndpoint, len(d.servers))
+	for _, server := range d.servers {
+		URLs = append(URLs, server.URL)
+		servers[server.URL] = server
+	}
+
+	rgws, endpoints, err := fetchEndpointList(ctx, URLs, func(URL string) *http.Client {
+		return d.e.probeClient(servers[URL])
+	}, d.e.probeTimeout())
+	if err != nil {
+		return nil, err
+	}
+
+	d.e.mutex.Lock()
+	upToDate := !d.force && rgws.epoch <= d.e.lastEpoch
+	if !upToDate {
+		d.e.updateEpoch(rgws.epoch)
+	}
+	d.e.unlock()
+	if upToDate {
+		return nil, errEndpointListUpToDate
+	}
+
+	d.e.saveCache(rgws)
+	return endpoints, nil
+}
+
+// discoverer returns CollectionOptions.Discoverer, bounded by the probe
+// timeout, or an apiDiscoverer asking servers if it is not set.
+func (e *EndpointCollection) discoverer(servers []*SingleEndpoint, force bool) GatewayDiscoverer {
+	discoverer := e.options.Discoverer
+	if discoverer == nil {
+		return apiDiscoverer{e: e, servers: servers, force: force}
+	}
+	return GatewayDiscovererFunc(func(ctx context.Context) ([]*SingleEndpoint, error) {
+		ctx, cancel := context.WithTimeout(ctx, e.probeTimeout())
+		defer cancel()
+		return discoverer.Discover(ctx)
+	})
+}
+
+// discover merges the gateways found by discoverer into the collection.
+// force also recovers the blacklisted gateways found, as done when every
+// endpoint is blacklisted. Returns true if the discovery succeeded, whether
+// the endpoints changed or not.
+func (e *EndpointCollection) discover(discoverer GatewayDiscoverer, force bool) bool {
+	endpoints, err := discoverer.Discover(e.context())
+	if err == errEndpointListUpToDate {
+		return true
+	}
+	if err != nil || len(endpoints) == 0 {
+		return false
+	}
+
+	e.mutex.Lock()
+	defer e.unlock()
+	e.mergeEndpoints(endpoints)
+	if force {
+		recovered := false
+		for _, endpoint := range endpoints {
+			if e.rmEndpointFromBlacklist(endpoint.URL) {
+				recovered = true
+			}
+		}
+		if recovered {
+			e.publish()
+		}
+	}
+	return true
+}
@@ -0,0 +1,452 @@
+package endpoints
+
+import (
+	"context"
+	"encoding/binary"
+	"errors"
+	"net"
+	"strings"
+	"sync"
+	"testing"
+)
+
+// dnsStub answers DNS queries over a loopback UDP socket, so the
+// discoverers are exercised through the pure Go resolver of the net package.
+type dnsStub struct {
+	conn  net.PacketConn
+	srv   map[string][]*net.SRV
+	hosts map[string][]string
+}
+
+const (
+	dnsTypeA    = 1
+	dnsTypeAAAA = 28
+	dnsTypeSRV  = 33
+)
+
+func newDNSStub(t *testing.T, srv map[string][]*net.SRV, hosts map[string][]string) *dnsStub {
+	conn, err := net.ListenPacket("udp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatalf("listen dns stub failed, %v", err)
+	}
+	stub := &dnsStub{conn: conn, srv: map[string][]*net.SRV{}, hosts: map[string][]string{}}
+	for name, records := range srv {
+		stub.srv[strings.TrimSuffix(name, ".")+"."] = records
+	}
+	for name, addrs := range hosts {
+		stub.hosts[strings.TrimSuffix(name, ".")+"."] = addrs
+	}
+	go stub.serve()
+	return stub
+}
+
+// resolver dials the stub whatever name server is configured
+func (s *dnsStub) resolver() *net.Resolver {
+	return &net.Resolver{
+		PreferGo: true,
+		Dial: func(ctx context.Context, network, address string) (net.Conn, error) {
+			var d net.Dialer
+			return d.DialContext(ctx, "udp", s.conn.LocalAddr().String())
+		},
+	}
+}
+
+func (s *dnsStub) Close() {
+	s.conn.Close()
+}
+
+func (s *dnsStub) serve() {
+	buf := make([]byte, 1500)
+	for {
+		n, addr, err := s.conn.ReadFrom(buf)
+		if err != nil {
+			return
+		}
+		if resp := s.answer(buf[:n]); resp != nil {
+			s.conn.WriteTo(resp, addr)
+		}
+	}
+}
+
+// answer builds the response of a single question query
+func (s *dnsStub) answer(query []byte) []byte {
+	if len(query) < 12 {
+		return nil
+	}
+	labels, offset, ok := readDNSName(query, 12)
+	if !ok || len(query) < offset+4 {
+		return nil
+	}
+	name := strings.ToLower(strings.Join(labels, ".") + ".")
+	qtype := binary.BigEndian.Uint16(query[offset:])
+
+	var answers [][]byte
+	_, knownSRV := s.srv[name]
+	addrs, knownHost := s.hosts[name]
+	switch qtype {
+	case dnsTypeSRV:
+		for _, record := range s.srv[name] {
+			rdata := make([]byte, 6)
+			binary.BigEndian.PutUint16(rdata[0:], record.Priority)
+			binary.BigEndian.PutUint16(rdata[2:], record.Weight)
+			binary.BigEndian.PutUint16(rdata[4:], record.Port)
+			answers = append(answers, dnsRecord(qtype, append(rdata, writeDNSName(record.Target)...)))
+		}
+	case dnsTypeA, dnsTypeAAAA:
+		for _, addr := range addrs {
+			ip := net.ParseIP(addr)
+			if ip4 := ip.To4(); ip4 != nil && qtype == dnsTypeA {
+				answers = append(answers, dnsRecord(qtype, ip4))
+			} else if ip4 == nil && qtype == dnsTypeAAAA {
+				answers = append(answers, dnsRecord(qtype, ip.To16()))
+			}
+		}
+	}
+
+	header := make([]byte, 12)
+	copy(header, query[:2])
+	// response, authoritative, recursion desired as asked and available
+	flags := uint16(0x8400) | uint16(query[2]&0x01)<<8 | 0x0080
+	if !knownSRV && !knownHost {
+		flags |= 3 // name error
+	}
+	binary.BigEndian.PutUint16(header[2:], flags)
+	binary.BigEndian.PutUint16(header[4:], 1)
+	binary.BigEndian.PutUint16(header[6:], uint16(len(answers)))
+
+	resp := append(header, query[12:offset+4]...)
+	for _, answer := range answers {
+		resp = append(resp, answer...)
+	}
+	return resp
+}
+
+// dnsRecord points its owner name at the question
+func dnsRecord(qtype uint16, rdata []byte) []byte {
+	record := []byte{0xc0, 12, 0, 0, 0, 1, 0, 0, 0, 60, 0, 0}
+	binary.BigEndian.PutUint16(record[2:], qtype)
+	binary.BigEndian.PutUint16(record[10:], uint16(len(rdata)))
+	return append(record, rdata...)
+}
+
+func readDNSName(msg []byte, offset int) ([]string, int, bool) {
+	var labels []string
+	for offset < len(msg) {
+		length := int(msg[offset])
+		offset++
+		if length == 0 {
+			return labels, offset, true
+		}
+		if length > 63 || offset+length > len(msg) {
+			return nil, 0, false
+		}
+		labels = append(labels, string(msg[offset:offset+length]))
+		offset += length
+	}
+	return nil, 0, false
+}
+
+func writeDNSName(name string) []byte {
+	var b []byte
+	for _, label := range strings.Split(strings.TrimSuffix(name, "."), ".") {
+		if label == "" {
+			continue
+		}
+		b = append(b, byte(len(label)))
+		b = append(b, label...)
+	}
+	return append(b, 0)
+}
+
+func discoveredURLs(endpoints []*SingleEndpoint) string {
+	var URLs []string
+	for _, endpoint := range endpoints {
+		URLs = append(URLs, endpoint.URL)
+	}
+	return strings.Join(URLs, ",")
+}
+
+func TestStaticDiscoverer(t *testing.T) {
+	endpoints, err := StaticDiscoverer{
+		"http://10.0.0.1:8080 weight=2 zone=z1",
+		"10.0.0.2:8080",
+		"http://10.0.0.3:8080 disabled=true",
+	}.Discover(context.Background())
+	if err != nil {
+		t.Fatalf("1 expect nil, got err %v", err)
+	}
+	if e, a := "http://10.0.0.1:8080,http://10.0.0.2:8080", discoveredURLs(endpoints); e != a {
+		t.Errorf("2 expect %s, got %s", e, a)
+	}
+	if e, a := 2, endpoints[0].Weight; e != a {
+		t.Errorf("3 expect weight %d, got %d", e, a)
+	}
+	if e, a := "z1", endpoints[0].Zone; e != a {
+		t.Errorf("4 expect zone %s, got %s", e, a)
+	}
+
+	if _, err := (StaticDiscoverer{"http://10.0.0.1:8080 weight=x"}).Discover(context.Background()); err == nil {
+		t.Errorf("5 expect invalid gateway to fail")
+	}
+	if _, err := (StaticDiscoverer{}).Discover(context.Background()); err == nil {
+		t.Errorf("6 expect empty list to fail")
+	}
+}
+
+func TestFileDiscoverer(t *testing.T) {
+	path, cleanup := writeEndpointsFile(t,
+		"http://abc1.test:8080 zone=z1",
+		"h",
+		"http://abc2.test:8080",
+	)
+	defer cleanup()
+
+	endpoints, err := FileDiscoverer{Path: path}.Discover(context.Background())
+	if err != nil {
+		t.Fatalf("1 expect nil, got err %v", err)
+	}
+	if e, a := "http://abc1.test:8080,http://abc2.test:8080", discoveredURLs(endpoints); e != a {
+		t.Errorf("2 expect %s, got %s", e, a)
+	}
+
+	if _, err := (FileDiscoverer{Path: path + "_dont_exist"}).Discover(context.Background()); err == nil {
+		t.Errorf("3 expect missing file to fail")
+	}
+}
+
+func TestRgwDiscoverer(t *testing.T) {
+	server, endpoint := newRgwServer(t, 1)
+	defer server.Close()
+
+	// port 808 should not listen
+	endpoints, err := RgwDiscoverer{
+		URLs: []string{"http://127.0.0.1:808", server.URL},
+	}.Discover(context.Background())
+	if err != nil {
+		t.Fatalf("1 expect nil, got err %v", err)
+	}
+	if e, a := endpoint.URL, discoveredURLs(endpoints); e != a {
+		t.Errorf("2 expect %s, got %s", e, a)
+	}
+
+	if _, err := (RgwDiscoverer{URLs: []string{"http://127.0.0.1:808"}}).Discover(context.Background()); err == nil {
+		t.Errorf("3 expect unreachable server to fail")
+	}
+}
+
+func TestDNSDiscoverer(t *testing.T) {
+	stub := newDNSStub(t, nil, map[string][]string{
+		"s3.example.com":    {"10.0.0.1", "10.0.0.2"},
+		"s3v6.example.com.": {"fd00::1"},
+	})
+	defer stub.Close()
+	resolver := stub.resolver()
+
+	cases := map[string]struct {
+		discoverer    DNSDiscoverer
+		URLs          string
+		tlsServerName string
+		err           bool
+	}{
+		"http": {
+			discoverer: DNSDiscoverer{Host: "s3.example.com", Port: "8080", Resolver: resolver},
+			URLs:       "http://10.0.0.1:8080,http://10.0.0.2:8080",
+		},
+		"https default port": {
+			discoverer:    DNSDiscoverer{Host: "s3.example.com", Protocol: "https", Resolver: resolver},
+			URLs:          "https://10.0.0.1,https://10.0.0.2",
+			tlsServerName: "s3.example.com",
+		},
+		"trailing dot": {
+			discoverer:    DNSDiscoverer{Host: "s3.example.com.", Protocol: "https", Port: "8443", Resolver: resolver},
+			URLs:          "https://10.0.0.1:8443,https://10.0.0.2:8443",
+			tlsServerName: "s3.example.com",
+		},
+		"ipv6": {
+			discoverer: DNSDiscoverer{Host: "s3v6.example.com", Port: "8080", Resolver: resolver},
+			URLs:       "http://[fd00::1]:8080",
+		},
+		"ipv6 default port": {
+			discoverer: DNSDiscoverer{Host: "s3v6.example.com", Resolver: resolver},
+			URLs:       "http://[fd00::1]",
+		},
+		"unknown host": {
+			discoverer: DNSDiscoverer{Host: "unknown.example.com", Resolver: resolver},
+			err:        true,
+		},
+	}
+
+	for name, c := range cases {
+		endpoints, err := c.discoverer.Discover(context.Background())
+		if c.err {
+			if err == nil {
+				t.Errorf("%s expect error", name)
+			}
+			continue
+		}
+		if err != nil {
+			t.Errorf("%s expect nil, got err %v", name, err)
+			continue
+		}
+		if e, a := c.URLs, discoveredURLs(endpoints); e != a {
+			t.Errorf("%s expect %s, got %s", name, e, a)
+		}
+		if e, a := c.tlsServerName, endpoints[0].TLSServerName; e != a {
+			t.Errorf("%s expect server name %q, got %q", name, e, a)
+		}
+	}
+}
+
+func TestDNSSRVDiscoverer(t *testing.T) {
+	// the records are served out of priority order
+	stub := newDNSStub(t, map[string][]*net.SRV{
+		"_s3._tcp.example.com": {
+			{Target: "gw2.example.com.", Port: 8081, Priority: 20, Weight: 20},
+			{Target: ".", Port: 0, Priority: 30},
+			{Target: "gw1.example.com.", Port: 8080, Priority: 10, Weight: 10},
+		},
+	}, nil)
+	defer stub.Close()
+	resolver := stub.resolver()
+
+	endpoints, err := DNSSRVDiscoverer{
+		Service:  "s3",
+		Proto:    "tcp",
+		Name:     "example.com",
+		Resolver: resolver,
+	}.Discover(context.Background())
+	if err != nil {
+		t.Fatalf("1 expect nil, got err %v", err)
+	}
+	if e, a := "http://gw1.example.com:8080,http://gw2.example.com:8081", discoveredURLs(endpoints); e != a {
+		t.Errorf("2 expect %s, got %s", e, a)
+	}
+	if e, a := 20, endpoints[1].Weight; e != a {
+		t.Errorf("3 expect weight %d, got %d", e, a)
+	}
+	if e, a := "", endpoints[0].TLSServerName; e != a {
+		t.Errorf("4 expect no server name, got %q", a)
+	}
+
+	_, err = DNSSRVDiscoverer{Name: "example.org", Resolver: resolver}.Discover(context.Background())
+	if err == nil {
+		t.Errorf("5 expect missing records to fail")
+	}
+}
+
+func TestUpdateEndpointByDiscoverer(t *testing.T) {
+	var mutex sync.Mutex
+	var gateways StaticDiscoverer
+	var discoverErr error
+	discoverer := GatewayDiscovererFunc(func(ctx context.Context) ([]*SingleEndpoint, error) {
+		mutex.Lock()
+		defer mutex.Unlock()
+		if discoverErr != nil {
+			return nil, discoverErr
+		}
+		return gateways.Discover(ctx)
+	})
+	setGateways := func(err error, lines ...string) {
+		mutex.Lock()
+		defer mutex.Unlock()
+		gateways, discoverErr = lines, err
+	}
+
+	path, cleanup := writeEndpointsFile(t, "http://abc1.test:8080", "http://abc2.test:8080")
+	defer cleanup()
+	ec := newSeedCollection(t, path)
+	ec.options.Discoverer = discoverer
+
+	setGateways(nil, "http://abc2.test:8080", "http://abc3.test:8080")
+	kept := findActive(ec, "http://abc2.test:8080")
+	if !ec.UpdateEndpointByApi() {
+		t.Fatalf("1 expect endpoints changed")
+	}
+	if e, a := "http://abc2.test:8080,http://abc3.test:8080", activeURLs(ec); e != a {
+		t.Errorf("2 expect active %s, got %s", e, a)
+	}
+	if findActive(ec, kept.URL) != kept {
+		t.Errorf("3 expect kept endpoint not to be replaced")
+	}
+	// an unchanged list is up to date, not a failure
+	if !ec.UpdateEndpointByApi() {
+		t.Errorf("4 expect endpoints up to date")
+	}
+	if e, a := "http://abc2.test:8080,http://abc3.test:8080", activeURLs(ec); e != a {
+		t.Errorf("4 expect active %s, got %s", e, a)
+	}
+
+	// the discoverer owns the active endpoints, the seeds only change
+	rewriteEndpointsFile(t, path, "http://abc4.test:8080")
+	if !ec.reloadSeed() {
+		t.Fatalf("5 expect seeds reloaded")
+	}
+	if e, a := "http://abc2.test:8080,http://abc3.test:8080", activeURLs(ec); e != a {
+		t.Errorf("6 expect active %s, got %s", e, a)
+	}
+
+	// a failed discovery keeps the endpoints
+	setGateways(errors.New("dns failure"))
+	if ec.UpdateEndpointByApi() {
+		t.Errorf("7 expect failed discovery")
+	}
+	if e, a := "http://abc2.test:8080,http://abc3.test:8080", activeURLs(ec); e != a {
+		t.Errorf("8 expect active %s, got %s", e, a)
+	}
+
+	// once every endpoint is blacklisted the discoverer is asked again
+	for ec.SelectEndpoint(nil) != nil {
+		ec.AddEndpointToBlacklist(ec.SelectEndpoint(nil))
+	}
+	setGateways(nil, "http://abc5.test:8080")
+	if !ec.UpdateEndpointFromSeed() {
+		t.Fatalf("9 expect endpoints changed")
+	}
+	if e, a := "http://abc5.test:8080", activeURLs(ec); e != a {
+		t.Errorf("10 expect active %s, got %s", e, a)
+	}
+	if e, a := 0, len(ec.blackList); e != a {
+		t.Errorf("11 expect %d blacklisted endpoints, got %d", e, a)
+	}
+}
+
+func TestUpdateEndpointByApiUpToDate(t *testing.T) {
+	server, endpoint := newRgwServer(t, 3)
+	defer server.Close()
+
+	// the seed is another name of the server
+	path, cleanup := writeEndpointsFile(t,
+		strings.Replace(endpoint.URL, "127.0.0.1", "localhost", 1))
+	defer cleanup()
+	ec := newSeedCollection(t, path)
+
+	if !ec.UpdateEndpointsByEndpoint(endpoint, false) {
+		t.Fatalf("1 expect update from server")
+	}
+	if e, a := 3, ec.lastEpoch; e != a {
+		t.Errorf("2 expect epoch %d, got %d", e, a)
+	}
+
+	// the same epoch again is up to date, the endpoints are kept
+	kept := findActive(ec, endpoint.URL)
+	if !ec.UpdateEndpointByApi() {
+		t.Errorf("3 expect endpoints up to date")
+	}
+	if findActive(ec, endpoint.URL) != kept {
+		t.Errorf("4 expect kept endpoint not to be replaced")
+	}
+
+	// the seeds recover the blacklisted endpoints they list
+	for ec.SelectEndpoint(nil) != nil {
+		ec.AddEndpointToBlacklist(ec.SelectEndpoint(nil))
+	}
+	if !ec.UpdateEndpointFromSeed() {
+		t.Fatalf("5 expect update from seed")
+	}
+	if e, a := endpoint.URL, activeURLs(ec); e != a {
+		t.Errorf("6 expect active %s, got %s", e, a)
+	}
+	if e, a := 0, len(ec.blackList); e != a {
+		t.Errorf("7 expect %d blacklisted endpoints, got %d", e, a)
+	}
+}
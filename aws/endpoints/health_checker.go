@@ -0,0 +1,264 @@
+// Copyright 2020 Baidu, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License"); you may not use this file
+// except in compliance with the License. You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software distributed under the
+// License is distributed on an "AS IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND,
+// either express or implied. See the License for the specific language governing permissions
+// and limitations under the License.
+
+package endpoints
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net"
+	"net/http"
+	"sync"
+	"time"
+)
+
+const (
+	// DefaultProbeTimeout is the default timeout of one health probe.
+	DefaultProbeTimeout = ProbeRequestTimeOut
+
+	// DefaultProbeConcurrency is the default number of health probes a
+	// collection runs at the same time.
+	DefaultProbeConcurrency = 8
+)
+
+// HealthChecker probes whether an endpoint is able to serve requests. It is
+// used by the keep alive of an EndpointCollection to decide when a
+// blacklisted endpoint, or an endpoint of the seed list, is healthy again.
+//
+// Check returns nil if the endpoint is healthy. The context is canceled when
+// the probe times out or the collection is closed. client is the HTTP client
+// of the prober of the collection. Implementations must be safe for
+// concurrent use.
+type HealthChecker interface {
+	Check(ctx context.Context, client *http.Client, endpoint *SingleEndpoint) error
+}
+
+// HealthCheckerFunc is a HealthChecker implemented by a function.
+type HealthCheckerFunc func(ctx context.Context, client *http.Client,
+	endpoint *SingleEndpoint) error
+
+// Check calls fn.
+func (fn HealthCheckerFunc) Check(ctx context.Context, client *http.Client,
+	endpoint *SingleEndpoint) error {
+	return fn(ctx, client, endpoint)
+}
+
+// HTTPHealthChecker sends an unsigned HTTP request to a path of the endpoint
+// and expects one of the given status codes.
+type HTTPHealthChecker struct {
+	// The HTTP method, defaults to GET.
+	Method string
+
+	// The path of the request, such as "/healthz".
+	Path string
+
+	// The raw query of the request, optional.
+	Query string
+
+	// The status codes of a healthy endpoint, defaults to 200.
+	ExpectedStatus []int
+}
+
+// DefaultHealthChecker is used when no HealthChecker is configured. It gets
+// a well known probe key, and takes 200, 403 and 404 as healthy, so it does
+// not need credentials.
+var DefaultHealthChecker HealthChecker = HTTPHealthChecker{
+	Method:         http.MethodGet,
+	Path:           "/" + probeKey,
+	ExpectedStatus: []int{200, 403, 404},
+}
+
+// Check sends the request and checks the status code of the response.
+func (c HTTPHealthChecker) Check(ctx context.Context, client *http.Client,
+	endpoint *SingleEndpoint) error {
+
+	httpRequest := newHttpRequestFromEndpoint(endpoint, c.Path, c.Query)
+	httpRequest.Method = c.Method
+	if httpRequest.Method == "" {
+		httpRequest.Method = http.MethodGet
+	}
+	httpRequest.Header = make(http.Header)
+
+	return CheckHTTPResponse(ctx, client, httpRequest, c.ExpectedStatus...)
+}
+
+// CheckHTTPResponse sends a probe request with ctx and returns nil if the
+// status code of the response is one of expectedStatus, or 200 when none is
+// given. The response body is drained and closed.
+func CheckHTTPResponse(ctx context.Context, client *http.Client,
+	httpRequest *http.Request, expectedStatus ...int) error {
+
+	httpResponse, err := client.Do(httpRequest.WithContext(ctx))
+	if err != nil {
+		return err
+	} else if httpResponse == nil {
+		return fmt.Errorf("response is empty")
+	}
+
+	defer httpResponse.Body.Close()
+	io.Copy(ioutil.Discard, httpResponse.Body)
+
+	if len(expectedStatus) == 0 {
+		expectedStatus = []int{http.StatusOK}
+	}
+	for _, status := range expectedStatus {
+		if httpResponse.StatusCode == status {
+			return nil
+		}
+	}
+	return fmt.Errorf("unexpected status %d from %s", httpResponse.StatusCode,
+		httpRequest.URL.Host)
+}
+
+// TCPHealthChecker only checks that a TCP connection to the endpoint can be
+// established.
+type TCPHealthChecker struct{}
+
+// Check dials the endpoint and closes the connection.
+func (TCPHealthChecker) Check(ctx context.Context, client *http.Client,
+	endpoint *SingleEndpoint) error {
+
+	address := endpoint.HostAndPort
+	if endpoint.Port == "" {
+		port := "80"
+		if endpoint.Protocol == "https" {
+			port = "443"
+		}
+		address = net.JoinHostPort(endpoint.Host, port)
+	}
+
+	var dialer net.Dialer
+	conn, err := dialer.DialContext(ctx, "tcp", address)
+	if err != nil {
+		return err
+	}
+	return conn.Close()
+}
+
+func (e *EndpointCollection) healthChecker() HealthChecker {
+	if e.options.HealthChecker != nil {
+		return e.options.HealthChecker
+	}
+	return DefaultHealthChecker
+}
+
+func (e *EndpointCollection) probeTimeout() time.Duration {
+	if e.options.ProbeTimeout > 0 {
+		return e.options.ProbeTimeout
+	}
+	return DefaultProbeTimeout
+}
+
+func (e *EndpointCollection) probeConcurrency() int {
+	if e.options.ProbeConcurrency > 0 {
+		return e.options.ProbeConcurrency
+	}
+	return DefaultProbeConcurrency
+}
+
+// acquireProbe waits for a free probe slot, returns false if ctx is done
+func (e *EndpointCollection) acquireProbe(ctx context.Context) bool {
+	e.probeSlotsOnce.Do(func() {
+		e.probeSlots = make(chan struct{}, e.probeConcurrency())
+	})
+
+	select {
+	case e.probeSlots <- struct{}{}:
+		return true
+	case <-ctx.Done():
+		return false
+	}
+}
+
+func (e *EndpointCollection) releaseProbe() {
+	<-e.probeSlots
+}
+
+// check runs the health checker on the endpoint
+func (e *EndpointCollection) check(ctx context.Context, endpoint *SingleEndpoint) error {
+	ctx, cancel := context.WithTimeout(ctx, e.probeTimeout())
+	defer cancel()
+
+	if !e.acquireProbe(ctx) {
+		return ctx.Err()
+	}
+	defer e.releaseProbe()
+
+	return e.healthChecker().Check(ctx, e.probeClient(endpoint), endpoint)
+}
+
+// keepAlivePeriod is the time between two rounds of the keep alive
+func (e *EndpointCollection) keepAlivePeriod() time.Duration {
+	if e.keepAliveInterval > 1 {
+		return time.Duration(e.keepAliveInterval) * time.Second
+	}
+	return time.Second
+}
+
+// probeAll probes the endpoints in parallel with at most probeConcurrency
+// workers, and returns the healthy ones in their original order. A round of
+// probes never takes longer than one keep alive period, the probes still
+// running by then fail.
+func (e *EndpointCollection) probeAll(endpoints []*SingleEndpoint) []*SingleEndpoint {
+	if len(endpoints) == 0 {
+		return nil
+	}
+
+	ctx, cancel := context.WithTimeout(e.context(), e.keepAlivePeriod())
+	defer cancel()
+
+	workers := e.probeConcurrency()
+	if workers > len(endpoints) {
+		workers = len(endpoints)
+	}
+
+	healthy := make([]bool, len(endpoints))
+	jobs := make(chan int)
+	var wg sync.WaitGroup
+	for i := 0; i < workers; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for j := range jobs {
+				endpoint := endpoints[j]
+				if endpoint.Host == "" {
+					continue
+				}
+				err := e.check(ctx, endpoint)
+				healthy[j] = err == nil
+				e.mutex.Lock()
+				e.emitEvent(EndpointEvent{Type: EndpointProbed, Time: time.Now(),
+					URL: endpoint.URL, Err: err})
+				e.unlock()
+			}
+		}()
+	}
+
+	for i := range endpoints {
+		select {
+		case jobs <- i:
+		case <-ctx.Done():
+		}
+	}
+	close(jobs)
+	wg.Wait()
+
+	result := make([]*SingleEndpoint, 0, len(endpoints))
+	for i, endpoint := range endpoints {
+		if healthy[i] {
+			result = append(result, endpoint)
+		}
+	}
+	return result
+}
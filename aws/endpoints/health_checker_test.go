@@ -0,0 +1,359 @@
+package endpoints
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+func newTestEndpoint(t *testing.T, URL string) *SingleEndpoint {
+	endpoint := &SingleEndpoint{}
+	if err := parseEndpointFromString(URL, endpoint); err != nil {
+		t.Fatalf("expect nil, got err %v", err)
+	}
+	return endpoint
+}
+
+func TestHTTPHealthChecker(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch {
+		case r.URL.Path == "/healthz" && r.URL.RawQuery == "full=1":
+			w.WriteHeader(http.StatusOK)
+		case r.URL.Path == "/healthz" && r.Method == http.MethodHead:
+			w.WriteHeader(http.StatusNoContent)
+		case r.URL.Path == "/"+probeKey:
+			w.WriteHeader(http.StatusForbidden)
+		default:
+			w.WriteHeader(http.StatusServiceUnavailable)
+		}
+	}))
+	defer server.Close()
+	endpoint := newTestEndpoint(t, server.URL)
+
+	cases := map[string]struct {
+		checker HealthChecker
+		healthy bool
+	}{
+		"default": {
+			checker: DefaultHealthChecker,
+			healthy: true,
+		},
+		"path and query": {
+			checker: HTTPHealthChecker{Path: "/healthz", Query: "full=1"},
+			healthy: true,
+		},
+		"unexpected status": {
+			checker: HTTPHealthChecker{Path: "/healthz"},
+			healthy: false,
+		},
+		"expected status": {
+			checker: HTTPHealthChecker{
+				Method:         http.MethodHead,
+				Path:           "/healthz",
+				ExpectedStatus: []int{http.StatusNoContent},
+			},
+			healthy: true,
+		},
+		"tcp": {
+			checker: TCPHealthChecker{},
+			healthy: true,
+		},
+	}
+
+	for name, c := range cases {
+		err := c.checker.Check(context.Background(), http.DefaultClient, endpoint)
+		if healthy := err == nil; healthy != c.healthy {
+			t.Errorf("%s expect healthy %v, got err %v", name, c.healthy, err)
+		}
+	}
+
+	closed := newTestEndpoint(t, "http://127.0.0.1:808") // port 808 should not listen
+	if err := (TCPHealthChecker{}).Check(context.Background(), nil, closed); err == nil {
+		t.Errorf("expect tcp check of closed port to fail")
+	}
+}
+
+func TestCollectionHealthChecker(t *testing.T) {
+	var probed []string
+	var mutex sync.Mutex
+	checker := HealthCheckerFunc(func(ctx context.Context, client *http.Client,
+		endpoint *SingleEndpoint) error {
+
+		mutex.Lock()
+		probed = append(probed, endpoint.HostAndPort)
+		mutex.Unlock()
+
+		if strings.HasPrefix(endpoint.Host, "bad") {
+			return errors.New("bad endpoint")
+		}
+		return nil
+	})
+
+	ec, err := NewEndpointCollection(TEST_ENDPOINT_PATH, 100, func(o *CollectionOptions) {
+		o.HealthChecker = checker
+	})
+	if err != nil {
+		t.Fatalf("1 expect nil, got err %v", err)
+	}
+	defer ec.Close()
+
+	if !ec.probeEndpoint("http://good.test:8080") {
+		t.Errorf("2 expect good endpoint healthy")
+	}
+	if ec.probeEndpoint("http://bad.test:8080") {
+		t.Errorf("3 expect bad endpoint unhealthy")
+	}
+	if ec.probeEndpoint("") {
+		t.Errorf("4 expect invalid endpoint unhealthy")
+	}
+
+	mutex.Lock()
+	defer mutex.Unlock()
+	if e, a := []string{"good.test:8080", "bad.test:8080"}, probed; strings.Join(e, ",") != strings.Join(a, ",") {
+		t.Errorf("5 expect probed %v, got %v", e, a)
+	}
+}
+
+func TestProbeTimeout(t *testing.T) {
+	checker := HealthCheckerFunc(func(ctx context.Context, client *http.Client,
+		endpoint *SingleEndpoint) error {
+		<-ctx.Done()
+		return ctx.Err()
+	})
+
+	ec, err := NewEndpointCollection(TEST_ENDPOINT_PATH, 100, func(o *CollectionOptions) {
+		o.HealthChecker = checker
+		o.ProbeTimeout = 10 * time.Millisecond
+	})
+	if err != nil {
+		t.Fatalf("1 expect nil, got err %v", err)
+	}
+	defer ec.Close()
+
+	start := time.Now()
+	if ec.probeEndpoint("http://abc1.test:8080") {
+		t.Errorf("2 expect probe to time out")
+	}
+	if elapsed := time.Since(start); elapsed > time.Second {
+		t.Errorf("3 expect probe to time out quickly, took %v", elapsed)
+	}
+}
+
+func TestProbeConcurrency(t *testing.T) {
+	var inflight, maxInflight int32
+	checker := HealthCheckerFunc(func(ctx context.Context, client *http.Client,
+		endpoint *SingleEndpoint) error {
+
+		n := atomic.AddInt32(&inflight, 1)
+		defer atomic.AddInt32(&inflight, -1)
+		for {
+			max := atomic.LoadInt32(&maxInflight)
+			if n <= max || atomic.CompareAndSwapInt32(&maxInflight, max, n) {
+				break
+			}
+		}
+		time.Sleep(5 * time.Millisecond)
+		return nil
+	})
+
+	ec, err := NewEndpointCollection(TEST_ENDPOINT_PATH, 100, func(o *CollectionOptions) {
+		o.HealthChecker = checker
+		o.ProbeConcurrency = 2
+	})
+	if err != nil {
+		t.Fatalf("1 expect nil, got err %v", err)
+	}
+	defer ec.Close()
+
+	var wg sync.WaitGroup
+	for i := 0; i < 10; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			ec.probeEndpoint("http://abc1.test:8080")
+		}()
+	}
+	wg.Wait()
+
+	if a := atomic.LoadInt32(&maxInflight); a > 2 || a < 1 {
+		t.Errorf("2 expect at most 2 probes in flight, got %d", a)
+	}
+}
+
+// newIdleCollection returns a collection of TEST_ENDPOINT_PATH without a
+// keep alive running in the background
+func newIdleCollection(t *testing.T, keepAliveInterval int, options CollectionOptions) *EndpointCollection {
+	ec := &EndpointCollection{
+		lastEpoch:         -1,
+		keepAliveInterval: keepAliveInterval,
+		blackList:         make(map[string]*SingleEndpoint),
+		notify:            make(chan bool, 1),
+		options:           options,
+	}
+	if err := ec.ReadEndpointsFromFile(TEST_ENDPOINT_PATH, true); err != nil {
+		t.Fatalf("expect nil, got err %v", err)
+	}
+	return ec
+}
+
+// forget the active endpoints, as an update from the server to an empty
+// list would
+func dropActiveEndpoints(ec *EndpointCollection) {
+	ec.mutex.Lock()
+	defer ec.mutex.Unlock()
+
+	ec.endpointHead = nil
+	ec.numOfActiveEndpoint = 0
+	ec.validMinEndpointId++
+	ec.publish()
+}
+
+func TestProbeBlacklistParallel(t *testing.T) {
+	var inflight, maxInflight int32
+	checker := HealthCheckerFunc(func(ctx context.Context, client *http.Client,
+		endpoint *SingleEndpoint) error {
+
+		n := atomic.AddInt32(&inflight, 1)
+		defer atomic.AddInt32(&inflight, -1)
+		for {
+			max := atomic.LoadInt32(&maxInflight)
+			if n <= max || atomic.CompareAndSwapInt32(&maxInflight, max, n) {
+				break
+			}
+		}
+		time.Sleep(200 * time.Millisecond)
+		return nil
+	})
+
+	ec := newIdleCollection(t, 100, CollectionOptions{
+		HealthChecker:  checker,
+		CircuitBreaker: CircuitBreakerConfig{OpenTimeout: time.Nanosecond},
+	})
+	for ec.SelectEndpoint(nil) != nil {
+		ec.AddEndpointToBlacklist(ec.SelectEndpoint(nil))
+	}
+
+	start := time.Now()
+	if !ec.probeBlacklist() {
+		t.Fatalf("1 expect blacklist recovered")
+	}
+	if elapsed := time.Since(start); elapsed > 500*time.Millisecond {
+		t.Errorf("2 expect probes in parallel, took %v", elapsed)
+	}
+	if e, a := int32(3), atomic.LoadInt32(&maxInflight); e != a {
+		t.Errorf("3 expect %d probes in flight, got %d", e, a)
+	}
+	if e, a := 3, len(ec.Scores()); e != a {
+		t.Errorf("4 expect %d endpoints, got %d", e, a)
+	}
+	if e, a := 0, len(ec.blackList); e != a {
+		t.Errorf("5 expect %d blacklisted endpoints, got %d", e, a)
+	}
+}
+
+func TestProbeBlacklistEndpointAttributes(t *testing.T) {
+	var probed []*SingleEndpoint
+	var mutex sync.Mutex
+	checker := HealthCheckerFunc(func(ctx context.Context, client *http.Client,
+		endpoint *SingleEndpoint) error {
+
+		mutex.Lock()
+		defer mutex.Unlock()
+		probed = append(probed, endpoint.clone())
+		return nil
+	})
+
+	// not started, so that only this test probes
+	ec := newEndpointCollection(100, []func(*CollectionOptions){func(o *CollectionOptions) {
+		o.HealthChecker = checker
+		o.CircuitBreaker = CircuitBreakerConfig{OpenTimeout: time.Nanosecond}
+	}})
+	defer ec.Close()
+	endpoints, _ := parseEndpointList([]string{"http://abc1.test:8080 zone=z1 weight=3 max_connections=4"})
+	if err := ec.useEndpoints(endpoints, nil, true); err != nil {
+		t.Fatalf("1 expect nil, got err %v", err)
+	}
+	ec.AddEndpointToBlacklist(ec.SelectEndpoint(nil))
+
+	if !ec.probeBlacklist() {
+		t.Fatalf("2 expect blacklist recovered")
+	}
+
+	mutex.Lock()
+	defer mutex.Unlock()
+	if e, a := 1, len(probed); e != a {
+		t.Fatalf("3 expect %d probes, got %d", e, a)
+	}
+	if a := probed[0]; a.Zone != "z1" || a.Weight != 3 || a.MaxConnections != 4 {
+		t.Errorf("4 expect the attributes of the endpoint probed, got zone %q weight %d max connections %d",
+			a.Zone, a.Weight, a.MaxConnections)
+	}
+}
+
+func TestProbeRoundDeadline(t *testing.T) {
+	checker := HealthCheckerFunc(func(ctx context.Context, client *http.Client,
+		endpoint *SingleEndpoint) error {
+		if endpoint.Host == "abc2.test" {
+			return nil
+		}
+		<-ctx.Done()
+		return ctx.Err()
+	})
+
+	ec := newIdleCollection(t, 1, CollectionOptions{
+		HealthChecker:    checker,
+		ProbeTimeout:     time.Minute,
+		ProbeConcurrency: 1,
+	})
+	dropActiveEndpoints(ec)
+
+	start := time.Now()
+	ok := ec.probeEndpointFromSeed()
+	if elapsed := time.Since(start); elapsed > 2*time.Second {
+		t.Errorf("1 expect probes to stop after one keep alive period, took %v", elapsed)
+	}
+
+	// abc1 blocks the only worker until the deadline, abc2 is never probed
+	if ok {
+		t.Errorf("2 expect no seed recovered")
+	}
+	if endpoint := ec.SelectEndpoint(nil); endpoint != nil {
+		t.Errorf("3 expect no active endpoint, got %s", endpoint.URL)
+	}
+}
+
+func TestProbeEndpointFromSeedParallel(t *testing.T) {
+	checker := HealthCheckerFunc(func(ctx context.Context, client *http.Client,
+		endpoint *SingleEndpoint) error {
+		time.Sleep(200 * time.Millisecond)
+		if endpoint.Host == "abc3.test" {
+			return errors.New("bad endpoint")
+		}
+		return nil
+	})
+
+	ec := newIdleCollection(t, 100, CollectionOptions{HealthChecker: checker})
+	dropActiveEndpoints(ec)
+
+	start := time.Now()
+	if !ec.probeEndpointFromSeed() {
+		t.Fatalf("1 expect seeds recovered")
+	}
+	if elapsed := time.Since(start); elapsed > 500*time.Millisecond {
+		t.Errorf("2 expect probes in parallel, took %v", elapsed)
+	}
+
+	var URLs []string
+	for _, score := range ec.Scores() {
+		URLs = append(URLs, score.URL)
+	}
+	if e, a := "http://abc1.test:8080,http://abc2.test:8080", strings.Join(URLs, ","); e != a {
+		t.Errorf("3 expect %s, got %s", e, a)
+	}
+}
@@ -0,0 +1,84 @@
+// Copyright 2020 Baidu, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License"); you may not use this file
+// except in compliance with the License. You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software distributed under the
+// License is distributed on an "AS IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND,
+// either express or implied. See the License for the specific language governing permissions
+// and limitations under the License.
+
+// Package healthcheck provides health checkers of endpoints.EndpointCollection
+// which depend on the signers of the SDK, and so cannot live in package
+// endpoints.
+package healthcheck
+
+import (
+	"context"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/aws/aws-sdk-go/aws/credentials"
+	"github.com/aws/aws-sdk-go/aws/endpoints"
+	"github.com/aws/aws-sdk-go/aws/signer/v4"
+)
+
+// DefaultRegion is the region used to sign the probes when none is set.
+const DefaultRegion = "us-east-1"
+
+// SignedHeadChecker sends a V4 signed HEAD request for a bucket, or an object
+// of the bucket. Unlike endpoints.DefaultHealthChecker it fails when the
+// gateway rejects the credentials or its backend cluster is down.
+type SignedHeadChecker struct {
+	// The credentials used to sign the probes. Nil or
+	// credentials.AnonymousCredentials send unsigned probes.
+	Credentials *credentials.Credentials
+
+	// The region used to sign the probes, defaults to DefaultRegion.
+	Region string
+
+	// The bucket to probe.
+	Bucket string
+
+	// The key to probe, optional. The bucket itself is probed when empty.
+	Key string
+
+	// The status codes of a healthy endpoint, defaults to 200 and 404. A
+	// missing object is fine, a 403 means the signature was rejected.
+	ExpectedStatus []int
+}
+
+// Check signs the HEAD request and checks the status code of the response.
+func (c SignedHeadChecker) Check(ctx context.Context, client *http.Client,
+	endpoint *endpoints.SingleEndpoint) error {
+
+	path := "/" + c.Bucket
+	if c.Key != "" {
+		path += "/" + strings.TrimPrefix(c.Key, "/")
+	}
+
+	httpRequest, err := http.NewRequest(http.MethodHead, endpoint.URL+path, nil)
+	if err != nil {
+		return err
+	}
+
+	region := c.Region
+	if region == "" {
+		region = DefaultRegion
+	}
+	if c.Credentials != nil && c.Credentials != credentials.AnonymousCredentials {
+		signer := v4.NewSigner(c.Credentials)
+		if _, err := signer.Sign(httpRequest, nil, "s3", region, time.Now()); err != nil {
+			return err
+		}
+	}
+
+	expectedStatus := c.ExpectedStatus
+	if len(expectedStatus) == 0 {
+		expectedStatus = []int{http.StatusOK, http.StatusNotFound}
+	}
+	return endpoints.CheckHTTPResponse(ctx, client, httpRequest, expectedStatus...)
+}
@@ -0,0 +1,90 @@
+package healthcheck
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/aws/aws-sdk-go/aws/credentials"
+	"github.com/aws/aws-sdk-go/aws/endpoints"
+)
+
+func TestSignedHeadChecker(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		auth := r.Header.Get("Authorization")
+		switch {
+		case auth == "":
+			w.WriteHeader(http.StatusUnauthorized)
+		case r.Method != http.MethodHead:
+			w.WriteHeader(http.StatusMethodNotAllowed)
+		case !strings.Contains(auth, "Credential=AKID/") ||
+			!strings.Contains(auth, "/us-west-2/s3/aws4_request"):
+			w.WriteHeader(http.StatusForbidden)
+		case r.URL.Path == "/bucket" || r.URL.Path == "/bucket/key":
+			w.WriteHeader(http.StatusOK)
+		case r.URL.Path == "/down":
+			w.WriteHeader(http.StatusServiceUnavailable)
+		default:
+			w.WriteHeader(http.StatusNotFound)
+		}
+	}))
+	defer server.Close()
+
+	endpoint := &endpoints.SingleEndpoint{URL: server.URL}
+	creds := credentials.NewStaticCredentials("AKID", "SECRET", "")
+
+	cases := map[string]struct {
+		checker SignedHeadChecker
+		healthy bool
+	}{
+		"bucket": {
+			checker: SignedHeadChecker{Credentials: creds, Region: "us-west-2", Bucket: "bucket"},
+			healthy: true,
+		},
+		"key": {
+			checker: SignedHeadChecker{Credentials: creds, Region: "us-west-2", Bucket: "bucket", Key: "key"},
+			healthy: true,
+		},
+		"missing key": {
+			checker: SignedHeadChecker{Credentials: creds, Region: "us-west-2", Bucket: "bucket", Key: "missing"},
+			healthy: true,
+		},
+		"missing key not expected": {
+			checker: SignedHeadChecker{Credentials: creds, Region: "us-west-2", Bucket: "bucket",
+				Key: "missing", ExpectedStatus: []int{http.StatusOK}},
+			healthy: false,
+		},
+		"backend down": {
+			checker: SignedHeadChecker{Credentials: creds, Region: "us-west-2", Bucket: "down"},
+			healthy: false,
+		},
+		"signature rejected": {
+			checker: SignedHeadChecker{Credentials: creds, Bucket: "bucket"},
+			healthy: false,
+		},
+		"anonymous": {
+			checker: SignedHeadChecker{Credentials: credentials.AnonymousCredentials,
+				Region: "us-west-2", Bucket: "bucket"},
+			healthy: false,
+		},
+		"nil credentials": {
+			checker: SignedHeadChecker{Region: "us-west-2", Bucket: "bucket"},
+			healthy: false,
+		},
+	}
+
+	for name, c := range cases {
+		err := c.checker.Check(context.Background(), http.DefaultClient, endpoint)
+		if healthy := err == nil; healthy != c.healthy {
+			t.Errorf("%s expect healthy %v, got err %v", name, c.healthy, err)
+		}
+	}
+
+	// without credentials the probe is sent unsigned
+	err := SignedHeadChecker{Bucket: "bucket"}.Check(context.Background(), http.DefaultClient, endpoint)
+	if err == nil || !strings.Contains(err.Error(), "unexpected status 401") {
+		t.Errorf("expect an unsigned probe, got err %v", err)
+	}
+}
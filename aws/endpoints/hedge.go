@@ -0,0 +1,165 @@
+// Copyright 2020 Baidu, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License"); you may not use this file
+// except in compliance with the License. You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software distributed under the
+// License is distributed on an "AS IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND,
+// either express or implied. See the License for the specific language governing permissions
+// and limitations under the License.
+
+package endpoints
+
+import (
+	"sort"
+	"sync"
+	"time"
+)
+
+const (
+	// DefaultHedgePercentile is the default percentile of the recent read
+	// latencies after which a read is hedged.
+	DefaultHedgePercentile = 0.95
+
+	// DefaultHedgeMinDelay is the default lower bound of the hedge delay.
+	DefaultHedgeMinDelay = 10 * time.Millisecond
+
+	// DefaultHedgeMaxDelay is the default upper bound of the hedge delay.
+	DefaultHedgeMaxDelay = 1 * time.Second
+
+	// number of recent read latencies the hedge delay is computed from
+	readLatencyWindow = 256
+
+	// the hedge delay is MaxDelay until this many latencies are known
+	minReadLatencySamples = 20
+)
+
+// DefaultHedgeOperations are the idempotent S3 reads hedged by default.
+var DefaultHedgeOperations = []string{
+	"GetObject",
+	"HeadObject",
+	"HeadBucket",
+	"ListObjects",
+	"ListObjectsV2",
+}
+
+// HedgeConfig configures hedged reads. A read which did not receive the
+// headers of its response within the hedge delay is sent again to another
+// endpoint of the collection, the first response is used and the other
+// request is canceled. Zero values are replaced by the defaults.
+type HedgeConfig struct {
+	// Percentile of the recent read latencies of the collection used as
+	// the hedge delay, between 0 and 1.
+	Percentile float64
+
+	// Lower bound of the hedge delay.
+	MinDelay time.Duration
+
+	// Upper bound of the hedge delay. It is also the delay until enough
+	// reads completed.
+	MaxDelay time.Duration
+
+	// Names of the operations which may be hedged. They must be
+	// idempotent and send no body.
+	Operations []string
+}
+
+func (c HedgeConfig) withDefaults() HedgeConfig {
+	if c.Percentile <= 0 || c.Percentile > 1 {
+		c.Percentile = DefaultHedgePercentile
+	}
+	if c.MinDelay <= 0 {
+		c.MinDelay = DefaultHedgeMinDelay
+	}
+	if c.MaxDelay <= 0 {
+		c.MaxDelay = DefaultHedgeMaxDelay
+	}
+	if c.MaxDelay < c.MinDelay {
+		c.MaxDelay = c.MinDelay
+	}
+	if c.Operations == nil {
+		c.Operations = DefaultHedgeOperations
+	}
+	return c
+}
+
+// Hedges reports whether the operation may be hedged.
+func (c HedgeConfig) Hedges(operation string) bool {
+	for _, name := range c.withDefaults().Operations {
+		if name == operation {
+			return true
+		}
+	}
+	return false
+}
+
+// the most recent read latencies of a collection
+type latencyWindow struct {
+	mutex   sync.Mutex
+	samples [readLatencyWindow]time.Duration
+	count   int
+	next    int
+}
+
+func (w *latencyWindow) record(latency time.Duration) {
+	w.mutex.Lock()
+	defer w.mutex.Unlock()
+
+	w.samples[w.next] = latency
+	w.next = (w.next + 1) % len(w.samples)
+	if w.count < len(w.samples) {
+		w.count++
+	}
+}
+
+// percentile returns the p-th percentile of the samples, false if there are
+// too few of them
+func (w *latencyWindow) percentile(p float64) (time.Duration, bool) {
+	w.mutex.Lock()
+	if w.count < minReadLatencySamples {
+		w.mutex.Unlock()
+		return 0, false
+	}
+	sorted := make([]time.Duration, w.count)
+	copy(sorted, w.samples[:w.count])
+	w.mutex.Unlock()
+
+	sort.Sort(durations(sorted))
+	i := int(p*float64(len(sorted))+0.5) - 1
+	if i < 0 {
+		i = 0
+	}
+	if i >= len(sorted) {
+		i = len(sorted) - 1
+	}
+	return sorted[i], true
+}
+
+type durations []time.Duration
+
+func (a durations) Len() int           { return len(a) }
+func (a durations) Swap(i, j int)      { a[i], a[j] = a[j], a[i] }
+func (a durations) Less(i, j int) bool { return a[i] < a[j] }
+
+// RecordReadLatency records the time a read of the collection took to
+// receive the headers of its response, it feeds HedgeDelay.
+func (e *EndpointCollection) RecordReadLatency(latency time.Duration) {
+	e.reads.record(latency)
+}
+
+// HedgeDelay returns the time after which a read sent to the collection is
+// hedged: the cfg.Percentile of the recent read latencies, bounded by
+// cfg.MinDelay and cfg.MaxDelay.
+func (e *EndpointCollection) HedgeDelay(cfg HedgeConfig) time.Duration {
+	cfg = cfg.withDefaults()
+	delay, ok := e.reads.percentile(cfg.Percentile)
+	if !ok || delay > cfg.MaxDelay {
+		return cfg.MaxDelay
+	}
+	if delay < cfg.MinDelay {
+		return cfg.MinDelay
+	}
+	return delay
+}
@@ -0,0 +1,54 @@
+package endpoints
+
+import (
+	"testing"
+	"time"
+)
+
+func TestHedgeDelay(t *testing.T) {
+	ec := newIdleCollection(t, 100, CollectionOptions{})
+	cfg := HedgeConfig{Percentile: 0.9, MinDelay: 5 * time.Millisecond, MaxDelay: 200 * time.Millisecond}
+
+	if e, a := 200*time.Millisecond, ec.HedgeDelay(cfg); e != a {
+		t.Errorf("1 expect %v until enough samples, got %v", e, a)
+	}
+
+	for i := 1; i <= 100; i++ {
+		ec.RecordReadLatency(time.Duration(i) * time.Millisecond)
+	}
+	if e, a := 90*time.Millisecond, ec.HedgeDelay(cfg); e != a {
+		t.Errorf("2 expect %v, got %v", e, a)
+	}
+
+	cfg.MaxDelay = 50 * time.Millisecond
+	if e, a := 50*time.Millisecond, ec.HedgeDelay(cfg); e != a {
+		t.Errorf("3 expect %v, got %v", e, a)
+	}
+
+	// only the most recent latencies count
+	for i := 0; i < readLatencyWindow; i++ {
+		ec.RecordReadLatency(time.Millisecond)
+	}
+	if e, a := 5*time.Millisecond, ec.HedgeDelay(cfg); e != a {
+		t.Errorf("4 expect %v, got %v", e, a)
+	}
+
+	if e, a := DefaultHedgeMinDelay, ec.HedgeDelay(HedgeConfig{}); e != a {
+		t.Errorf("5 expect %v, got %v", e, a)
+	}
+}
+
+func TestHedgeConfigHedges(t *testing.T) {
+	var cfg HedgeConfig
+	if !cfg.Hedges("GetObject") || !cfg.Hedges("HeadObject") {
+		t.Errorf("expect the default reads hedged")
+	}
+	if cfg.Hedges("PutObject") {
+		t.Errorf("expect PutObject not hedged")
+	}
+
+	cfg.Operations = []string{"GetObject"}
+	if cfg.Hedges("HeadObject") {
+		t.Errorf("expect HeadObject not hedged")
+	}
+}
@@ -0,0 +1,125 @@
+// Copyright 2020 Baidu, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License"); you may not use this file
+// except in compliance with the License. You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software distributed under the
+// License is distributed on an "AS IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND,
+// either express or implied. See the License for the specific language governing permissions
+// and limitations under the License.
+
+package endpoints
+
+import "sync"
+
+const (
+	// DefaultRetryQuotaCapacity is the default number of tokens of a
+	// RetryQuota.
+	DefaultRetryQuotaCapacity = 500
+
+	// DefaultRetryCost is the default number of tokens a retry draws.
+	DefaultRetryCost = 5
+
+	// DefaultTimeoutRetryCost is the default number of tokens the retry of
+	// a timed out request draws.
+	DefaultTimeoutRetryCost = 10
+
+	// DefaultSuccessRefill is the default number of tokens a request
+	// succeeding at its first attempt gives back.
+	DefaultSuccessRefill = 1
+)
+
+// RetryQuotaConfig configures a RetryQuota. Zero values are replaced by
+// the defaults.
+type RetryQuotaConfig struct {
+	// Number of tokens of the quota, it starts full.
+	Capacity int
+
+	// Tokens drawn by a retry.
+	RetryCost int
+
+	// Tokens drawn by the retry of a timed out request.
+	TimeoutRetryCost int
+
+	// Tokens given back by a request succeeding at its first attempt. A
+	// request succeeding after retries gives back the tokens it drew.
+	SuccessRefill int
+}
+
+func (c RetryQuotaConfig) withDefaults() RetryQuotaConfig {
+	if c.Capacity <= 0 {
+		c.Capacity = DefaultRetryQuotaCapacity
+	}
+	if c.RetryCost <= 0 {
+		c.RetryCost = DefaultRetryCost
+	}
+	if c.TimeoutRetryCost <= 0 {
+		c.TimeoutRetryCost = DefaultTimeoutRetryCost
+	}
+	if c.SuccessRefill <= 0 {
+		c.SuccessRefill = DefaultSuccessRefill
+	}
+	return c
+}
+
+// RetryQuota is a token bucket shared by the requests of one or more
+// clients. Every retry draws tokens from it and successes refill it, so that
+// once most requests fail the retries stop instead of multiplying the load
+// of an overloaded cluster. It is safe for concurrent use.
+type RetryQuota struct {
+	cfg RetryQuotaConfig
+
+	mutex     sync.Mutex
+	available int
+}
+
+// NewRetryQuota returns a full RetryQuota.
+func NewRetryQuota(cfg RetryQuotaConfig) *RetryQuota {
+	cfg = cfg.withDefaults()
+	return &RetryQuota{cfg: cfg, available: cfg.Capacity}
+}
+
+// Draw takes the tokens of a retry from the quota, the cost of a timeout
+// retry if timeout is set. Returns the tokens drawn, and false without
+// drawing any if the quota does not hold enough.
+func (q *RetryQuota) Draw(timeout bool) (int, bool) {
+	cost := q.cfg.RetryCost
+	if timeout {
+		cost = q.cfg.TimeoutRetryCost
+	}
+
+	q.mutex.Lock()
+	defer q.mutex.Unlock()
+
+	if q.available < cost {
+		return 0, false
+	}
+	q.available -= cost
+	return cost, true
+}
+
+// Refill gives back the tokens drawn by the retries of a request which
+// succeeded, or SuccessRefill tokens if it did not retry.
+func (q *RetryQuota) Refill(drawn int) {
+	if drawn <= 0 {
+		drawn = q.cfg.SuccessRefill
+	}
+
+	q.mutex.Lock()
+	defer q.mutex.Unlock()
+
+	q.available += drawn
+	if q.available > q.cfg.Capacity {
+		q.available = q.cfg.Capacity
+	}
+}
+
+// Available returns the number of tokens left in the quota.
+func (q *RetryQuota) Available() int {
+	q.mutex.Lock()
+	defer q.mutex.Unlock()
+
+	return q.available
+}
@@ -0,0 +1,35 @@
+package endpoints
+
+import "testing"
+
+func TestRetryQuota(t *testing.T) {
+	q := NewRetryQuota(RetryQuotaConfig{Capacity: 12})
+
+	if e, a := 12, q.Available(); e != a {
+		t.Fatalf("1 expect %d tokens, got %d", e, a)
+	}
+	if cost, ok := q.Draw(true); !ok || cost != DefaultTimeoutRetryCost {
+		t.Fatalf("2 expect %d tokens drawn, got %d, %v", DefaultTimeoutRetryCost, cost, ok)
+	}
+	if cost, ok := q.Draw(false); ok {
+		t.Fatalf("3 expect the quota exceeded, drew %d", cost)
+	}
+	if e, a := 2, q.Available(); e != a {
+		t.Fatalf("4 expect %d tokens, got %d", e, a)
+	}
+
+	// a request succeeding at its first attempt gives back SuccessRefill
+	for i := 0; i < 3; i++ {
+		q.Refill(0)
+	}
+	if cost, ok := q.Draw(false); !ok || cost != DefaultRetryCost {
+		t.Fatalf("5 expect %d tokens drawn, got %d, %v", DefaultRetryCost, cost, ok)
+	}
+
+	// a request succeeding after retries gives back what it drew, up to
+	// the capacity
+	q.Refill(DefaultRetryCost + DefaultTimeoutRetryCost)
+	if e, a := 12, q.Available(); e != a {
+		t.Errorf("6 expect %d tokens, got %d", e, a)
+	}
+}
@@ -0,0 +1,215 @@
+// Copyright 2020 Baidu, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License"); you may not use this file
+// except in compliance with the License. You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software distributed under the
+// License is distributed on an "AS IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND,
+// either express or implied. See the License for the specific language governing permissions
+// and limitations under the License.
+
+package endpoints
+
+import (
+	"os"
+	"time"
+)
+
+// DefaultSeedReloadInterval is how often the endpoints file of a collection
+// is checked for changes when CollectionOptions.SeedReloadInterval is 0.
+const DefaultSeedReloadInterval = 10 * time.Second
+
+// fileStamp identifies a version of the endpoints file
+type fileStamp struct {
+	modTime time.Time
+	size    int64
+}
+
+func statEndpointsFile(endpointsPath string) (fileStamp, error) {
+	info, err := os.Stat(endpointsPath)
+	if err != nil {
+		return fileStamp{}, err
+	}
+	return fileStamp{modTime: info.ModTime(), size: info.Size()}, nil
+}
+
+func (e *EndpointCollection) seedReloadInterval() time.Duration {
+	if e.options.SeedReloadInterval == 0 {
+		return DefaultSeedReloadInterval
+	}
+	return e.options.SeedReloadInterval
+}
+
+// watchSeed reloads the endpoints file whenever its modification time or
+// size changes. It returns when the collection is closed.
+func (e *EndpointCollection) watchSeed() {
+	interval := e.seedReloadInterval()
+	if interval < 0 {
+		return
+	}
+	for {
+		if _, closed := e.sleep(interval, false); closed {
+			return
+		}
+		e.reloadSeed()
+	}
+}
+
+// reloadSeed reads the endpoints file again if it changed since it was last
+// read, and merges its endpoints into the collection. A file which can not
+// be read, or has no valid entry, is retried on the next check and the
+// previous seeds are kept meanwhile. Returns true if the seeds changed.
+func (e *EndpointCollection) reloadSeed() bool {
+	if e.seedPath == "" {
+		return false
+	}
+
+	// stat before reading, a change made during the read is seen by the
+	// next check
+	stamp, err := statEndpointsFile(e.seedPath)
+	if err != nil {
+		return false
+	}
+	e.mutex.Lock()
+	unchanged := stamp == e.seedStamp
+	e.unlock()
+	if unchanged {
+		return false
+	}
+
+	endpointAll, parseErrors, err := readEndpointsFile(e.seedPath)
+	if err != nil || len(endpointAll) == 0 {
+		return false
+	}
+
+	e.mutex.Lock()
+	defer e.unlock()
+
+	e.seedStamp = stamp
+	e.parseErrors = parseErrors
+	e.mergeSeed(endpointAll)
+	return true
+}
+
+// mergeSeed replaces the seeds by endpointAll. While the active endpoints
+// still come from the endpoints file, and not from the server or a
+// discoverer, the removed seeds leave the collection and the added ones join
+// it.
+// must protected by lock
+func (e *EndpointCollection) mergeSeed(endpointAll []SingleEndpoint) {
+	e.endpointSeed = &endpointAll
+	if e.lastEpoch >= 0 || e.options.Discoverer != nil {
+		// the server or the discoverer owns the list of active endpoints,
+		// the seeds are only used once all of them are blacklisted
+		return
+	}
+
+	endpoints := make([]*SingleEndpoint, 0, len(endpointAll))
+	for i := range endpointAll {
+		endpoints = append(endpoints, &endpointAll[i])
+	}
+	e.mergeEndpoints(endpoints)
+}
+
+// mergeEndpoints makes endpoints the endpoints of the collection. The
+// endpoints kept are not replaced, so their state and the requests in flight
+// to them are not affected. Returns true if the collection changed.
+// must protected by lock
+func (e *EndpointCollection) mergeEndpoints(endpoints []*SingleEndpoint) bool {
+	wanted := make(map[string]*SingleEndpoint, len(endpoints))
+	for _, endpoint := range endpoints {
+		wanted[endpoint.URL] = endpoint
+	}
+
+	// an endpoint whose settings changed is replaced as well
+	var removed []*SingleEndpoint
+	for _, endpoint := range e.ringEndpoints() {
+		if seed, ok := wanted[endpoint.URL]; !ok || !seed.sameSettings(endpoint) {
+			removed = append(removed, endpoint)
+		}
+	}
+	for _, endpoint := range removed {
+		e.unlinkEndpoint(endpoint)
+		endpoint.Id = 0
+		e.emit(EndpointRemoved, endpoint.URL)
+		e.closeClients(endpoint.URL)
+	}
+	changed := len(removed) > 0
+	for URL, endpoint := range e.blackList {
+		if seed, ok := wanted[URL]; !ok || !seed.sameSettings(endpoint) {
+			delete(e.blackList, URL)
+			endpoint.Id = 0
+			changed = true
+			e.emit(EndpointRemoved, URL)
+			e.closeClients(URL)
+		}
+	}
+
+	for _, endpoint := range endpoints {
+		if e.insertEndpoint(endpoint.clone()) {
+			changed = true
+		}
+	}
+
+	if e.endpointHead == nil {
+		e.notifyKeepAlive()
+	}
+	if changed {
+		e.publish()
+	}
+	return changed
+}
+
+// ringEndpoints returns the endpoints of the ring, blacklisted or not
+// must protected by lock
+func (e *EndpointCollection) ringEndpoints() []*SingleEndpoint {
+	var endpoints []*SingleEndpoint
+	if e.endpointHead == nil {
+		return endpoints
+	}
+	temp := e.endpointHead
+	for {
+		endpoints = append(endpoints, temp)
+		temp = temp.next
+		if temp == nil || temp == e.endpointHead {
+			return endpoints
+		}
+	}
+}
+
+// unlinkEndpoint removes the endpoint from the ring of active endpoints
+// must protected by lock
+func (e *EndpointCollection) unlinkEndpoint(endpoint *SingleEndpoint) {
+	if endpoint.next == nil {
+		return
+	}
+
+	if endpoint.next == endpoint {
+		e.endpointHead = nil
+	} else {
+		endpoint.next.pre = endpoint.pre
+		endpoint.pre.next = endpoint.next
+		if endpoint == e.endpointHead {
+			e.endpointHead = endpoint.next
+		}
+	}
+	endpoint.next = nil
+	endpoint.pre = nil
+	e.numOfActiveEndpoint--
+	if e.numOfActiveEndpoint < 0 {
+		e.numOfActiveEndpoint = 0
+	}
+}
+
+// sameSettings reports whether the endpoints have the same address and
+// settings
+func (s *SingleEndpoint) sameSettings(other *SingleEndpoint) bool {
+	return s.URL == other.URL &&
+		s.Weight == other.Weight &&
+		s.Zone == other.Zone &&
+		s.MaxConnections == other.MaxConnections &&
+		s.TLSServerName == other.TLSServerName &&
+		s.MaxIdleConnections == other.MaxIdleConnections
+}
@@ -0,0 +1,226 @@
+package endpoints
+
+import (
+	"io/ioutil"
+	"os"
+	"strings"
+	"testing"
+	"time"
+)
+
+// newSeedCollection returns a collection of the endpoints file at path
+// without a keep alive or seed watch running in the background
+func newSeedCollection(t *testing.T, path string) *EndpointCollection {
+	ec, err := newFileEndpointCollection(path, 100, []func(*CollectionOptions){
+		func(o *CollectionOptions) {
+			o.CircuitBreaker.FailureThreshold = 1
+		},
+	})
+	if err != nil {
+		t.Fatalf("expect nil, got err %v", err)
+	}
+	return ec
+}
+
+// rewrite the endpoints file with a modification time in the future, so that
+// the change is seen even on file systems with a coarse time resolution
+func rewriteEndpointsFile(t *testing.T, path string, lines ...string) {
+	if err := ioutil.WriteFile(path, []byte(strings.Join(lines, "\n")), 0644); err != nil {
+		t.Fatalf("expect nil, got err %v", err)
+	}
+	future := time.Now().Add(time.Hour)
+	if err := os.Chtimes(path, future, future); err != nil {
+		t.Fatalf("expect nil, got err %v", err)
+	}
+}
+
+func activeURLs(ec *EndpointCollection) string {
+	var URLs []string
+	for _, endpoint := range ec.activeEndpoints() {
+		URLs = append(URLs, endpoint.URL)
+	}
+	return strings.Join(URLs, ",")
+}
+
+func findActive(ec *EndpointCollection, URL string) *SingleEndpoint {
+	for _, endpoint := range ec.activeEndpoints() {
+		if endpoint.URL == URL {
+			return endpoint
+		}
+	}
+	return nil
+}
+
+func TestReloadSeed(t *testing.T) {
+	path, cleanup := writeEndpointsFile(t,
+		"http://abc1.test:8080",
+		"http://abc2.test:8080",
+		"http://abc3.test:8080",
+	)
+	defer cleanup()
+
+	ec := newSeedCollection(t, path)
+	if ec.reloadSeed() {
+		t.Errorf("1 expect unchanged file not to be reloaded")
+	}
+
+	kept := findActive(ec, "http://abc1.test:8080")
+	removed := findActive(ec, "http://abc2.test:8080")
+	blacklisted := findActive(ec, "http://abc3.test:8080")
+	ec.AddEndpointToBlacklist(blacklisted)
+
+	// requests in flight to the endpoints
+	kept.Acquire()
+	removed.Acquire()
+
+	rewriteEndpointsFile(t, path,
+		"http://abc1.test:8080",
+		"http://abc3.test:8080",
+		"http://abc4.test:8080 zone=z1",
+	)
+	if !ec.reloadSeed() {
+		t.Fatalf("2 expect changed file to be reloaded")
+	}
+
+	if e, a := "http://abc1.test:8080,http://abc4.test:8080", activeURLs(ec); e != a {
+		t.Errorf("3 expect active %s, got %s", e, a)
+	}
+	if e, a := kept, findActive(ec, kept.URL); e != a {
+		t.Errorf("4 expect kept endpoint not to be replaced")
+	}
+	if e, a := int64(1), kept.Inflight(); e != a {
+		t.Errorf("5 expect %d request in flight, got %d", e, a)
+	}
+	if e, a := "z1", findActive(ec, "http://abc4.test:8080").Zone; e != a {
+		t.Errorf("6 expect zone %s, got %s", e, a)
+	}
+	if _, ok := ec.blackList[blacklisted.URL]; !ok {
+		t.Errorf("7 expect kept endpoint to stay blacklisted")
+	}
+	if e, a := 3, len(*ec.endpointSeed); e != a {
+		t.Errorf("8 expect %d seeds, got %d", e, a)
+	}
+
+	// the request in flight to the removed endpoint completes, and its
+	// failure does not affect the collection
+	removed.Release()
+	ec.AddEndpointToBlacklist(removed)
+	if e, a := "http://abc1.test:8080,http://abc4.test:8080", activeURLs(ec); e != a {
+		t.Errorf("9 expect active %s, got %s", e, a)
+	}
+	if _, ok := ec.blackList[removed.URL]; ok {
+		t.Errorf("10 expect removed endpoint not to be blacklisted")
+	}
+}
+
+func TestReloadSeedSettings(t *testing.T) {
+	path, cleanup := writeEndpointsFile(t,
+		"http://abc1.test:8080",
+		"http://abc2.test:8080",
+	)
+	defer cleanup()
+
+	ec := newSeedCollection(t, path)
+	before := findActive(ec, "http://abc1.test:8080")
+
+	rewriteEndpointsFile(t, path,
+		"http://abc1.test:8080 weight=5",
+		"http://abc2.test:8080",
+	)
+	if !ec.reloadSeed() {
+		t.Fatalf("1 expect changed file to be reloaded")
+	}
+
+	after := findActive(ec, "http://abc1.test:8080")
+	if after == nil || after == before {
+		t.Fatalf("2 expect endpoint with new settings to be replaced")
+	}
+	if e, a := 5, after.Weight; e != a {
+		t.Errorf("3 expect weight %d, got %d", e, a)
+	}
+	if e, a := "http://abc1.test:8080,http://abc2.test:8080", activeURLs(ec); e != a {
+		t.Errorf("4 expect active %s, got %s", e, a)
+	}
+}
+
+func TestReloadSeedInvalid(t *testing.T) {
+	path, cleanup := writeEndpointsFile(t,
+		"http://abc1.test:8080",
+		"http://abc2.test:8080",
+	)
+	defer cleanup()
+
+	ec := newSeedCollection(t, path)
+
+	// a file without a valid entry keeps the previous seeds
+	rewriteEndpointsFile(t, path, "h")
+	if ec.reloadSeed() {
+		t.Errorf("1 expect invalid file not to be reloaded")
+	}
+	if e, a := "http://abc1.test:8080,http://abc2.test:8080", activeURLs(ec); e != a {
+		t.Errorf("2 expect active %s, got %s", e, a)
+	}
+
+	os.Remove(path)
+	if ec.reloadSeed() {
+		t.Errorf("3 expect missing file not to be reloaded")
+	}
+
+	rewriteEndpointsFile(t, path, "http://abc2.test:8080", "bad entry")
+	if !ec.reloadSeed() {
+		t.Fatalf("4 expect fixed file to be reloaded")
+	}
+	if e, a := "http://abc2.test:8080", activeURLs(ec); e != a {
+		t.Errorf("5 expect active %s, got %s", e, a)
+	}
+	if e, a := 1, len(ec.ParseErrors()); e != a {
+		t.Errorf("6 expect %d parse error, got %d", e, a)
+	}
+}
+
+func TestReloadSeedFromServer(t *testing.T) {
+	path, cleanup := writeEndpointsFile(t, "http://abc1.test:8080")
+	defer cleanup()
+
+	ec := newSeedCollection(t, path)
+	head, num := ec.ParseEndpointFromRgwInfo(&RgwInfo{
+		RgwConfiguration: []*Rgw{{Ip: "10.0.0.1", Port: "8080"}},
+	})
+	if err := ec.UpdateWholeEndpoitCollection(head, num, 1); err != nil {
+		t.Fatalf("expect nil, got err %v", err)
+	}
+
+	// the endpoints from the server are kept, only the seeds change
+	rewriteEndpointsFile(t, path, "http://abc2.test:8080")
+	if !ec.reloadSeed() {
+		t.Fatalf("1 expect changed file to be reloaded")
+	}
+	if e, a := "http://10.0.0.1:8080", activeURLs(ec); e != a {
+		t.Errorf("2 expect active %s, got %s", e, a)
+	}
+	if e, a := "http://abc2.test:8080", (*ec.endpointSeed)[0].URL; e != a {
+		t.Errorf("3 expect seed %s, got %s", e, a)
+	}
+}
+
+func TestWatchSeed(t *testing.T) {
+	path, cleanup := writeEndpointsFile(t, "http://abc1.test:8080")
+	defer cleanup()
+
+	ec, err := NewEndpointCollection(path, 100, func(o *CollectionOptions) {
+		o.SeedReloadInterval = 10 * time.Millisecond
+	})
+	if err != nil {
+		t.Fatalf("expect nil, got err %v", err)
+	}
+	defer ec.Close()
+
+	rewriteEndpointsFile(t, path, "http://abc1.test:8080", "http://abc2.test:8080")
+	deadline := time.Now().Add(5 * time.Second)
+	for activeURLs(ec) != "http://abc1.test:8080,http://abc2.test:8080" {
+		if time.Now().After(deadline) {
+			t.Fatalf("expect added seed to be active, got %s", activeURLs(ec))
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+}
@@ -77,6 +77,11 @@ const (
 	// wire unmarshaled message content of requests and responses made while
 	// using the SDK Will also enable LogDebug.
 	LogDebugWithEventStreamBody
+
+	// LogDebugWithEndpointEvents states the SDK should log the changes of
+	// the endpoint collections, such as blacklisted and recovered
+	// endpoints. Will also enable LogDebug.
+	LogDebugWithEndpointEvents
 )
 
 // A Logger is a minimalistic interface for the SDK to log messages to. Should
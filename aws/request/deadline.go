@@ -0,0 +1,49 @@
+package request
+
+import (
+	"fmt"
+	"time"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/aws/awserr"
+)
+
+// WithOperationTimeout is a request option bounding the total time of the
+// request, see aws.Config.OperationTimeout.
+//
+//     svc.GetObjectWithContext(ctx, params, request.WithOperationTimeout(10 * time.Second))
+func WithOperationTimeout(timeout time.Duration) Option {
+	return func(r *Request) {
+		r.Config.OperationTimeout = &timeout
+	}
+}
+
+// RetryBeforeDeadline returns whether the next retry of the request, made
+// after RetryDelay, can complete before the deadline of the request context,
+// assuming it lasts as long as the last attempt. Otherwise the request fails
+// fast: its Error is replaced by an ErrCodeRetryDeadlineExceeded error
+// wrapping it, it is no longer retryable, and false is returned. Always true
+// without a deadline.
+func (r *Request) RetryBeforeDeadline() bool {
+	deadline, ok := r.Context().Deadline()
+	if !ok {
+		return true
+	}
+
+	var attempt time.Duration
+	if !r.AttemptTime.IsZero() {
+		attempt = time.Since(r.AttemptTime)
+	}
+	if time.Now().Add(r.RetryDelay + attempt).Before(deadline) {
+		return true
+	}
+
+	if r.Config.LogLevel.Matches(aws.LogDebugWithRequestRetries) {
+		r.Config.Logger.Log(fmt.Sprintf("DEBUG: Retry of %s/%s cannot complete before the deadline, not retrying",
+			r.ClientInfo.ServiceName, r.Operation.Name))
+	}
+	r.Error = awserr.New(ErrCodeRetryDeadlineExceeded,
+		"retry cannot complete before the request deadline", r.Error)
+	r.Retryable = aws.Bool(false)
+	return false
+}
@@ -0,0 +1,87 @@
+// +build go1.7
+
+package request
+
+import (
+	"context"
+	"time"
+
+	"github.com/aws/aws-sdk-go/aws/awserr"
+)
+
+// applyOperationTimeout bounds the context of the request by
+// Config.OperationTimeout, so that every attempt, the delays between them
+// and the read of the response body share the time left. The returned
+// func releases the context, unless bindOperationTimeout handed it to the
+// response body of the request.
+func (r *Request) applyOperationTimeout() (finish func()) {
+	if r.Config.OperationTimeout == nil || *r.Config.OperationTimeout <= 0 {
+		return func() {}
+	}
+
+	ctx, cancel := context.WithTimeout(r.Context(), *r.Config.OperationTimeout)
+	setRequestContext(r, ctx)
+	r.cancelOperation = cancel
+	return func() {
+		if r.Error != nil || r.cancelOperation != nil {
+			cancel()
+		}
+		r.cancelOperation = nil
+	}
+}
+
+// bindOperationTimeout hands the release of the context bounded by
+// Config.OperationTimeout to the response body, before it is unmarshaled
+// or kept by a streaming output. The context is released once the body is
+// read or closed.
+func (r *Request) bindOperationTimeout() {
+	cancel := r.cancelOperation
+	if cancel == nil || r.HTTPResponse == nil || r.HTTPResponse.Body == nil {
+		return
+	}
+	r.cancelOperation = nil
+	r.HTTPResponse.Body = &cancelReadCloser{ReadCloser: r.HTTPResponse.Body, cancel: cancel}
+}
+
+// boundAttempt bounds the wait of the attempt for its response by its share
+// of the time left before the deadline of Config.OperationTimeout, the time
+// left divided by the attempts left, so that a hung attempt leaves time to
+// its retries. The last attempt has the time left. The returned func, run
+// once the Send handlers ran, turns an attempt stopped by its share into an
+// ErrCodeResponseTimeout error, and releases the context of the attempt
+// once the response body is read or closed.
+func (r *Request) boundAttempt() (done func()) {
+	deadline, ok := r.Context().Deadline()
+	if r.Config.OperationTimeout == nil || !ok || r.Retryer == nil {
+		return func() {}
+	}
+	attemptsLeft := r.MaxRetries() - r.RetryCount + 1
+	if attemptsLeft <= 1 {
+		return func() {}
+	}
+
+	share := deadline.Sub(time.Now()) / time.Duration(attemptsLeft)
+	ctx, cancel := context.WithCancel(r.Context())
+	timer := time.AfterFunc(share, cancel)
+	httpReq := r.HTTPRequest
+	r.HTTPRequest = httpReq.WithContext(ctx)
+	return func() {
+		// the next attempt is not sent with this context
+		r.HTTPRequest = httpReq
+		if !timer.Stop() {
+			cancel()
+			if resp := r.HTTPResponse; r.Error == nil && resp != nil && resp.Body != nil {
+				// the response came too late, its body is canceled
+				resp.Body.Close()
+			}
+			r.Error = awserr.New(ErrCodeResponseTimeout,
+				"attempt exceeded its share of the operation timeout", r.Error)
+			return
+		}
+		if resp := r.HTTPResponse; r.Error == nil && resp != nil && resp.Body != nil {
+			resp.Body = &cancelReadCloser{ReadCloser: resp.Body, cancel: cancel}
+		} else {
+			cancel()
+		}
+	}
+}
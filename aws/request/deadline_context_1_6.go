@@ -0,0 +1,15 @@
+// +build !go1.7
+
+package request
+
+// the operation timeout needs the context package, it is ignored before
+// Go 1.7
+func (r *Request) applyOperationTimeout() (finish func()) {
+	return func() {}
+}
+
+func (r *Request) bindOperationTimeout() {}
+
+func (r *Request) boundAttempt() (done func()) {
+	return func() {}
+}
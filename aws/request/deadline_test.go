@@ -0,0 +1,204 @@
+// +build go1.7
+
+package request_test
+
+import (
+	"bytes"
+	"context"
+	"io/ioutil"
+	"net/http"
+	"testing"
+	"time"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/aws/awserr"
+	"github.com/aws/aws-sdk-go/aws/client"
+	"github.com/aws/aws-sdk-go/aws/request"
+	"github.com/aws/aws-sdk-go/awstesting"
+	"github.com/aws/aws-sdk-go/awstesting/unit"
+	"github.com/aws/aws-sdk-go/service/s3"
+)
+
+func newDeadlineClient(cfg *aws.Config, send func(r *request.Request)) *client.Client {
+	s := awstesting.NewClient(cfg)
+	s.Handlers.Validate.Clear()
+	s.Handlers.Unmarshal.PushBack(unmarshal)
+	s.Handlers.UnmarshalError.PushBack(unmarshalError)
+	s.Handlers.Send.Clear() // mock sending
+	s.Handlers.Send.PushBack(send)
+	return s
+}
+
+func TestRetryBeforeDeadline(t *testing.T) {
+	cases := map[string]struct {
+		Timeout time.Duration
+		Options []request.Option
+		Retries int
+		Code    string
+	}{
+		"no deadline": {
+			Retries: 2,
+		},
+		"context deadline": {
+			Timeout: time.Second,
+			Code:    request.ErrCodeRetryDeadlineExceeded,
+		},
+		"operation timeout": {
+			Options: []request.Option{request.WithOperationTimeout(time.Second)},
+			Code:    request.ErrCodeRetryDeadlineExceeded,
+		},
+		"operation timeout long enough": {
+			Options: []request.Option{request.WithOperationTimeout(time.Hour)},
+			Retries: 2,
+		},
+	}
+
+	for name, c := range cases {
+		t.Run(name, func(t *testing.T) {
+			s := newDeadlineClient(&aws.Config{
+				Retryer: client.DefaultRetryer{
+					NumMaxRetries: 2,
+					MinRetryDelay: 5 * time.Second,
+					MaxRetryDelay: 5 * time.Second,
+				},
+				SleepDelay: func(time.Duration) {},
+			}, func(r *request.Request) {
+				r.HTTPResponse = &http.Response{
+					StatusCode: 500,
+					Body:       body(`{"__type":"UnknownError","message":"An error occurred."}`),
+				}
+			})
+
+			r := s.NewRequest(&request.Operation{Name: "Operation"}, nil, &testData{})
+			if c.Timeout > 0 {
+				ctx, cancel := context.WithTimeout(context.Background(), c.Timeout)
+				defer cancel()
+				r.SetContext(ctx)
+			}
+			r.ApplyOptions(c.Options...)
+			err := r.Send()
+
+			aerr, ok := err.(awserr.Error)
+			if !ok {
+				t.Fatalf("expect awserr.Error, got %v", err)
+			}
+			if c.Code != "" && aerr.Code() != c.Code {
+				t.Errorf("expect %s error, got %v", c.Code, err)
+			}
+			if e, a := c.Retries, r.RetryCount; e != a {
+				t.Errorf("expect %d retries, got %d", e, a)
+			}
+		})
+	}
+}
+
+func TestOperationTimeout(t *testing.T) {
+	s := newDeadlineClient(&aws.Config{
+		Retryer: client.DefaultRetryer{
+			NumMaxRetries: 10,
+			MinRetryDelay: 5 * time.Second,
+			MaxRetryDelay: 5 * time.Second,
+		},
+		OperationTimeout: aws.NewConfig().WithOperationTimeout(50 * time.Millisecond).OperationTimeout,
+		SleepDelay:       func(time.Duration) {},
+	}, func(r *request.Request) {
+		<-r.HTTPRequest.Context().Done()
+		r.Error = awserr.New(request.ErrCodeRequestError, "send request failed",
+			r.HTTPRequest.Context().Err())
+	})
+
+	start := time.Now()
+	r := s.NewRequest(&request.Operation{Name: "Operation"}, nil, &testData{})
+	err := r.Send()
+	if aerr, ok := err.(awserr.Error); !ok || aerr.Code() != request.ErrCodeRetryDeadlineExceeded {
+		t.Fatalf("expect %s error, got %v", request.ErrCodeRetryDeadlineExceeded, err)
+	}
+	if elapsed := time.Since(start); elapsed > time.Second {
+		t.Errorf("expect the request to end at its deadline, took %v", elapsed)
+	}
+	if e, a := 0, r.RetryCount; e != a {
+		t.Errorf("expect %d retries, got %d", e, a)
+	}
+}
+
+func TestOperationTimeoutAttemptShare(t *testing.T) {
+	var attempts int
+	s := newDeadlineClient(&aws.Config{
+		Retryer: client.DefaultRetryer{
+			NumMaxRetries: 3,
+			MinRetryDelay: time.Nanosecond,
+			MaxRetryDelay: time.Nanosecond,
+		},
+		OperationTimeout: aws.NewConfig().WithOperationTimeout(2 * time.Second).OperationTimeout,
+		SleepDelay:       func(time.Duration) {},
+	}, func(r *request.Request) {
+		attempts++
+		if attempts == 1 {
+			// hangs until the attempt is stopped
+			<-r.HTTPRequest.Context().Done()
+			r.Error = awserr.New(request.ErrCodeRequestError, "send request failed",
+				r.HTTPRequest.Context().Err())
+			return
+		}
+		if err := r.HTTPRequest.Context().Err(); err != nil {
+			t.Errorf("expect the retry sent with a live context, got %v", err)
+		}
+		r.HTTPResponse = &http.Response{
+			StatusCode: 200,
+			Body:       body(`{"data":"valid"}`),
+		}
+	})
+
+	start := time.Now()
+	r := s.NewRequest(&request.Operation{Name: "Operation"}, nil, &testData{})
+	if err := r.Send(); err != nil {
+		t.Fatalf("expect no error, got %v", err)
+	}
+	// the first of the 4 attempts has a quarter of the budget
+	if elapsed := time.Since(start); elapsed < 400*time.Millisecond || elapsed > time.Second {
+		t.Errorf("expect the hung attempt stopped after its share, took %v", elapsed)
+	}
+	if e, a := 1, r.RetryCount; e != a {
+		t.Errorf("expect %d retries, got %d", e, a)
+	}
+	r.HTTPResponse.Body.Close()
+}
+
+func TestOperationTimeoutResponseBody(t *testing.T) {
+	svc := s3.New(unit.Session, aws.NewConfig().WithOperationTimeout(time.Hour))
+	svc.Handlers.Send.Clear() // mock sending
+	svc.Handlers.Send.PushBack(func(r *request.Request) {
+		r.HTTPResponse = &http.Response{
+			StatusCode: 200,
+			Header:     http.Header{},
+			Body:       ioutil.NopCloser(bytes.NewBufferString("content")),
+		}
+	})
+
+	// the budget covers the read of a streaming body
+	get, out := svc.GetObjectRequest(&s3.GetObjectInput{
+		Bucket: aws.String("bucket"), Key: aws.String("key"),
+	})
+	if err := get.Send(); err != nil {
+		t.Fatalf("expect no error, got %v", err)
+	}
+	ctx := get.Context()
+	if err := ctx.Err(); err != nil {
+		t.Fatalf("expect the context alive until the body is closed, got %v", err)
+	}
+	out.Body.Close()
+	if e, a := context.Canceled, ctx.Err(); e != a {
+		t.Errorf("expect %v once the body is closed, got %v", e, a)
+	}
+
+	// a body consumed by the unmarshalers releases it at once
+	head, _ := svc.HeadObjectRequest(&s3.HeadObjectInput{
+		Bucket: aws.String("bucket"), Key: aws.String("key"),
+	})
+	if err := head.Send(); err != nil {
+		t.Fatalf("expect no error, got %v", err)
+	}
+	if e, a := context.Canceled, head.Context().Err(); e != a {
+		t.Errorf("expect %v once the body is consumed, got %v", e, a)
+	}
+}
@@ -0,0 +1,41 @@
+package request
+
+import (
+	"github.com/aws/aws-sdk-go/aws/endpoints"
+)
+
+// WithEndpointAffinity is a request option pinning the request to the
+// endpoint of affinity. Requests sharing the affinity, such as the parts of
+// one multipart upload, are sent to the same endpoint of Config.CEndpoint.
+// They only move to another endpoint when the request fails over, after
+// the endpoint failed.
+//
+//     affinity := endpoints.NewAffinity()
+//     svc.UploadPartWithContext(ctx, params, request.WithEndpointAffinity(affinity))
+func WithEndpointAffinity(affinity *endpoints.Affinity) Option {
+	return func(r *Request) {
+		r.Affinity = affinity
+		if affinity == nil || r.CEndpoint == nil {
+			return
+		}
+
+		endpoint := affinity.Select(r.CEndpoint, r.Config.BalancePolicy)
+		if endpoint == nil || endpoint == r.Endpoint {
+			return
+		}
+		r.Endpoint = endpoint
+		if r.HTTPRequest != nil && r.HTTPRequest.URL != nil {
+			updateURL(r.HTTPRequest.URL, endpoint)
+		}
+	}
+}
+
+// selectRetryEndpoint returns the endpoint a request fails over to, after
+// its endpoint failed
+func (r *Request) selectRetryEndpoint() *endpoints.SingleEndpoint {
+	if r.Affinity != nil {
+		r.Affinity.Failover(r.Endpoint)
+		return r.Affinity.Select(r.CEndpoint, r.Config.BalancePolicy)
+	}
+	return r.CEndpoint.SelectEndpointByKey(paramsObjectKey(r.Params), r.Config.BalancePolicy, r.Endpoint)
+}
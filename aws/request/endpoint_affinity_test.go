@@ -0,0 +1,82 @@
+package request
+
+import (
+	"fmt"
+	"net/http"
+	"net/url"
+	"testing"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/aws/awserr"
+	"github.com/aws/aws-sdk-go/aws/client/metadata"
+	"github.com/aws/aws-sdk-go/aws/endpoints"
+)
+
+func TestWithEndpointAffinity(t *testing.T) {
+	path, cleanup := writeTestEndpoints(t,
+		"http://abc1.test:8080", "http://abc2.test:8080", "http://abc3.test:8080")
+	defer cleanup()
+
+	coll, err := endpoints.NewEndpointCollection(path, 60)
+	if err != nil {
+		t.Fatalf("expect no error, got %v", err)
+	}
+	defer coll.Close()
+
+	cfg := aws.Config{CEndpoint: coll, MaxNetworkErrorRetries: aws.Int(0)}
+	op := &Operation{Name: "UploadPart", HTTPMethod: "PUT", HTTPPath: "/"}
+	affinity := endpoints.NewAffinity()
+	newRequest := func() *Request {
+		r := New(cfg, metadata.ClientInfo{}, Handlers{}, nil, op, nil, nil)
+		r.ApplyOptions(WithEndpointAffinity(affinity))
+		if r.Error != nil {
+			t.Fatalf("expect no error, got %v", r.Error)
+		}
+		return r
+	}
+
+	first := newRequest()
+	pinned := affinity.Endpoint()
+	if e, a := pinned, first.Endpoint; e != a {
+		t.Fatalf("expect %s, got %s", e.URL, a.URL)
+	}
+	for i := 0; i < 5; i++ {
+		r := newRequest()
+		if e, a := pinned.URL, r.Endpoint.URL; e != a {
+			t.Errorf("%d expect %s, got %s", i, e, a)
+		}
+		if e, a := pinned.URL, r.HTTPRequest.URL.Scheme+"://"+r.HTTPRequest.URL.Host; e != a {
+			t.Errorf("%d expect request URL %s, got %s", i, e, a)
+		}
+	}
+
+	// a client error does not move the operation
+	first.Error = awserr.New("NoSuchUpload", "no such upload", nil)
+	first.HTTPResponse = &http.Response{StatusCode: 404}
+	if first.ShouldNetworkErrorRetry() {
+		t.Errorf("expect no endpoint retry")
+	}
+	if e, a := pinned, affinity.Endpoint(); e != a {
+		t.Errorf("expect %s, got %s", e.URL, a.URL)
+	}
+
+	// an endpoint failure moves it, for the requests to come too
+	first.Error = awserr.New(ErrCodeRequestError, "send request failed", &url.Error{
+		URL: first.Endpoint.URL,
+		Err: fmt.Errorf("connection refused"),
+	})
+	first.HTTPResponse = nil
+	if !first.ShouldNetworkErrorRetry() {
+		t.Fatalf("expect endpoint retry")
+	}
+	moved := affinity.Endpoint()
+	if moved == nil || moved == pinned {
+		t.Fatalf("expect another endpoint than %s", pinned.URL)
+	}
+	if e, a := moved, first.Endpoint; e != a {
+		t.Errorf("expect %s, got %s", e.URL, a.URL)
+	}
+	if e, a := moved.URL, newRequest().Endpoint.URL; e != a {
+		t.Errorf("expect %s, got %s", e, a)
+	}
+}
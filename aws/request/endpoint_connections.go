@@ -0,0 +1,94 @@
+package request
+
+import (
+	"net/http"
+	"time"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/aws/awserr"
+	"github.com/aws/aws-sdk-go/aws/endpoints"
+)
+
+// acquireEndpoint marks the start of the attempt on endpoint, waiting in the
+// queue of the endpoint while it is saturated
+func (r *Request) acquireEndpoint(endpoint *endpoints.SingleEndpoint) error {
+	if r.CEndpoint == nil {
+		endpoint.Acquire()
+		return nil
+	}
+	if err := r.CEndpoint.AcquireEndpoint(r.Context(), endpoint); err != nil {
+		return awserr.New(CanceledErrorCode,
+			"request context canceled while waiting for a connection", err)
+	}
+	return nil
+}
+
+// releaseEndpoint marks the end of an attempt started with acquireEndpoint
+func (r *Request) releaseEndpoint(endpoint *endpoints.SingleEndpoint) {
+	if r.CEndpoint == nil {
+		endpoint.Release()
+		return
+	}
+	r.CEndpoint.ReleaseEndpoint(endpoint)
+}
+
+// HTTPClient returns the client the request is sent with. Requests sent to
+// an endpoint of Config.CEndpoint use the connections of that endpoint,
+// derived from Config.HTTPClient and bounded by Config.AttemptTimeouts, see
+// endpoints.EndpointCollection.HTTPClient.
+func (r *Request) HTTPClient() *http.Client {
+	if r.CEndpoint == nil || r.Endpoint == nil {
+		return r.Config.HTTPClient
+	}
+	return r.CEndpoint.HTTPClient(r.Endpoint, r.Config.HTTPClient, r.attemptTimeouts())
+}
+
+// attemptTimeouts returns Config.AttemptTimeouts, zero if not set
+func (r *Request) attemptTimeouts() endpoints.AttemptTimeouts {
+	if r.Config.AttemptTimeouts == nil {
+		return endpoints.AttemptTimeouts{}
+	}
+	return *r.Config.AttemptTimeouts
+}
+
+// isAttemptTimeout returns whether the attempt failed on one of
+// Config.AttemptTimeouts, or on its share of Config.OperationTimeout
+func (r *Request) isAttemptTimeout() bool {
+	return (r.Config.AttemptTimeouts != nil || r.Config.OperationTimeout != nil) &&
+		isErrTimeout(r.Error)
+}
+
+// sendAttempt runs the Send handlers, bounding the wait for the response by
+// the share of the attempt of the operation timeout, and the reads of the
+// response body by the ReadIdle timeout
+func (r *Request) sendAttempt() {
+	done := r.boundAttempt()
+	r.Handlers.Send.Run(r)
+	done()
+	if idle := r.attemptTimeouts().ReadIdle; idle > 0 && r.Error == nil &&
+		r.HTTPResponse != nil && r.HTTPResponse.Body != nil {
+
+		r.HTTPResponse.Body = &timeoutReadCloser{reader: r.HTTPResponse.Body, duration: idle}
+	}
+}
+
+// sendOnEndpoint runs the Send handlers of the attempt, once the endpoint
+// of the request let it through, and sets SendTime to that time. Returns the
+// function ending the attempt on the endpoint.
+func (r *Request) sendOnEndpoint() (release func()) {
+	r.SendTime = time.Time{}
+	endpoint := r.Endpoint
+	if endpoint == nil {
+		r.SendTime = time.Now()
+		r.sendAttempt()
+		return func() {}
+	}
+	if err := r.acquireEndpoint(endpoint); err != nil {
+		r.Error = err
+		r.Retryable = aws.Bool(false)
+		return func() {}
+	}
+	r.SendTime = time.Now()
+	r.sendAttempt()
+	return func() { r.releaseEndpoint(endpoint) }
+}
@@ -0,0 +1,189 @@
+// +build go1.7
+
+package request
+
+import (
+	"context"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/aws/awserr"
+	"github.com/aws/aws-sdk-go/aws/client/metadata"
+	"github.com/aws/aws-sdk-go/aws/endpoints"
+)
+
+func TestSendQueuedOnSaturatedEndpoint(t *testing.T) {
+	unblock := make(chan struct{})
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		<-unblock
+	}))
+	defer server.Close()
+
+	coll, err := endpoints.NewEndpointCollectionFromList([]string{server.URL}, 60,
+		func(o *endpoints.CollectionOptions) {
+			o.MaxConnectionsPerEndpoint = 1
+		})
+	if err != nil {
+		t.Fatalf("expect no error, got %v", err)
+	}
+	defer coll.Close()
+
+	base := &http.Client{Transport: &http.Transport{}}
+	var handlers Handlers
+	handlers.Send.PushBack(func(r *Request) {
+		client := r.HTTPClient()
+		if client == base {
+			t.Errorf("expect the client of the endpoint")
+		}
+		var err error
+		if r.HTTPResponse, err = client.Do(r.HTTPRequest); err != nil {
+			r.Error = awserr.New(ErrCodeRequestError, "send request failed", err)
+		}
+	})
+
+	cfg := aws.Config{CEndpoint: coll, HTTPClient: base}
+	op := &Operation{Name: "GetObject", HTTPMethod: "GET", HTTPPath: "/bucket/key"}
+
+	first := New(cfg, metadata.ClientInfo{}, handlers, nil, op, nil, nil)
+	sent := make(chan error)
+	go func() {
+		sent <- first.Send()
+	}()
+	for deadline := time.Now().Add(time.Second); first.Endpoint.Inflight() == 0; {
+		if time.Now().After(deadline) {
+			t.Fatalf("expect the first request in flight")
+		}
+		time.Sleep(time.Millisecond)
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 20*time.Millisecond)
+	defer cancel()
+	second := New(cfg, metadata.ClientInfo{}, handlers, nil, op, nil, nil)
+	second.SetContext(ctx)
+	err = second.Send()
+	if aerr, ok := err.(awserr.Error); !ok || aerr.Code() != CanceledErrorCode {
+		t.Errorf("expect %s, got %v", CanceledErrorCode, err)
+	}
+	if !second.SendTime.IsZero() {
+		t.Errorf("expect no send time for a request left in the queue")
+	}
+
+	close(unblock)
+	if err := <-sent; err != nil {
+		t.Fatalf("expect no error, got %v", err)
+	}
+	first.HTTPResponse.Body.Close()
+	if first.SendTime.Before(first.AttemptTime) {
+		t.Errorf("expect send time %v after attempt time %v", first.SendTime, first.AttemptTime)
+	}
+	if e, a := int64(0), first.Endpoint.Inflight(); e != a {
+		t.Errorf("expect %d in flight, got %d", e, a)
+	}
+}
+
+type retryTwice struct{ noOpRetryer }
+
+func (retryTwice) MaxRetries() int { return 2 }
+
+func TestAttemptTimeoutFailover(t *testing.T) {
+	unblock := make(chan struct{})
+	hung := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		<-unblock
+	}))
+	defer hung.Close()
+	idle := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(200)
+		w.(http.Flusher).Flush()
+		<-unblock
+	}))
+	defer idle.Close()
+	ok := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Write([]byte("ok"))
+	}))
+	defer ok.Close()
+	defer close(unblock)
+
+	cases := map[string]struct {
+		Timeouts endpoints.AttemptTimeouts
+		Failing  string
+	}{
+		"first byte": {
+			Timeouts: endpoints.AttemptTimeouts{FirstByte: 50 * time.Millisecond},
+			Failing:  hung.URL,
+		},
+		"read idle": {
+			Timeouts: endpoints.AttemptTimeouts{ReadIdle: 50 * time.Millisecond},
+			Failing:  idle.URL,
+		},
+	}
+
+	for name, c := range cases {
+		t.Run(name, func(t *testing.T) {
+			coll, err := endpoints.NewEndpointCollectionFromList([]string{c.Failing, ok.URL}, 60)
+			if err != nil {
+				t.Fatalf("expect no error, got %v", err)
+			}
+			defer coll.Close()
+
+			var handlers Handlers
+			handlers.Send.PushBack(func(r *Request) {
+				var err error
+				if r.HTTPResponse, err = r.HTTPClient().Do(r.HTTPRequest); err != nil {
+					r.Error = awserr.New(ErrCodeRequestError, "send request failed", err)
+				}
+			})
+			handlers.Unmarshal.PushBack(func(r *Request) {
+				defer r.HTTPResponse.Body.Close()
+				if _, err := ioutil.ReadAll(r.HTTPResponse.Body); err != nil {
+					r.Error = awserr.New(ErrCodeSerialization, "failed to read body", err)
+				}
+			})
+			handlers.AfterRetry.PushBack(func(r *Request) {
+				if r.ShouldNetworkErrorRetry() {
+					r.Retryable = aws.Bool(true)
+				}
+				if r.WillRetry() {
+					r.RetryCount++
+					r.Error = nil
+				}
+			})
+
+			cfg := aws.Config{
+				CEndpoint:              coll,
+				HTTPClient:             &http.Client{Transport: &http.Transport{}},
+				MaxNetworkErrorRetries: aws.Int(aws.DefaultMaxNetworkErrorRetries),
+				AttemptTimeouts:        &c.Timeouts,
+			}
+			op := &Operation{Name: "GetObject", HTTPMethod: "GET", HTTPPath: "/bucket/key"}
+
+			for sent := false; !sent; {
+				r := New(cfg, metadata.ClientInfo{}, handlers, retryTwice{}, op, nil, nil)
+				if r.Endpoint.URL != c.Failing {
+					continue
+				}
+				sent = true
+				failing := r.Endpoint
+
+				// fails over at once without blacklisting, with the default
+				// MaxNetworkErrorRetries
+				if err := r.Send(); err != nil {
+					t.Fatalf("expect no error, got %v", err)
+				}
+				if e, a := ok.URL, r.Endpoint.URL; e != a {
+					t.Errorf("expect the request retried on %s, got %s", e, a)
+				}
+				if e, a := 1, r.RetryCount; e != a {
+					t.Errorf("expect %d retry, got %d", e, a)
+				}
+				// a single timeout does not blacklist the endpoint
+				if failing.IsInBlackList {
+					t.Errorf("expect %s not blacklisted", failing.URL)
+				}
+			}
+		})
+	}
+}
@@ -0,0 +1,134 @@
+package request
+
+import (
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/aws/awserr"
+)
+
+// EndpointErrorClassifier decides whether the failure of a request attempt
+// is caused by the endpoint of Config.CEndpoint the attempt was sent to.
+// Endpoint errors count against the health of the endpoint, may blacklist
+// it, and make the request retry on another endpoint.
+//
+// Network errors are always endpoint errors, the classifier is only asked
+// about the other failures.
+type EndpointErrorClassifier interface {
+	IsEndpointError(*Request) bool
+}
+
+// EndpointErrorClassifierFunc is an EndpointErrorClassifier implemented by
+// a function.
+type EndpointErrorClassifierFunc func(*Request) bool
+
+// IsEndpointError calls fn.
+func (fn EndpointErrorClassifierFunc) IsEndpointError(r *Request) bool {
+	return fn(r)
+}
+
+// EndpointErrorClasses is an EndpointErrorClassifier built from classes of
+// errors.
+type EndpointErrorClasses struct {
+	// 500, 502, 503 and 504 responses, such as InternalError. 501 Not
+	// Implemented is never an endpoint error, and a 503 SlowDown belongs to
+	// Throttling: the cluster is overloaded rather than the endpoint.
+	ServerErrors bool
+
+	// Throttling responses, such as 429 responses, S3 SlowDown or the
+	// error codes of Request.IsErrorThrottle.
+	Throttling bool
+
+	// Responses not received in time, such as ResponseTimeout errors or
+	// network timeouts.
+	Timeouts bool
+
+	// Additional API error codes which are endpoint errors.
+	ErrorCodes []string
+}
+
+// DefaultEndpointErrorClasses is the classifier used when
+// Config.EndpointErrorClassifier is not set. Timeouts count against the
+// endpoint, server errors and throttling do not: with the default
+// MaxNetworkErrorRetries and circuit breaker a single endpoint error
+// blacklists the endpoint, so that errors returned by every endpoint of an
+// overloaded cluster would empty the collection.
+var DefaultEndpointErrorClasses = EndpointErrorClasses{
+	Timeouts: true,
+}
+
+// slowDownCodes are the error codes of the servers throttling the requests
+// with a 503 response
+var slowDownCodes = []string{"SlowDown"}
+
+// IsEndpointError returns true if the error of r belongs to one of the
+// classes.
+func (c EndpointErrorClasses) IsEndpointError(r *Request) bool {
+	if r.Error == nil {
+		return false
+	}
+
+	if isErrCode(r.Error, c.ErrorCodes) {
+		return true
+	}
+
+	slowDown := isErrCode(r.Error, slowDownCodes)
+	if c.Throttling && (slowDown || r.IsErrorThrottle()) {
+		return true
+	}
+
+	if c.ServerErrors && !slowDown && r.HTTPResponse != nil {
+		switch r.HTTPResponse.StatusCode {
+		case 500, 502, 503, 504:
+			return true
+		}
+	}
+
+	if c.Timeouts && isErrTimeout(r.Error) {
+		return true
+	}
+
+	return false
+}
+
+// WithEndpointErrorClassifier sets a EndpointErrorClassifier value to the
+// given Config returning the Config value for chaining.
+func WithEndpointErrorClassifier(cfg *aws.Config, classifier EndpointErrorClassifier) *aws.Config {
+	cfg.EndpointErrorClassifier = classifier
+	return cfg
+}
+
+// IsEndpointError returns whether the error of the request attempt is caused
+// by the endpoint it was sent to. Canceled requests are never endpoint
+// errors.
+func (r *Request) IsEndpointError() bool {
+	if r.Error == nil {
+		return false
+	}
+	if aerr, ok := r.Error.(awserr.Error); ok && aerr.Code() == CanceledErrorCode {
+		return false
+	}
+
+	if IsNetworkError(r.Error) || r.isAttemptTimeout() {
+		return true
+	}
+
+	classifier, ok := r.Config.EndpointErrorClassifier.(EndpointErrorClassifier)
+	if !ok || classifier == nil {
+		classifier = DefaultEndpointErrorClasses
+	}
+	return classifier.IsEndpointError(r)
+}
+
+func isErrTimeout(err error) bool {
+	switch err := err.(type) {
+	case awserr.Error:
+		if err.Code() == ErrCodeResponseTimeout {
+			return true
+		}
+		if origErr := err.OrigErr(); origErr != nil {
+			return isErrTimeout(origErr)
+		}
+	case interface{ Timeout() bool }:
+		return err.Timeout()
+	}
+	return false
+}
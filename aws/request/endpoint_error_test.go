@@ -0,0 +1,398 @@
+package request
+
+import (
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"net/url"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/aws/awserr"
+	"github.com/aws/aws-sdk-go/aws/client/metadata"
+	"github.com/aws/aws-sdk-go/aws/endpoints"
+)
+
+// writes the endpoints into a temporary file
+func writeTestEndpoints(t *testing.T, urls ...string) (string, func()) {
+	dir, err := ioutil.TempDir("", "aws-sdk-go-request-endpoints")
+	if err != nil {
+		t.Fatalf("expect no error, got %v", err)
+	}
+
+	path := filepath.Join(dir, "endpoints")
+	if err := ioutil.WriteFile(path, []byte(strings.Join(urls, "\n")), 0644); err != nil {
+		os.RemoveAll(dir)
+		t.Fatalf("expect no error, got %v", err)
+	}
+	return path, func() { os.RemoveAll(dir) }
+}
+
+type timeoutError struct{}
+
+func (timeoutError) Error() string   { return "i/o timeout" }
+func (timeoutError) Timeout() bool   { return true }
+func (timeoutError) Temporary() bool { return false }
+
+func TestIsEndpointError(t *testing.T) {
+	cases := map[string]struct {
+		Classifier aws.EndpointErrorClassifier
+		Err        error
+		StatusCode int
+		Expect     bool
+	}{
+		"no error": {
+			StatusCode: 200,
+		},
+		"network error": {
+			Err: &url.Error{
+				URL: "http://127.0.0.1:8080",
+				Err: fmt.Errorf("connection refused"),
+			},
+			Expect: true,
+		},
+		"canceled": {
+			Err: awserr.New(CanceledErrorCode, "canceled", &url.Error{
+				URL: "http://127.0.0.1:8080",
+				Err: fmt.Errorf("connection refused"),
+			}),
+		},
+		"internal error by default": {
+			Err:        awserr.New("InternalError", "internal error", nil),
+			StatusCode: 500,
+		},
+		"slow down by default": {
+			Err:        awserr.New("SlowDown", "slow down", nil),
+			StatusCode: 503,
+		},
+		"internal error": {
+			Classifier: EndpointErrorClasses{ServerErrors: true},
+			Err:        awserr.New("InternalError", "internal error", nil),
+			StatusCode: 500,
+			Expect:     true,
+		},
+		"service unavailable": {
+			Classifier: EndpointErrorClasses{ServerErrors: true},
+			Err:        awserr.New("ServiceUnavailable", "service unavailable", nil),
+			StatusCode: 503,
+			Expect:     true,
+		},
+		"slow down is not a server error": {
+			Classifier: EndpointErrorClasses{ServerErrors: true},
+			Err:        awserr.New("SlowDown", "slow down", nil),
+			StatusCode: 503,
+		},
+		"slow down": {
+			Classifier: EndpointErrorClasses{Throttling: true},
+			Err:        awserr.New("SlowDown", "slow down", nil),
+			StatusCode: 503,
+			Expect:     true,
+		},
+		"not implemented": {
+			Classifier: EndpointErrorClasses{ServerErrors: true},
+			Err:        awserr.New("NotImplemented", "not implemented", nil),
+			StatusCode: 501,
+		},
+		"not found": {
+			Err:        awserr.New("NoSuchKey", "not found", nil),
+			StatusCode: 404,
+		},
+		"throttled by default": {
+			Err:        awserr.New("TooManyRequestsException", "too many requests", nil),
+			StatusCode: 429,
+		},
+		"throttled": {
+			Classifier: EndpointErrorClasses{Throttling: true},
+			Err:        awserr.New("TooManyRequestsException", "too many requests", nil),
+			StatusCode: 429,
+			Expect:     true,
+		},
+		"response timeout": {
+			Err:    awserr.New(ErrCodeResponseTimeout, "read timeout", nil),
+			Expect: true,
+		},
+		"wrapped timeout": {
+			Err:    awserr.New(ErrCodeRead, "read failed", timeoutError{}),
+			Expect: true,
+		},
+		"timeouts disabled": {
+			Classifier: EndpointErrorClasses{},
+			Err:        awserr.New(ErrCodeRead, "read failed", timeoutError{}),
+		},
+		"error code": {
+			Classifier: EndpointErrorClasses{ErrorCodes: []string{"AccessDenied"}},
+			Err:        awserr.New("AccessDenied", "access denied", nil),
+			StatusCode: 403,
+			Expect:     true,
+		},
+		"classifier func": {
+			Classifier: EndpointErrorClassifierFunc(func(r *Request) bool {
+				return r.HTTPResponse.StatusCode == 403
+			}),
+			Err:        awserr.New("AccessDenied", "access denied", nil),
+			StatusCode: 403,
+			Expect:     true,
+		},
+		"invalid classifier": {
+			Classifier: "not a classifier",
+			Err:        awserr.New(ErrCodeRead, "read failed", timeoutError{}),
+			Expect:     true,
+		},
+	}
+
+	for name, c := range cases {
+		t.Run(name, func(t *testing.T) {
+			r := &Request{Error: c.Err}
+			r.Config.EndpointErrorClassifier = c.Classifier
+			if c.StatusCode != 0 {
+				r.HTTPResponse = &http.Response{StatusCode: c.StatusCode}
+			}
+			if e, a := c.Expect, r.IsEndpointError(); e != a {
+				t.Errorf("expect %v, got %v", e, a)
+			}
+		})
+	}
+}
+
+func TestShouldNetworkErrorRetryServerError(t *testing.T) {
+	path, cleanup := writeTestEndpoints(t, "http://abc1.test:8080", "http://abc2.test:8080")
+	defer cleanup()
+
+	coll, err := endpoints.NewEndpointCollection(path, 60)
+	if err != nil {
+		t.Fatalf("expect no error, got %v", err)
+	}
+	defer coll.Close()
+
+	endpoint := coll.SelectEndpoint(endpoints.NewRoundRobinPolicy())
+	r := &Request{
+		Error:        awserr.New("InternalError", "internal error", nil),
+		HTTPResponse: &http.Response{StatusCode: 500},
+		Endpoint:     endpoint,
+		CEndpoint:    coll,
+	}
+	r.Config.CEndpoint = coll
+	r.Config.MaxNetworkErrorRetries = aws.Int(0)
+
+	if r.ShouldNetworkErrorRetry() {
+		t.Errorf("expect no endpoint retry, server errors are not classified by default")
+	}
+
+	r.Config.EndpointErrorClassifier = EndpointErrorClasses{ServerErrors: true}
+	if !r.ShouldNetworkErrorRetry() {
+		t.Fatalf("expect retry")
+	}
+	if !aws.BoolValue(r.NetWorkErrorRetry) {
+		t.Errorf("expect retry on another endpoint")
+	}
+	if r.Endpoint == endpoint {
+		t.Errorf("expect endpoint %s replaced", endpoint.URL)
+	}
+	if !endpoint.IsInBlackList {
+		t.Errorf("expect endpoint %s blacklisted", endpoint.URL)
+	}
+}
+
+func TestClusterWideServerErrorsKeepEndpoints(t *testing.T) {
+	path, cleanup := writeTestEndpoints(t,
+		"http://abc1.test:8080", "http://abc2.test:8080", "http://abc3.test:8080")
+	defer cleanup()
+
+	coll, err := endpoints.NewEndpointCollection(path, 60)
+	if err != nil {
+		t.Fatalf("expect no error, got %v", err)
+	}
+	defer coll.Close()
+
+	cases := map[string]struct {
+		Classifier aws.EndpointErrorClassifier
+		Err        error
+		StatusCode int
+	}{
+		"slow down": {
+			Err:        awserr.New("SlowDown", "slow down", nil),
+			StatusCode: 503,
+		},
+		"service unavailable": {
+			Err:        awserr.New("ServiceUnavailable", "service unavailable", nil),
+			StatusCode: 503,
+		},
+		"internal error": {
+			Err:        awserr.New("InternalError", "internal error", nil),
+			StatusCode: 500,
+		},
+		"slow down with server errors": {
+			Classifier: EndpointErrorClasses{ServerErrors: true, Timeouts: true},
+			Err:        awserr.New("SlowDown", "slow down", nil),
+			StatusCode: 503,
+		},
+	}
+
+	for name, c := range cases {
+		// every endpoint of the cluster answers every request with the error
+		for i := 0; i < 10; i++ {
+			r := &Request{
+				Error:        c.Err,
+				HTTPResponse: &http.Response{StatusCode: c.StatusCode},
+				Endpoint:     coll.SelectEndpoint(endpoints.NewRoundRobinPolicy()),
+				CEndpoint:    coll,
+			}
+			if r.Endpoint == nil {
+				t.Fatalf("%s expect an endpoint, the collection is empty", name)
+			}
+			r.Config.CEndpoint = coll
+			r.Config.MaxNetworkErrorRetries = aws.Int(aws.DefaultMaxNetworkErrorRetries)
+			r.Config.EndpointErrorClassifier = c.Classifier
+			r.ShouldNetworkErrorRetry()
+		}
+		if e, a := 3, coll.NumActiveEndpoints(); e != a {
+			t.Errorf("%s expect %d active endpoints, got %d", name, e, a)
+		}
+	}
+}
+
+func TestNewRoutesToEndpointPool(t *testing.T) {
+	hotPath, cleanupHot := writeTestEndpoints(t, "http://hot.test:8080")
+	defer cleanupHot()
+	coldPath, cleanupCold := writeTestEndpoints(t, "http://cold.test:8080")
+	defer cleanupCold()
+
+	hot, err := endpoints.NewEndpointCollection(hotPath, 100)
+	if err != nil {
+		t.Fatalf("expect no error, got %v", err)
+	}
+	defer hot.Close()
+	cold, err := endpoints.NewEndpointCollection(coldPath, 100)
+	if err != nil {
+		t.Fatalf("expect no error, got %v", err)
+	}
+	defer cold.Close()
+
+	pools, err := endpoints.NewEndpointPools(
+		map[string]*endpoints.EndpointCollection{"cold": cold},
+		[]endpoints.PoolRoute{{Pool: "cold", Buckets: []string{"cold-*"}}},
+	)
+	if err != nil {
+		t.Fatalf("expect no error, got %v", err)
+	}
+
+	type input struct {
+		Bucket *string
+	}
+	cfg := aws.Config{CEndpoint: hot, CEndpointPools: pools}
+	op := &Operation{Name: "GetObject", HTTPMethod: "GET", HTTPPath: "/{Bucket}"}
+
+	cases := map[string]struct {
+		Params interface{}
+		Expect *endpoints.EndpointCollection
+		URL    string
+	}{
+		"routed": {
+			Params: &input{Bucket: aws.String("cold-2020")},
+			Expect: cold,
+			URL:    "http://cold.test:8080",
+		},
+		"default": {
+			Params: &input{Bucket: aws.String("hot-2020")},
+			Expect: hot,
+			URL:    "http://hot.test:8080",
+		},
+		"no bucket": {
+			Params: &struct{}{},
+			Expect: hot,
+			URL:    "http://hot.test:8080",
+		},
+	}
+
+	for name, c := range cases {
+		r := New(cfg, metadata.ClientInfo{}, Handlers{}, nil, op, c.Params, nil)
+		if r.Error != nil {
+			t.Fatalf("%s expect no error, got %v", name, r.Error)
+		}
+		if e, a := c.Expect, r.CEndpoint; e != a {
+			t.Errorf("%s expect collection %p, got %p", name, e, a)
+		}
+		if e, a := c.Expect, r.Config.CEndpoint; e != a {
+			t.Errorf("%s expect config collection %p, got %p", name, e, a)
+		}
+		if e, a := c.URL, r.Endpoint.URL; e != a {
+			t.Errorf("%s expect %s, got %s", name, e, a)
+		}
+	}
+}
+
+func TestNewConsistentHash(t *testing.T) {
+	path, cleanup := writeTestEndpoints(t,
+		"http://abc1.test:8080", "http://abc2.test:8080", "http://abc3.test:8080")
+	defer cleanup()
+
+	coll, err := endpoints.NewEndpointCollection(path, 60, func(o *endpoints.CollectionOptions) {
+		o.ConsistentHash = true
+	})
+	if err != nil {
+		t.Fatalf("expect no error, got %v", err)
+	}
+	defer coll.Close()
+
+	type input struct {
+		Bucket *string
+		Key    *string
+	}
+	cfg := aws.Config{CEndpoint: coll, MaxNetworkErrorRetries: aws.Int(0)}
+	op := &Operation{Name: "GetObject", HTTPMethod: "GET", HTTPPath: "/{Bucket}/{Key+}"}
+	params := &input{Bucket: aws.String("bucket"), Key: aws.String("key")}
+
+	first := New(cfg, metadata.ClientInfo{}, Handlers{}, nil, op, params, nil)
+	if first.Error != nil {
+		t.Fatalf("expect no error, got %v", first.Error)
+	}
+	for i := 0; i < 10; i++ {
+		r := New(cfg, metadata.ClientInfo{}, Handlers{}, nil, op, params, nil)
+		if e, a := first.Endpoint, r.Endpoint; e != a {
+			t.Errorf("%d expect %s, got %s", i, e.URL, a.URL)
+		}
+	}
+
+	// a failed request moves to another endpoint, as do the next ones once
+	// the endpoint is blacklisted
+	preferred := first.Endpoint
+	first.Error = awserr.New(ErrCodeRequestError, "send request failed", &url.Error{
+		URL: first.Endpoint.URL,
+		Err: fmt.Errorf("connection refused"),
+	})
+	first.HTTPResponse = nil
+	if !first.ShouldNetworkErrorRetry() {
+		t.Fatalf("expect endpoint retry")
+	}
+	if first.Endpoint == preferred {
+		t.Errorf("expect endpoint %s replaced", preferred.URL)
+	}
+	r := New(cfg, metadata.ClientInfo{}, Handlers{}, nil, op, params, nil)
+	if e, a := first.Endpoint, r.Endpoint; e != a {
+		t.Errorf("expect %s, got %s", e.URL, a.URL)
+	}
+}
+
+func TestParamsObjectKey(t *testing.T) {
+	type input struct {
+		Bucket *string
+		Key    *string
+	}
+	cases := map[string]struct {
+		Params interface{}
+		Expect string
+	}{
+		"object":    {&input{Bucket: aws.String("bucket"), Key: aws.String("a/b")}, "bucket/a/b"},
+		"bucket":    {&input{Bucket: aws.String("bucket")}, "bucket/"},
+		"no bucket": {&input{Key: aws.String("a/b")}, ""},
+		"nil":       {nil, ""},
+	}
+	for name, c := range cases {
+		if e, a := c.Expect, paramsObjectKey(c.Params); e != a {
+			t.Errorf("%s expect %q, got %q", name, e, a)
+		}
+	}
+}
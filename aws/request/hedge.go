@@ -0,0 +1,234 @@
+// +build go1.7
+
+package request
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"io"
+	"time"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/aws/awserr"
+	"github.com/aws/aws-sdk-go/aws/endpoints"
+)
+
+// hedgeDelay returns the time after which the attempt is hedged, false if it
+// is not hedged. Only the reads of Config.Hedging sent to a collection with
+// another active endpoint are.
+func (r *Request) hedgeDelay() (time.Duration, bool) {
+	cfg := r.Config.Hedging
+	if cfg == nil || r.CEndpoint == nil || r.Endpoint == nil {
+		return 0, false
+	}
+	if method := r.HTTPRequest.Method; method != "GET" && method != "HEAD" {
+		return 0, false
+	}
+	if !cfg.Hedges(r.Operation.Name) || r.CEndpoint.NumActiveEndpoints() < 2 {
+		return 0, false
+	}
+	return r.CEndpoint.HedgeDelay(*cfg), true
+}
+
+// hedgeAttempt is one of the requests sent for a hedged attempt, each with
+// its own context
+type hedgeAttempt struct {
+	req     *Request
+	cancel  context.CancelFunc
+	release func()
+}
+
+// newHedgeAttempt returns a copy of the request to be sent to endpoint. The
+// copy is signed again when endpoint is not the endpoint of the request.
+func (r *Request) newHedgeAttempt(endpoint *endpoints.SingleEndpoint) (*hedgeAttempt, error) {
+	ctx, cancel := context.WithCancel(r.Context())
+	req := r.copy()
+	req.context = ctx
+	if endpoint == r.Endpoint {
+		req.HTTPRequest = r.HTTPRequest.WithContext(ctx)
+		return &hedgeAttempt{req: req, cancel: cancel}, nil
+	}
+
+	// hedged reads have no body, the copy gets its own empty one so that
+	// signing it does not read the body of the other request
+	req.Endpoint = endpoint
+	req.Body = bytes.NewReader(nil)
+	req.BodyStart = 0
+	req.safeBody, _ = newOffsetReader(req.Body, 0)
+	req.HTTPRequest = copyHTTPRequest(r.HTTPRequest, NoBody).WithContext(ctx)
+	if req.HTTPRequest.Host == stripPort(r.HTTPRequest.URL.Host) {
+		req.HTTPRequest.Host = ""
+	}
+	updateURL(req.HTTPRequest.URL, endpoint)
+	SanitizeHostForHeader(req.HTTPRequest)
+
+	req.Handlers.Sign.Run(req)
+	if req.Error != nil {
+		cancel()
+		return nil, req.Error
+	}
+	return &hedgeAttempt{req: req, cancel: cancel}, nil
+}
+
+// record records the attempt once it completed. Its latency feeds the hedge
+// delay whether its response is used or not, an attempt canceled for the
+// other one ran for at least that time. The CompleteAttempt handlers of the
+// request only see the used attempt, those of the copy are run here for an
+// attempt whose response is not used.
+func (a *hedgeAttempt) record(used bool) {
+	req := a.req
+	if req.SendTime.IsZero() {
+		// never let through by its endpoint
+		return
+	}
+	failed := req.IsEndpointError()
+	if !failed {
+		req.CEndpoint.RecordReadLatency(time.Since(req.SendTime))
+	}
+	if used {
+		return
+	}
+
+	req.Handlers.CompleteAttempt.Run(req)
+	if aerr, ok := req.Error.(awserr.Error); ok && aerr.Code() == CanceledErrorCode {
+		return
+	}
+	if failed {
+		req.CEndpoint.AddEndpointToBlacklist(req.Endpoint)
+	}
+}
+
+// discard releases an attempt whose response is not used
+func (a *hedgeAttempt) discard() {
+	a.cancel()
+	if resp := a.req.HTTPResponse; resp != nil && resp.Body != nil {
+		resp.Body.Close()
+	}
+	a.release()
+}
+
+// sendHedged runs the Send handlers of the attempt, and once more on another
+// endpoint of the collection if no response headers arrived within delay.
+// The first response is kept and the other request is canceled. Returns the
+// function releasing the endpoint the kept response came from.
+func (r *Request) sendHedged(delay time.Duration) (release func()) {
+	results := make(chan *hedgeAttempt, 2)
+	send := func(a *hedgeAttempt) {
+		go func() {
+			a.release = a.req.sendOnEndpoint()
+			results <- a
+		}()
+	}
+
+	primary, _ := r.newHedgeAttempt(r.Endpoint)
+	attempts := []*hedgeAttempt{primary}
+	send(primary)
+	pending := 1
+
+	timer := time.NewTimer(delay)
+	defer timer.Stop()
+
+	var winner *hedgeAttempt
+	for winner == nil {
+		select {
+		case a := <-results:
+			pending--
+			// an attempt failing to get a response waits for the other
+			if a.req.Error == nil || pending == 0 {
+				winner = a
+			} else {
+				a.record(false)
+				a.discard()
+			}
+		case <-timer.C:
+			if hedge := r.newHedge(); hedge != nil {
+				attempts = append(attempts, hedge)
+				send(hedge)
+				pending++
+			}
+		}
+	}
+
+	for _, a := range attempts {
+		if a != winner {
+			a.cancel()
+		}
+	}
+	if pending > 0 {
+		go func() {
+			loser := <-results
+			loser.record(false)
+			loser.discard()
+		}()
+	}
+
+	winner.record(true)
+	r.useHedgeAttempt(winner)
+	return winner.release
+}
+
+// newHedge returns the hedge of the attempt, sent to another endpoint than
+// the request. Nil if the request is canceled or has no other endpoint.
+func (r *Request) newHedge() *hedgeAttempt {
+	select {
+	case <-r.Context().Done():
+		return nil
+	default:
+	}
+
+	endpoint := r.CEndpoint.SelectEndpointByKey(paramsObjectKey(r.Params),
+		r.Config.BalancePolicy, r.Endpoint)
+	if endpoint == nil || endpoint == r.Endpoint {
+		return nil
+	}
+	hedge, err := r.newHedgeAttempt(endpoint)
+	if err != nil {
+		return nil
+	}
+
+	if r.Config.LogLevel.Matches(aws.LogDebugWithRequestRetries) {
+		r.Config.Logger.Log(fmt.Sprintf("DEBUG: Hedging Request %s/%s to %s",
+			r.ClientInfo.ServiceName, r.Operation.Name, endpoint.URL))
+	}
+	return hedge
+}
+
+// useHedgeAttempt makes the result of the attempt the result of the
+// request. The context of the attempt is canceled once its response body is
+// closed.
+func (r *Request) useHedgeAttempt(a *hedgeAttempt) {
+	r.Endpoint = a.req.Endpoint
+	r.SendTime = a.req.SendTime
+	r.HTTPRequest = a.req.HTTPRequest.WithContext(r.Context())
+	r.HTTPResponse = a.req.HTTPResponse
+	r.Error = a.req.Error
+	r.Retryable = a.req.Retryable
+
+	if resp := r.HTTPResponse; resp != nil && resp.Body != nil {
+		resp.Body = &cancelReadCloser{ReadCloser: resp.Body, cancel: a.cancel}
+	} else {
+		a.cancel()
+	}
+}
+
+// cancelReadCloser cancels the context of a response once its body is read
+// or closed
+type cancelReadCloser struct {
+	io.ReadCloser
+	cancel context.CancelFunc
+}
+
+func (c *cancelReadCloser) Read(p []byte) (int, error) {
+	n, err := c.ReadCloser.Read(p)
+	if err == io.EOF {
+		c.cancel()
+	}
+	return n, err
+}
+
+func (c *cancelReadCloser) Close() error {
+	err := c.ReadCloser.Close()
+	c.cancel()
+	return err
+}
@@ -0,0 +1,14 @@
+// +build !go1.7
+
+package request
+
+import "time"
+
+// hedging needs the context package, reads are not hedged before Go 1.7
+func (r *Request) hedgeDelay() (time.Duration, bool) {
+	return 0, false
+}
+
+func (r *Request) sendHedged(delay time.Duration) (release func()) {
+	return r.sendOnEndpoint()
+}
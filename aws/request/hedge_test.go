@@ -0,0 +1,269 @@
+// +build go1.7
+
+package request
+
+import (
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/aws/awserr"
+	"github.com/aws/aws-sdk-go/aws/client/metadata"
+	"github.com/aws/aws-sdk-go/aws/endpoints"
+)
+
+// pickPolicy picks the endpoint of URL while it is a candidate
+type pickPolicy struct {
+	URL string
+}
+
+func (p pickPolicy) Name() string { return "pick" }
+
+func (p pickPolicy) Pick(candidates []*endpoints.SingleEndpoint) *endpoints.SingleEndpoint {
+	for _, endpoint := range candidates {
+		if endpoint.URL == p.URL {
+			return endpoint
+		}
+	}
+	return candidates[0]
+}
+
+func hedgeHandlers() Handlers {
+	var handlers Handlers
+	handlers.Sign.PushBack(func(r *Request) {
+		r.HTTPRequest.Header.Set("X-Signed-Host", r.HTTPRequest.URL.Host)
+	})
+	handlers.Send.PushBack(func(r *Request) {
+		var err error
+		r.HTTPResponse, err = http.DefaultClient.Do(r.HTTPRequest)
+		if err != nil {
+			r.Error = awserr.New(ErrCodeRequestError, "send request failed", err)
+		}
+	})
+	return handlers
+}
+
+func TestHedgedRead(t *testing.T) {
+	canceled := make(chan struct{})
+	slow := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		select {
+		case <-r.Context().Done():
+			close(canceled)
+		case <-time.After(5 * time.Second):
+		}
+	}))
+	defer slow.Close()
+
+	var fastRequests int32
+	fast := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		atomic.AddInt32(&fastRequests, 1)
+		if e, a := r.Host, r.Header.Get("X-Signed-Host"); e != a {
+			t.Errorf("expect the hedge signed for %s, got %s", e, a)
+		}
+		w.Write([]byte("fast"))
+	}))
+	defer fast.Close()
+
+	coll, err := endpoints.NewEndpointCollectionFromList([]string{slow.URL, fast.URL}, 60)
+	if err != nil {
+		t.Fatalf("expect no error, got %v", err)
+	}
+	defer coll.Close()
+
+	cfg := aws.Config{
+		CEndpoint:     coll,
+		BalancePolicy: pickPolicy{URL: slow.URL},
+		Hedging:       &endpoints.HedgeConfig{MinDelay: 20 * time.Millisecond, MaxDelay: 20 * time.Millisecond},
+	}
+	op := &Operation{Name: "GetObject", HTTPMethod: "GET", HTTPPath: "/bucket/key"}
+	r := New(cfg, metadata.ClientInfo{}, hedgeHandlers(), nil, op, nil, nil)
+	if e, a := slow.URL, r.Endpoint.URL; e != a {
+		t.Fatalf("expect %s, got %s", e, a)
+	}
+
+	if err := r.Send(); err != nil {
+		t.Fatalf("expect no error, got %v", err)
+	}
+	if e, a := fast.URL, r.Endpoint.URL; e != a {
+		t.Errorf("expect the response of %s, got %s", e, a)
+	}
+	b, err := ioutil.ReadAll(r.HTTPResponse.Body)
+	r.HTTPResponse.Body.Close()
+	if err != nil {
+		t.Fatalf("expect no error, got %v", err)
+	}
+	if e, a := "fast", string(b); e != a {
+		t.Errorf("expect %q, got %q", e, a)
+	}
+
+	select {
+	case <-canceled:
+	case <-time.After(2 * time.Second):
+		t.Fatalf("expect the slow request canceled")
+	}
+	inflight := func() (n int64) {
+		for _, score := range coll.Scores() {
+			n += score.Inflight
+		}
+		return n
+	}
+	for deadline := time.Now().Add(2 * time.Second); inflight() != 0 && time.Now().Before(deadline); {
+		time.Sleep(time.Millisecond)
+	}
+	if a := inflight(); a != 0 {
+		t.Errorf("expect the endpoints released, got %d in flight", a)
+	}
+
+	if e, a := int32(1), atomic.LoadInt32(&fastRequests); e != a {
+		t.Errorf("expect %d hedge, got %d", e, a)
+	}
+
+	// a write is never hedged
+	put := &Operation{Name: "PutObject", HTTPMethod: "PUT", HTTPPath: "/bucket/key"}
+	cfg.BalancePolicy = pickPolicy{URL: fast.URL}
+	cfg.Hedging = &endpoints.HedgeConfig{Operations: []string{"GetObject", "PutObject"}}
+	r = New(cfg, metadata.ClientInfo{}, hedgeHandlers(), nil, put, nil, nil)
+	if _, ok := r.hedgeDelay(); ok {
+		t.Errorf("expect PutObject not hedged")
+	}
+}
+
+func TestHedgedReadFastPrimary(t *testing.T) {
+	var hedged int32
+	other := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		atomic.AddInt32(&hedged, 1)
+	}))
+	defer other.Close()
+	primary := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Write([]byte("primary"))
+	}))
+	defer primary.Close()
+
+	coll, err := endpoints.NewEndpointCollectionFromList([]string{other.URL, primary.URL}, 60)
+	if err != nil {
+		t.Fatalf("expect no error, got %v", err)
+	}
+	defer coll.Close()
+
+	cfg := aws.Config{
+		CEndpoint:     coll,
+		BalancePolicy: pickPolicy{URL: primary.URL},
+		Hedging:       &endpoints.HedgeConfig{MinDelay: time.Second, MaxDelay: time.Second},
+	}
+	op := &Operation{Name: "HeadObject", HTTPMethod: "HEAD", HTTPPath: "/bucket/key"}
+	for i := 0; i < 5; i++ {
+		r := New(cfg, metadata.ClientInfo{}, hedgeHandlers(), nil, op, nil, nil)
+		if err := r.Send(); err != nil {
+			t.Fatalf("%d expect no error, got %v", i, err)
+		}
+		r.HTTPResponse.Body.Close()
+		if e, a := primary.URL, r.Endpoint.URL; e != a {
+			t.Errorf("%d expect %s, got %s", i, e, a)
+		}
+	}
+	if a := atomic.LoadInt32(&hedged); a != 0 {
+		t.Errorf("expect no hedge, got %d", a)
+	}
+}
+
+func TestHedgedReadRecordsLoser(t *testing.T) {
+	slow := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		select {
+		case <-r.Context().Done():
+		case <-time.After(5 * time.Second):
+		}
+	}))
+	defer slow.Close()
+	fast := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {}))
+	defer fast.Close()
+
+	coll, err := endpoints.NewEndpointCollectionFromList([]string{slow.URL, fast.URL}, 60)
+	if err != nil {
+		t.Fatalf("expect no error, got %v", err)
+	}
+	defer coll.Close()
+	// every read so far was fast
+	for i := 0; i < 19; i++ {
+		coll.RecordReadLatency(time.Millisecond)
+	}
+
+	cfg := aws.Config{
+		CEndpoint:     coll,
+		BalancePolicy: pickPolicy{URL: slow.URL},
+		Hedging:       &endpoints.HedgeConfig{MinDelay: 50 * time.Millisecond, MaxDelay: 50 * time.Millisecond},
+	}
+	op := &Operation{Name: "GetObject", HTTPMethod: "GET", HTTPPath: "/bucket/key"}
+	r := New(cfg, metadata.ClientInfo{}, hedgeHandlers(), nil, op, nil, nil)
+	if err := r.Send(); err != nil {
+		t.Fatalf("expect no error, got %v", err)
+	}
+	r.HTTPResponse.Body.Close()
+
+	// the canceled primary is recorded for at least the time it ran
+	slowest := endpoints.HedgeConfig{Percentile: 1, MinDelay: time.Nanosecond, MaxDelay: time.Minute}
+	for deadline := time.Now().Add(2 * time.Second); coll.HedgeDelay(slowest) < 50*time.Millisecond; {
+		if time.Now().After(deadline) {
+			t.Fatalf("expect the primary recorded for at least the hedge delay, got %v",
+				coll.HedgeDelay(slowest))
+		}
+		time.Sleep(time.Millisecond)
+	}
+}
+
+func TestHedgedReadFailedPrimary(t *testing.T) {
+	hedge := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		time.Sleep(200 * time.Millisecond)
+	}))
+	defer hedge.Close()
+	failing := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {}))
+	failing.Close()
+
+	// the primary fails once the hedge is sent
+	handlers := hedgeHandlers()
+	handlers.Send.PushFront(func(r *Request) {
+		if r.Endpoint.URL == failing.URL {
+			time.Sleep(50 * time.Millisecond)
+		}
+	})
+	// records the attempts like core.EndpointStatsHandler
+	handlers.CompleteAttempt.PushBack(func(r *Request) {
+		if !r.SendTime.IsZero() {
+			r.Endpoint.RecordResult(time.Since(r.SendTime), r.IsEndpointError())
+		}
+	})
+
+	coll, err := endpoints.NewEndpointCollectionFromList([]string{failing.URL, hedge.URL}, 60)
+	if err != nil {
+		t.Fatalf("expect no error, got %v", err)
+	}
+	defer coll.Close()
+
+	cfg := aws.Config{
+		CEndpoint:     coll,
+		BalancePolicy: pickPolicy{URL: failing.URL},
+		Hedging:       &endpoints.HedgeConfig{MinDelay: 10 * time.Millisecond, MaxDelay: 10 * time.Millisecond},
+	}
+	op := &Operation{Name: "GetObject", HTTPMethod: "GET", HTTPPath: "/bucket/key"}
+	r := New(cfg, metadata.ClientInfo{}, handlers, nil, op, nil, nil)
+	primary := r.Endpoint
+	if err := r.Send(); err != nil {
+		t.Fatalf("expect no error, got %v", err)
+	}
+	r.HTTPResponse.Body.Close()
+	if e, a := hedge.URL, r.Endpoint.URL; e != a {
+		t.Errorf("expect the response of %s, got %s", e, a)
+	}
+
+	// the CompleteAttempt handlers see the failed primary though its
+	// response is unused
+	if score := primary.Score(); score.Samples != 1 || score.ErrorRate == 0 {
+		t.Errorf("expect the failure of the primary recorded, got %+v", score)
+	}
+	if !primary.IsInBlackList {
+		t.Errorf("expect the failing primary blacklisted")
+	}
+}
@@ -12,6 +12,7 @@ import (
 
 	"github.com/aws/aws-sdk-go/aws"
 	"github.com/aws/aws-sdk-go/aws/awserr"
+	"github.com/aws/aws-sdk-go/aws/awsutil"
 	"github.com/aws/aws-sdk-go/aws/client/metadata"
 	"github.com/aws/aws-sdk-go/aws/endpoints"
 	"github.com/aws/aws-sdk-go/internal/sdkio"
@@ -41,6 +42,15 @@ const (
 	// ErrCodeRequestError is an error preventing the SDK from continuing to
 	// process the request.
 	ErrCodeRequestError = "RequestError"
+
+	// ErrCodeRetryQuotaExceeded is the error code returned instead of
+	// retrying a request when Config.RetryQuota is empty.
+	ErrCodeRetryQuotaExceeded = "RetryQuotaExceeded"
+
+	// ErrCodeRetryDeadlineExceeded is the error code returned instead of
+	// retrying a request when the retry cannot complete before the
+	// deadline of the request.
+	ErrCodeRetryDeadlineExceeded = "RetryDeadlineExceeded"
 )
 
 // A Request is the service request to be made.
@@ -51,9 +61,11 @@ type Request struct {
 
 	Retryer
 	AttemptTime            time.Time
+	SendTime               time.Time // when the endpoint let the attempt through
 	Time                   time.Time
 	Endpoint               *endpoints.SingleEndpoint
 	CEndpoint              *endpoints.EndpointCollection
+	Affinity               *endpoints.Affinity
 	Operation              *Operation
 	HTTPRequest            *http.Request
 	HTTPResponse           *http.Response
@@ -97,6 +109,12 @@ type Request struct {
 	// to the HTTP request's body after the client has returned. This value is
 	// safe to use concurrently and wrap the input Body for each HTTP request.
 	safeBody *offsetReader
+
+	// tokens drawn from Config.RetryQuota by the retries of the request
+	retryQuotaCost int
+
+	// releases the context bounded by Config.OperationTimeout
+	cancelOperation func()
 }
 
 // An Operation is the service API operation to be made.
@@ -138,9 +156,14 @@ func New(cfg aws.Config, clientInfo metadata.ClientInfo, handlers Handlers,
 
 	httpReq, _ := http.NewRequest(method, "", nil)
 
+	// route the request to a named pool of endpoints
+	if pool := cfg.CEndpointPools.Route(paramsBucket(params), operation.Name); pool != nil {
+		cfg.CEndpoint = pool
+	}
+
 	var err error
 	if cfg.CEndpoint != nil {
-		endpoint = cfg.CEndpoint.GetNextEndpoint(nil)
+		endpoint = cfg.CEndpoint.SelectEndpointByKey(paramsObjectKey(params), cfg.BalancePolicy)
 		if endpoint == nil {
 			err = fmt.Errorf("New Request: failed get endpoint from Collection")
 		} else {
@@ -175,6 +198,41 @@ func New(cfg aws.Config, clientInfo metadata.ClientInfo, handlers Handlers,
 	return r
 }
 
+// paramsBucket returns the Bucket member of the input parameters, if any.
+func paramsBucket(params interface{}) string {
+	return paramsString(params, "Bucket")
+}
+
+// paramsObjectKey returns the bucket and key of the object the input
+// parameters are about, only the bucket for the operations on a bucket.
+// Empty if the parameters have no Bucket member.
+func paramsObjectKey(params interface{}) string {
+	bucket := paramsBucket(params)
+	if bucket == "" {
+		return ""
+	}
+	return bucket + "/" + paramsString(params, "Key")
+}
+
+// paramsString returns the string member name of the input parameters, if
+// any.
+func paramsString(params interface{}, name string) string {
+	if params == nil {
+		return ""
+	}
+	values, err := awsutil.ValuesAtPath(params, name)
+	if err != nil || len(values) == 0 {
+		return ""
+	}
+	switch v := values[0].(type) {
+	case *string:
+		return aws.StringValue(v)
+	case string:
+		return v
+	}
+	return ""
+}
+
 // A Option is a functional option that can augment or modify a request when
 // using a WithContext API operation method.
 type Option func(*Request)
@@ -539,6 +597,9 @@ func (r *Request) Send() error {
 		return err
 	}
 
+	finish := r.applyOperationTimeout()
+	defer finish()
+
 	for {
 		r.Error = nil
 		r.AttemptTime = time.Now()
@@ -549,6 +610,7 @@ func (r *Request) Send() error {
 		}
 
 		if err := r.sendRequest(); err == nil {
+			r.refillRetryQuota()
 			return nil
 		}
 
@@ -576,7 +638,7 @@ func (r *Request) prepareRetry() error {
 	// and the HTTP Client's Transport may still be reading from
 	// the request's body even though the Client's Do returned.
 	r.HTTPRequest = copyHTTPRequest(r.HTTPRequest, nil)
-	if aws.BoolValue(r.NetWorkErrorRetry) && r.NetworkRetryCount == 0 {
+	if aws.BoolValue(r.NetWorkErrorRetry) {
 		if err := updateURL(r.HTTPRequest.URL, r.Endpoint); err != nil {
 			return awserr.New(ErrCodeSerialization,
 				"failed to prepare url for retry", err)
@@ -603,7 +665,13 @@ func (r *Request) sendRequest() (sendErr error) {
 
 	r.Retryable = nil
 	r.NetWorkErrorRetry = nil
-	r.Handlers.Send.Run(r)
+	if delay, ok := r.hedgeDelay(); ok {
+		release := r.sendHedged(delay)
+		defer release()
+	} else {
+		release := r.sendOnEndpoint()
+		defer release()
+	}
 	if r.Error != nil {
 		debugLogReqError(r, "Send Request",
 			fmtAttemptCount(r.RetryCount, r.MaxRetries()),
@@ -621,6 +689,7 @@ func (r *Request) sendRequest() (sendErr error) {
 		return r.Error
 	}
 
+	r.bindOperationTimeout()
 	r.Handlers.Unmarshal.Run(r)
 	if r.Error != nil {
 		debugLogReqError(r, "Unmarshal Response",
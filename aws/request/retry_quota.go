@@ -0,0 +1,42 @@
+package request
+
+import (
+	"fmt"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/aws/awserr"
+)
+
+// DrawRetryQuota draws the tokens of the next retry of the request from
+// Config.RetryQuota. If the quota is empty the request fails fast: its
+// Error is replaced by an ErrCodeRetryQuotaExceeded error wrapping it, it
+// is no longer retryable, and false is returned. Always true without a
+// quota.
+func (r *Request) DrawRetryQuota() bool {
+	quota := r.Config.RetryQuota
+	if quota == nil {
+		return true
+	}
+
+	cost, ok := quota.Draw(isErrTimeout(r.Error))
+	if !ok {
+		if r.Config.LogLevel.Matches(aws.LogDebugWithRequestRetries) {
+			r.Config.Logger.Log(fmt.Sprintf("DEBUG: Retry quota exceeded %s/%s, not retrying",
+				r.ClientInfo.ServiceName, r.Operation.Name))
+		}
+		r.Error = awserr.New(ErrCodeRetryQuotaExceeded, "retry quota exceeded", r.Error)
+		r.Retryable = aws.Bool(false)
+		return false
+	}
+	r.retryQuotaCost += cost
+	return true
+}
+
+// refillRetryQuota gives the tokens drawn by the request back to
+// Config.RetryQuota once it succeeded
+func (r *Request) refillRetryQuota() {
+	if quota := r.Config.RetryQuota; quota != nil {
+		quota.Refill(r.retryQuotaCost)
+		r.retryQuotaCost = 0
+	}
+}
@@ -0,0 +1,62 @@
+package request_test
+
+import (
+	"net/http"
+	"testing"
+	"time"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/aws/awserr"
+	"github.com/aws/aws-sdk-go/aws/endpoints"
+	"github.com/aws/aws-sdk-go/aws/request"
+	"github.com/aws/aws-sdk-go/awstesting"
+)
+
+func TestRequestRetryQuota(t *testing.T) {
+	quota := endpoints.NewRetryQuota(endpoints.RetryQuotaConfig{Capacity: 10, RetryCost: 5})
+	statusCodes := []int{500, 500, 200, 500, 500, 500}
+
+	attempts := 0
+	s := awstesting.NewClient(&aws.Config{
+		MaxRetries: aws.Int(10),
+		SleepDelay: func(time.Duration) {},
+		RetryQuota: quota,
+	})
+	s.Handlers.Validate.Clear()
+	s.Handlers.Unmarshal.PushBack(unmarshal)
+	s.Handlers.UnmarshalError.PushBack(unmarshalError)
+	s.Handlers.Send.Clear() // mock sending
+	s.Handlers.Send.PushBack(func(r *request.Request) {
+		r.HTTPResponse = &http.Response{
+			StatusCode: statusCodes[attempts],
+			Body:       body(`{"__type":"UnknownError","message":"An error occurred."}`),
+		}
+		attempts++
+	})
+
+	// the retries of a successful request are given back
+	r := s.NewRequest(&request.Operation{Name: "Operation"}, nil, &testData{})
+	if err := r.Send(); err != nil {
+		t.Fatalf("expect no error, got %v", err)
+	}
+	if e, a := 10, quota.Available(); e != a {
+		t.Fatalf("expect %d tokens, got %d", e, a)
+	}
+
+	// an empty quota fails fast
+	r = s.NewRequest(&request.Operation{Name: "Operation"}, nil, &testData{})
+	err := r.Send()
+	aerr, ok := err.(awserr.Error)
+	if !ok || aerr.Code() != request.ErrCodeRetryQuotaExceeded {
+		t.Fatalf("expect %s error, got %v", request.ErrCodeRetryQuotaExceeded, err)
+	}
+	if e, a := 2, r.RetryCount; e != a {
+		t.Errorf("expect %d retries, got %d", e, a)
+	}
+	if e, a := len(statusCodes), attempts; e != a {
+		t.Errorf("expect %d attempts, got %d", e, a)
+	}
+	if e, a := 0, quota.Available(); e != a {
+		t.Errorf("expect %d tokens, got %d", e, a)
+	}
+}
@@ -347,20 +347,32 @@ func (r *Request) IsErrorExpired() bool {
 	return IsErrorExpiredCreds(r.Error)
 }
 
-// If the the error is network error
+// If the the error is network error, or another error caused by the
+// endpoint, see IsEndpointError
 func (r *Request) ShouldNetworkErrorRetry() bool {
 	if r.Config.CEndpoint == nil {
 		r.NetWorkErrorRetry = aws.Bool(false)
 		return false
 	}
-	if isNetworkError := IsNetworkError(r.Error); !isNetworkError {
+	if isEndpointError := r.IsEndpointError(); !isEndpointError {
 		r.NetworkRetryCount = 0
 		r.NetWorkErrorRetry = aws.Bool(false)
 		return false
 	}
 	r.NetworkRetryCount += 1
-	if r.NetworkRetryCount >= aws.IntValue(r.Config.MaxNetworkErrorRetries) {
-		endpoint := r.CEndpoint.AddEndpointToBlacklist(r.Endpoint)
+	if r.isAttemptTimeout() {
+		// an attempt timing out fails over to another endpoint at once,
+		// whatever MaxNetworkErrorRetries. A single timeout does not
+		// blacklist its endpoint, it is recorded as a failure in the
+		// passive health of the endpoint
+		if endpoint := r.selectRetryEndpoint(); endpoint != nil && endpoint != r.Endpoint {
+			r.Endpoint = endpoint
+			r.NetworkRetryCount = 0
+			r.NetWorkErrorRetry = aws.Bool(true)
+		}
+	} else if r.NetworkRetryCount >= aws.IntValue(r.Config.MaxNetworkErrorRetries) {
+		r.CEndpoint.AddEndpointToBlacklist(r.Endpoint)
+		endpoint := r.selectRetryEndpoint()
 		if endpoint != nil {
 			r.Endpoint = endpoint
 			r.NetworkRetryCount = 0
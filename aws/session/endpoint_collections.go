@@ -0,0 +1,175 @@
+package session
+
+import (
+	"fmt"
+	"strings"
+	"sync"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/aws/endpoints"
+)
+
+// endpointCollections tracks the endpoint collections a Session took a
+// reference to, so they can be released when the Session is closed.
+type endpointCollections struct {
+	mutex       sync.Mutex
+	collections map[string]*endpoints.EndpointCollection
+
+	// gives back the reference to each collection
+	releases map[string]func() bool
+}
+
+func newEndpointCollections() *endpointCollections {
+	return &endpointCollections{
+		collections: make(map[string]*endpoints.EndpointCollection),
+		releases:    make(map[string]func() bool),
+	}
+}
+
+// findDefault returns the default collection of cfg, created from
+// cfg.EndpointsPath or else from cfg.Gateways.
+func (c *endpointCollections) findDefault(cfg *aws.Config) (*endpoints.EndpointCollection, error) {
+	keepAliveInterval := aws.IntValue(cfg.KeepAliveInterval)
+	if cfg.EndpointsPath != nil {
+		endpointsPath := aws.StringValue(cfg.EndpointsPath)
+		return c.find(endpointsPath, keepAliveInterval, collectionOptions(cfg, endpointsPath))
+	}
+	return c.findList(cfg.Gateways, keepAliveInterval, collectionOptions(cfg, ""))
+}
+
+// find returns the collection of endpointsPath, taking a reference to it
+// from endpoints.GEndpoints the first time the path is used.
+func (c *endpointCollections) find(endpointsPath string, keepAliveInterval int,
+	optFns ...func(*endpoints.CollectionOptions)) (*endpoints.EndpointCollection, error) {
+
+	if c == nil {
+		return endpoints.GEndpoints.FindEndpointCollection(endpointsPath,
+			keepAliveInterval, optFns...)
+	}
+
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+
+	if coll, ok := c.collections[endpointsPath]; ok {
+		return coll, nil
+	}
+
+	coll, err := endpoints.GEndpoints.FindEndpointCollection(endpointsPath,
+		keepAliveInterval, optFns...)
+	if err != nil {
+		return nil, err
+	}
+	c.collections[endpointsPath] = coll
+	c.releases[endpointsPath] = func() bool {
+		return endpoints.GEndpoints.ReleaseEndpointCollection(endpointsPath)
+	}
+	return coll, nil
+}
+
+// findList is find for a list of gateways.
+func (c *endpointCollections) findList(gateways []string, keepAliveInterval int,
+	optFns ...func(*endpoints.CollectionOptions)) (*endpoints.EndpointCollection, error) {
+
+	if c == nil {
+		return endpoints.GEndpoints.FindEndpointCollectionFromList(gateways,
+			keepAliveInterval, optFns...)
+	}
+
+	// a path has no newline, so the key of a list is never a path
+	key := "\n" + strings.Join(gateways, "\n")
+
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+
+	if coll, ok := c.collections[key]; ok {
+		return coll, nil
+	}
+
+	gateways = append([]string(nil), gateways...)
+	coll, err := endpoints.GEndpoints.FindEndpointCollectionFromList(gateways,
+		keepAliveInterval, optFns...)
+	if err != nil {
+		return nil, err
+	}
+	c.collections[key] = coll
+	c.releases[key] = func() bool {
+		return endpoints.GEndpoints.ReleaseEndpointCollectionFromList(gateways)
+	}
+	return coll, nil
+}
+
+// findPools returns the named pools of cfg.EndpointPools, routed by
+// cfg.EndpointRoutes. Returns nil if cfg has no pools.
+func (c *endpointCollections) findPools(cfg *aws.Config) (*endpoints.EndpointPools, error) {
+	if len(cfg.EndpointPools) == 0 {
+		return nil, nil
+	}
+
+	keepAliveInterval := aws.IntValue(cfg.KeepAliveInterval)
+	collections := make(map[string]*endpoints.EndpointCollection, len(cfg.EndpointPools))
+	for name, endpointsPath := range cfg.EndpointPools {
+		coll, err := c.find(endpointsPath, keepAliveInterval,
+			collectionOptions(cfg, endpointsPath))
+		if err != nil {
+			return nil, fmt.Errorf("failed to load endpoint pool %q, %v", name, err)
+		}
+		collections[name] = coll
+	}
+	return endpoints.NewEndpointPools(collections, cfg.EndpointRoutes)
+}
+
+// releaseAll gives back every reference taken by find.
+func (c *endpointCollections) releaseAll() {
+	if c == nil {
+		return
+	}
+
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+
+	for key, release := range c.releases {
+		release()
+		delete(c.collections, key)
+		delete(c.releases, key)
+	}
+}
+
+// collectionOptions returns the options of the endpoint collection of
+// endpointsPath created for cfg. endpointsPath is empty for a list of
+// gateways, which has no cache file.
+func collectionOptions(cfg *aws.Config, endpointsPath string) func(*endpoints.CollectionOptions) {
+	return func(o *endpoints.CollectionOptions) {
+		if cfg.CircuitBreaker != nil {
+			o.CircuitBreaker = *cfg.CircuitBreaker
+		}
+		o.HealthChecker = cfg.HealthChecker
+		if cfg.ProbeTimeout != nil {
+			o.ProbeTimeout = *cfg.ProbeTimeout
+		}
+		if cfg.ProbeConcurrency != nil {
+			o.ProbeConcurrency = *cfg.ProbeConcurrency
+		}
+		if cfg.ProbeTransport != nil {
+			o.ProbeTransport = *cfg.ProbeTransport
+		}
+		o.MaxConnectionsPerEndpoint = aws.IntValue(cfg.MaxConnectionsPerEndpoint)
+		o.MaxIdleConnsPerEndpoint = aws.IntValue(cfg.MaxIdleConnsPerEndpoint)
+		o.ConcurrencyLimit = cfg.ConcurrencyLimit
+		o.LocalZone = aws.StringValue(cfg.LocalZone)
+		if cfg.ZoneSpillover != nil {
+			o.ZoneSpillover = *cfg.ZoneSpillover
+		}
+		o.ConsistentHash = aws.BoolValue(cfg.ConsistentHash)
+		if cfg.SeedReloadInterval != nil {
+			o.SeedReloadInterval = *cfg.SeedReloadInterval
+		}
+		if aws.BoolValue(cfg.CacheEndpoints) && endpointsPath != "" {
+			o.CachePath = endpoints.DefaultCachePath(endpointsPath)
+		}
+		o.Discoverer = cfg.GatewayDiscoverer
+		if cfg.Logger != nil {
+			o.Logger = cfg.Logger.Log
+			o.LogEvents = cfg.LogLevel.Matches(aws.LogDebugWithEndpointEvents)
+		}
+	}
+}
@@ -48,6 +48,9 @@ var ErrSharedConfigInvalidCredSource = awserr.New(ErrCodeSharedConfig, "credenti
 type Session struct {
 	Config   *aws.Config
 	Handlers request.Handlers
+
+	// the endpoint collections referenced by the Session's clients
+	endpointCollections *endpointCollections
 }
 
 // New creates a new instance of the handlers merging in the provided configs
@@ -347,8 +350,9 @@ func deprecatedNewSession(cfgs ...*aws.Config) *Session {
 	cfg.MergeIn(cfgs...)
 
 	s := &Session{
-		Config:   cfg,
-		Handlers: handlers,
+		Config:              cfg,
+		Handlers:            handlers,
+		endpointCollections: newEndpointCollections(),
 	}
 
 	initHandlers(s)
@@ -416,8 +420,9 @@ func newSession(opts Options, envCfg envConfig, cfgs ...*aws.Config) (*Session,
 	}
 
 	s := &Session{
-		Config:   cfg,
-		Handlers: handlers,
+		Config:              cfg,
+		Handlers:            handlers,
+		endpointCollections: newEndpointCollections(),
 	}
 
 	initHandlers(s)
@@ -625,8 +630,9 @@ func initHandlers(s *Session) {
 //     sess.Copy(&aws.Config{Region: aws.String("us-west-2")})
 func (s *Session) Copy(cfgs ...*aws.Config) *Session {
 	newSession := &Session{
-		Config:   s.Config.Copy(cfgs...),
-		Handlers: s.Handlers.Copy(),
+		Config:              s.Config.Copy(cfgs...),
+		Handlers:            s.Handlers.Copy(),
+		endpointCollections: newEndpointCollections(),
 	}
 
 	initHandlers(newSession)
@@ -644,17 +650,20 @@ func (s *Session) ClientConfig(service string, cfgs ...*aws.Config) client.Confi
 	)
 	resolved := endpoints.ResolvedEndpoint{}
 	region := aws.StringValue(s.Config.Region)
+	collections := s.endpointCollections
 
 	s = s.Copy(cfgs...)
 
-	// find endpoints from GlobalEndpoints
-	if (s.Config.EndpointsPath != nil) {
-		endpointsPath := aws.StringValue(s.Config.EndpointsPath)
-		keepAliveInterval := aws.IntValue(s.Config.KeepAliveInterval)
-		if coll, err = endpoints.GEndpoints.FindEndpointCollection(
-				endpointsPath, keepAliveInterval); err == nil {
+	// find endpoints from GlobalEndpoints, unless the caller built the
+	// collection
+	if s.Config.CEndpoint != nil || s.Config.EndpointsPath != nil || s.Config.Gateways != nil {
+		coll = s.Config.CEndpoint
+		if coll == nil {
+			coll, err = collections.findDefault(s.Config)
+		}
+		if err == nil {
 			s.Config.CEndpoint = coll
-			endpoint := coll.GetNextEndpoint(nil)
+			endpoint := coll.SelectEndpoint(s.Config.BalancePolicy)
 			if endpoint != nil {
 				resolved.URL = endpoint.URL
 				resolved.SigningRegion = region
@@ -676,6 +685,15 @@ func (s *Session) ClientConfig(service string, cfgs ...*aws.Config) client.Confi
 		}
 	}
 
+	// find the named pools, requests are routed to them by request.New
+	if pools, err := collections.findPools(s.Config); err != nil {
+		s.Handlers.Validate.PushBack(func(r *request.Request) {
+			r.Error = awserr.New("InvalidEndpointPools", "invalid endpoint pools", err)
+		})
+	} else if pools != nil {
+		s.Config.CEndpointPools = pools
+	}
+
 	return client.Config{
 		Config:             s.Config,
 		Handlers:           s.Handlers,
@@ -687,6 +705,16 @@ func (s *Session) ClientConfig(service string, cfgs ...*aws.Config) client.Confi
 	}
 }
 
+// Close releases the endpoint collections used by the Session's service
+// clients. A collection is closed, and its keep alive stopped, once no other
+// Session uses it. Clients created from the Session must not be used after
+// Close returns. Sessions without EndpointsPath or Gateways do not need to be
+// closed.
+func (s *Session) Close() error {
+	s.endpointCollections.releaseAll()
+	return nil
+}
+
 func (s *Session) resolveEndpoint(service, region string, cfg *aws.Config) (endpoints.ResolvedEndpoint, error) {
 
 	if ep := aws.StringValue(cfg.Endpoint); len(ep) != 0 {
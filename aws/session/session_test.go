@@ -5,12 +5,17 @@ package session
 import (
 	"bytes"
 	"fmt"
+	"io/ioutil"
 	"os"
 	"path/filepath"
+	"sort"
 	"strings"
 	"testing"
+	"time"
 
 	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/aws/awserr"
+	"github.com/aws/aws-sdk-go/aws/client/metadata"
 	"github.com/aws/aws-sdk-go/aws/credentials"
 	"github.com/aws/aws-sdk-go/aws/defaults"
 	"github.com/aws/aws-sdk-go/aws/endpoints"
@@ -674,3 +679,249 @@ func TestSession_RegionalEndpoints(t *testing.T) {
 		})
 	}
 }
+
+func TestSessionClose(t *testing.T) {
+	restoreEnvFn := initSessionTestEnv()
+	defer restoreEnvFn()
+
+	dir, err := ioutil.TempDir("", "aws-sdk-go-session-close")
+	if err != nil {
+		t.Fatalf("expect no error, got %v", err)
+	}
+	defer os.RemoveAll(dir)
+
+	endpointsPath := filepath.Join(dir, "endpoints")
+	err = ioutil.WriteFile(endpointsPath, []byte("http://abc1.test:8080\n"), 0644)
+	if err != nil {
+		t.Fatalf("expect no error, got %v", err)
+	}
+
+	s, err := NewSession(&aws.Config{
+		Region:        aws.String("region"),
+		EndpointsPath: aws.String(endpointsPath),
+	})
+	if err != nil {
+		t.Fatalf("expect no error, got %v", err)
+	}
+
+	c1 := s.ClientConfig("s3")
+	c2 := s.ClientConfig("s3")
+	if c1.Config.CEndpoint == nil {
+		t.Fatalf("expect endpoint collection, got nil")
+	}
+	if c1.Config.CEndpoint != c2.Config.CEndpoint {
+		t.Errorf("expect clients to share the endpoint collection")
+	}
+	if e, a := "http://abc1.test:8080", c1.Endpoint; e != a {
+		t.Errorf("expect %v, got %v", e, a)
+	}
+
+	if err := s.Close(); err != nil {
+		t.Fatalf("expect no error, got %v", err)
+	}
+
+	coll, err := endpoints.GEndpoints.FindEndpointCollection(endpointsPath, 1)
+	if err != nil {
+		t.Fatalf("expect no error, got %v", err)
+	}
+	defer endpoints.GEndpoints.ReleaseEndpointCollection(endpointsPath)
+	if coll == c1.Config.CEndpoint {
+		t.Errorf("expect collection to be released by Close")
+	}
+}
+
+func TestSessionEndpointPools(t *testing.T) {
+	restoreEnvFn := initSessionTestEnv()
+	defer restoreEnvFn()
+
+	dir, err := ioutil.TempDir("", "aws-sdk-go-session-pools")
+	if err != nil {
+		t.Fatalf("expect no error, got %v", err)
+	}
+	defer os.RemoveAll(dir)
+
+	hotPath := filepath.Join(dir, "hot")
+	coldPath := filepath.Join(dir, "cold")
+	if err := ioutil.WriteFile(hotPath, []byte("http://hot.test:8080\n"), 0644); err != nil {
+		t.Fatalf("expect no error, got %v", err)
+	}
+	if err := ioutil.WriteFile(coldPath, []byte("http://cold.test:8080\n"), 0644); err != nil {
+		t.Fatalf("expect no error, got %v", err)
+	}
+
+	cfg := &aws.Config{
+		Region:        aws.String("region"),
+		EndpointsPath: aws.String(hotPath),
+	}
+	cfg.WithEndpointPool("cold", coldPath).
+		WithEndpointRoutes(endpoints.PoolRoute{Pool: "cold", Buckets: []string{"cold-*"}})
+
+	s, err := NewSession(cfg)
+	if err != nil {
+		t.Fatalf("expect no error, got %v", err)
+	}
+	defer s.Close()
+
+	c := s.ClientConfig("s3")
+	if e, a := "http://hot.test:8080", c.Endpoint; e != a {
+		t.Errorf("expect %v, got %v", e, a)
+	}
+	cold := c.Config.CEndpointPools.Route("cold-1", "GetObject")
+	if cold == nil {
+		t.Fatalf("expect cold pool, got nil")
+	}
+	if e, a := "http://cold.test:8080", cold.SelectEndpoint(nil).URL; e != a {
+		t.Errorf("expect %v, got %v", e, a)
+	}
+
+	// routes to an undefined pool fail the requests of the client
+	c = s.ClientConfig("s3", &aws.Config{
+		EndpointRoutes: []endpoints.PoolRoute{{Pool: "warm"}},
+	})
+	if c.Config.CEndpointPools != nil {
+		t.Errorf("expect no pools, got %v", c.Config.CEndpointPools)
+	}
+	r := &request.Request{
+		Config:     *c.Config,
+		ClientInfo: metadata.ClientInfo{Endpoint: c.Endpoint, SigningRegion: c.SigningRegion},
+	}
+	c.Handlers.Validate.Run(r)
+	if r.Error == nil {
+		t.Fatalf("expect error, got nil")
+	}
+	if e, a := "InvalidEndpointPools", r.Error.(awserr.Error).Code(); e != a {
+		t.Errorf("expect %v, got %v", e, a)
+	}
+}
+
+func TestSessionGateways(t *testing.T) {
+	restoreEnvFn := initSessionTestEnv()
+	defer restoreEnvFn()
+
+	gateways := []string{"http://abc1.test:8080 zone=z1", "http://abc2.test:8080"}
+	s, err := NewSession(&aws.Config{
+		Region:   aws.String("region"),
+		Gateways: gateways,
+	})
+	if err != nil {
+		t.Fatalf("expect no error, got %v", err)
+	}
+
+	c1 := s.ClientConfig("s3")
+	c2 := s.ClientConfig("s3")
+	if c1.Config.CEndpoint == nil {
+		t.Fatalf("expect endpoint collection, got nil")
+	}
+	if c1.Config.CEndpoint != c2.Config.CEndpoint {
+		t.Errorf("expect clients to share the endpoint collection")
+	}
+	if !strings.HasPrefix(c1.Endpoint, "http://abc") {
+		t.Errorf("expect a gateway, got %v", c1.Endpoint)
+	}
+
+	if err := s.Close(); err != nil {
+		t.Fatalf("expect no error, got %v", err)
+	}
+
+	coll, err := endpoints.GEndpoints.FindEndpointCollectionFromList(gateways, 1)
+	if err != nil {
+		t.Fatalf("expect no error, got %v", err)
+	}
+	defer endpoints.GEndpoints.ReleaseEndpointCollectionFromList(gateways)
+	if coll == c1.Config.CEndpoint {
+		t.Errorf("expect collection to be released by Close")
+	}
+}
+
+func TestSessionCallerEndpointCollection(t *testing.T) {
+	restoreEnvFn := initSessionTestEnv()
+	defer restoreEnvFn()
+
+	coll, err := endpoints.NewEndpointCollectionFromList([]string{"http://abc1.test:8080"}, 1)
+	if err != nil {
+		t.Fatalf("expect no error, got %v", err)
+	}
+	defer coll.Close()
+
+	s, err := NewSession(&aws.Config{Region: aws.String("region")})
+	if err != nil {
+		t.Fatalf("expect no error, got %v", err)
+	}
+
+	c := s.ClientConfig("s3", aws.NewConfig().WithEndpointCollection(coll))
+	if c.Config.CEndpoint != coll {
+		t.Errorf("expect the collection of the caller")
+	}
+	if e, a := "http://abc1.test:8080", c.Endpoint; e != a {
+		t.Errorf("expect %v, got %v", e, a)
+	}
+
+	// the collection of the caller is not closed with the session
+	s.Close()
+	if e, a := "http://abc1.test:8080", coll.SelectEndpoint(nil).URL; e != a {
+		t.Errorf("expect %v, got %v", e, a)
+	}
+}
+
+func TestSessionEndpointLogger(t *testing.T) {
+	var logged []string
+	logger := aws.LoggerFunc(func(args ...interface{}) {
+		logged = append(logged, fmt.Sprint(args...))
+	})
+
+	cases := map[string]struct {
+		level  aws.LogLevelType
+		events bool
+	}{
+		"off":             {level: aws.LogOff},
+		"debug":           {level: aws.LogDebug},
+		"endpoint events": {level: aws.LogDebugWithEndpointEvents, events: true},
+	}
+	for name, c := range cases {
+		cfg := aws.NewConfig().WithLogger(logger).WithLogLevel(c.level)
+		var o endpoints.CollectionOptions
+		collectionOptions(cfg, "")(&o)
+		// the warnings of the collection are always logged
+		if o.Logger == nil {
+			t.Errorf("%s expect logger", name)
+			continue
+		}
+		if e, a := c.events, o.LogEvents; e != a {
+			t.Errorf("%s expect log events %v, got %v", name, e, a)
+		}
+		o.Logger(name)
+	}
+	sort.Strings(logged)
+	if e, a := "debug,endpoint events,off", strings.Join(logged, ","); e != a {
+		t.Errorf("expect %v, got %v", e, a)
+	}
+
+	var o endpoints.CollectionOptions
+	collectionOptions(aws.NewConfig(), "")(&o)
+	if o.Logger != nil {
+		t.Errorf("expect no logger without the Logger of the config")
+	}
+}
+
+func TestSessionEndpointConnectionOptions(t *testing.T) {
+	cfg := aws.NewConfig().
+		WithMaxConnectionsPerEndpoint(16).
+		WithMaxIdleConnsPerEndpoint(4).
+		WithProbeTransport(endpoints.TransportConfig{DialTimeout: time.Second}).
+		WithConcurrencyLimit(endpoints.ConcurrencyLimitConfig{InitialLimit: 8})
+
+	var o endpoints.CollectionOptions
+	collectionOptions(cfg, "")(&o)
+	if e, a := 16, o.MaxConnectionsPerEndpoint; e != a {
+		t.Errorf("expect %d, got %d", e, a)
+	}
+	if e, a := 4, o.MaxIdleConnsPerEndpoint; e != a {
+		t.Errorf("expect %d, got %d", e, a)
+	}
+	if e, a := time.Second, o.ProbeTransport.DialTimeout; e != a {
+		t.Errorf("expect %v, got %v", e, a)
+	}
+	if o.ConcurrencyLimit == nil || o.ConcurrencyLimit.InitialLimit != 8 {
+		t.Errorf("expect initial concurrency limit 8, got %+v", o.ConcurrencyLimit)
+	}
+}
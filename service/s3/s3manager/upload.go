@@ -12,6 +12,7 @@ import (
 	"github.com/aws/aws-sdk-go/aws/awsutil"
 	"github.com/aws/aws-sdk-go/aws/client"
 	"github.com/aws/aws-sdk-go/aws/credentials"
+	"github.com/aws/aws-sdk-go/aws/endpoints"
 	"github.com/aws/aws-sdk-go/aws/request"
 	"github.com/aws/aws-sdk-go/service/s3"
 	"github.com/aws/aws-sdk-go/service/s3/s3iface"
@@ -163,6 +164,12 @@ type Uploader struct {
 	// operation requests made by the uploader.
 	RequestOptions []request.Option
 
+	// Setting this value to true will send all the requests of one upload
+	// to the same endpoint of the client's endpoint collection. The upload
+	// only moves to another endpoint when that endpoint fails. Has no effect
+	// if the client has no endpoint collection.
+	EndpointAffinity bool
+
 	// Defines the buffer strategy used when uploading a part
 	BufferProvider ReadSeekerWriteToProvider
 
@@ -290,6 +297,11 @@ func (u Uploader) UploadWithContext(ctx aws.Context, input *UploadInput, opts ..
 	}
 
 	i.cfg.RequestOptions = append(i.cfg.RequestOptions, request.WithAppendUserAgent("S3Manager"))
+	if i.cfg.EndpointAffinity {
+		// a copy, the options of the Uploader are shared by its uploads
+		i.cfg.RequestOptions = append(append([]request.Option{}, i.cfg.RequestOptions...),
+			request.WithEndpointAffinity(endpoints.NewAffinity()))
+	}
 
 	return i.upload()
 }
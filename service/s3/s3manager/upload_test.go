@@ -23,6 +23,7 @@ import (
 	"github.com/aws/aws-sdk-go/aws"
 	"github.com/aws/aws-sdk-go/aws/awserr"
 	"github.com/aws/aws-sdk-go/aws/awsutil"
+	"github.com/aws/aws-sdk-go/aws/endpoints"
 	"github.com/aws/aws-sdk-go/aws/request"
 	"github.com/aws/aws-sdk-go/awstesting"
 	"github.com/aws/aws-sdk-go/awstesting/unit"
@@ -191,6 +192,59 @@ func TestUploadOrderMulti(t *testing.T) {
 	}
 }
 
+func TestUploadEndpointAffinity(t *testing.T) {
+	coll, err := endpoints.NewEndpointCollectionFromList([]string{
+		"http://abc1.test:8080", "http://abc2.test:8080", "http://abc3.test:8080",
+	}, 60)
+	if err != nil {
+		t.Fatalf("expect no error, got %v", err)
+	}
+	defer coll.Close()
+
+	var m sync.Mutex
+	urls := map[string]bool{}
+	s := s3.New(unit.Session, &aws.Config{CEndpoint: coll})
+	s.Handlers.Unmarshal.Clear()
+	s.Handlers.UnmarshalMeta.Clear()
+	s.Handlers.UnmarshalError.Clear()
+	s.Handlers.Send.Clear()
+	s.Handlers.Send.PushBack(func(r *request.Request) {
+		m.Lock()
+		defer m.Unlock()
+
+		urls[r.Endpoint.URL] = true
+		r.HTTPResponse = &http.Response{
+			StatusCode: 200,
+			Body:       ioutil.NopCloser(bytes.NewReader([]byte{})),
+		}
+		switch data := r.Data.(type) {
+		case *s3.CreateMultipartUploadOutput:
+			data.UploadId = aws.String("UPLOAD-ID")
+		case *s3.UploadPartOutput:
+			data.ETag = aws.String("ETAG")
+		}
+	})
+
+	u := s3manager.NewUploaderWithClient(s, func(u *s3manager.Uploader) {
+		u.PartSize = s3manager.MinUploadPartSize
+		u.EndpointAffinity = true
+	})
+	for i := 0; i < 3; i++ {
+		urls = map[string]bool{}
+		_, err := u.Upload(&s3manager.UploadInput{
+			Bucket: aws.String("Bucket"),
+			Key:    aws.String("Key"),
+			Body:   bytes.NewReader(buf12MB),
+		})
+		if err != nil {
+			t.Fatalf("%d expect no error, got %v", i, err)
+		}
+		if e, a := 1, len(urls); e != a {
+			t.Errorf("%d expect the upload sent to %d endpoint, got %v", i, e, urls)
+		}
+	}
+}
+
 func TestUploadOrderMultiDifferentPartSize(t *testing.T) {
 	s, ops, args := loggingSvc(emptyList)
 	mgr := s3manager.NewUploaderWithClient(s, func(u *s3manager.Uploader) {